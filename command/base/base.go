@@ -2,32 +2,116 @@ package base
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
-	"strings"
+	"slices"
+	"time"
 
 	"github.com/dihedron/archetype/repository"
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
 )
 
+// init makes the git SSH transport, which resolves HostName and Port with its
+// own ssh_config reader, read the same settings as the repository package.
+func init() {
+	ssh.DefaultSSHConfig = repository.SSHConfig
+}
+
 // Command provides a base structure for all commands, containing the common
 // command line options like the repository URL, the tag to use, and all
 // the authentication-related options.
 type Command struct {
-	URL              string   `short:"r" long:"repository" description:"The Git repository containing the template" required:"true" default:"." env:"ARCHETYPE_REPOSITORY_URL"`
-	Tag              *string  `short:"t" long:"tag" description:"The tag or commit to clone" optional:"true" default:"latest" env:"ARCHETYPE_REPOSITORY_TAG"`
-	Exclude          []string `short:"e" long:"exclude" description:"The pattern of files to exclude from processing" optional:"true" default:"" env:"ARCHETYPE_EXCLUDE"`
-	Include          []string `short:"i" long:"include" description:"The pattern of files to include from processing" optional:"true" default:"" env:"ARCHETYPE_INCLUDE"`
-	Token            *string  `short:"T" long:"token" description:"The personal access token for authentication" optional:"true" env:"ARCHETYPE_AUTH_TOKEN"`
-	Username         *string  `short:"U" long:"username" description:"The username for authentication" optional:"true" env:"ARCHETYPE_AUTH_USERNAME"`
-	Password         *string  `short:"P" long:"password" description:"The password for authentication" optional:"true" env:"ARCHETYPE_AUTH_PASSWORD"`
-	SSHKey           *string  `short:"K" long:"sshkey" description:"The SSH key for authentication" optional:"true" env:"ARCHETYPE_AUTH_SSH_KEY"`
-	UseDefaultSSHKey bool     `short:"D" long:"with-default-ssh-key" description:"Use default SSH key for authentication" optional:"true" env:"ARCHETYPE_AUTH_USE_DEFAULT_SSH_KEY"`
-	UseSSHAgent      bool     `short:"A" long:"with-ssh-agent" description:"Use SSH agent for authentication" optional:"true" env:"ARCHETYPE_AUTH_USE_SSH_AGENT"`
+	URLs          []string `short:"r" long:"repository" description:"The Git repository (URL, scp-like address, shorthand or local path) or archive containing the template; repeat it to give mirrors, tried in order if the first cannot be reached" required:"true" default:"." env:"ARCHETYPE_REPOSITORY_URL" env-delim:","`
+	URL           string   `no-flag:"true"`
+	Tag           *string  `short:"t" long:"tag" description:"The tag, branch, commit or revision expression to use" optional:"true" default:"latest" env:"ARCHETYPE_REPOSITORY_TAG"`
+	Exclude       []string `short:"e" long:"exclude" description:"The pattern of files to exclude from processing" optional:"true" default:"" env:"ARCHETYPE_EXCLUDE"`
+	Include       []string `short:"i" long:"include" description:"The pattern of files to include from processing" optional:"true" default:"" env:"ARCHETYPE_INCLUDE"`
+	Token         *string  `short:"T" long:"token" description:"The personal access token for authentication" optional:"true" env:"ARCHETYPE_AUTH_TOKEN"`
+	TokenFile     *string  `long:"token-file" description:"A file containing the personal access token for authentication" optional:"true" env:"ARCHETYPE_AUTH_TOKEN_FILE"`
+	TokenCommand  *string  `long:"token-command" description:"A shell command printing the personal access token for authentication" optional:"true" env:"ARCHETYPE_AUTH_TOKEN_COMMAND"`
+	TokenUsername string   `long:"token-username" description:"The username to send along with the token (e.g. for GitLab deploy tokens)" default:"git" env:"ARCHETYPE_AUTH_TOKEN_USERNAME"`
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	TokenType            string   `long:"token-type" description:"How to send the token: in HTTP basic authentication, or as a bearer token" choice:"basic" choice:"bearer" default:"basic" env:"ARCHETYPE_AUTH_TOKEN_TYPE"`
+	Username             *string  `short:"U" long:"username" description:"The username for authentication" optional:"true" env:"ARCHETYPE_AUTH_USERNAME"`
+	Password             *string  `short:"P" long:"password" description:"The password for authentication" optional:"true" env:"ARCHETYPE_AUTH_PASSWORD"`
+	SSHKey               *string  `short:"K" long:"sshkey" description:"The SSH key for authentication" optional:"true" env:"ARCHETYPE_AUTH_SSH_KEY"`
+	SSHKeyPassphraseFile *string  `long:"sshkey-passphrase-file" description:"A file containing the passphrase of the SSH key (also in ARCHETYPE_AUTH_SSH_KEY_PASSPHRASE, or asked for on the terminal)" optional:"true" env:"ARCHETYPE_AUTH_SSH_KEY_PASSPHRASE_FILE"`
+	UseDefaultSSHKey     bool     `short:"D" long:"with-default-ssh-key" description:"Use the SSH keys in ~/.ssh/config for the host, then ~/.ssh/id_ed25519, id_ecdsa and id_rsa, for authentication" optional:"true" env:"ARCHETYPE_AUTH_USE_DEFAULT_SSH_KEY"`
+	UseSSHAgent          bool     `short:"A" long:"with-ssh-agent" description:"Use SSH agent for authentication" optional:"true" env:"ARCHETYPE_AUTH_USE_SSH_AGENT"`
+	KnownHosts           []string `long:"known-hosts" description:"A known_hosts file to check the identity of SSH servers against (repeatable; default from ~/.ssh/config or ~/.ssh/known_hosts; requires an SSH authentication method)" env:"ARCHETYPE_AUTH_KNOWN_HOSTS" env-delim:","`
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	HostKeyPolicy         string   `long:"host-key-policy" description:"How to check the identity of SSH servers (default from StrictHostKeyChecking in ~/.ssh/config, or strict; requires an SSH authentication method)" choice:"strict" choice:"accept-new" choice:"fingerprint" env:"ARCHETYPE_AUTH_HOST_KEY_POLICY"`
+	HostKeyFingerprints   []string `long:"host-key-fingerprint" description:"A pinned SHA256 fingerprint of the SSH server key, for the fingerprint host key policy (repeatable)" env:"ARCHETYPE_AUTH_HOST_KEY_FINGERPRINTS" env-delim:","`
+	ProxyURL              string   `long:"proxy" description:"The proxy URL (http, https, socks5 or socks5h) for the repository and the api function (default from HTTP_PROXY, HTTPS_PROXY and ALL_PROXY, except the hosts in NO_PROXY)" env:"ARCHETYPE_PROXY"`
+	ProxyUser             *string  `long:"proxy-user" description:"The username for the proxy" optional:"true" env:"ARCHETYPE_PROXY_USER"`
+	ProxyPassword         *string  `long:"proxy-password" description:"The password for the proxy" optional:"true" env:"ARCHETYPE_PROXY_PASSWORD"`
+	CABundle              string   `long:"ca-bundle" description:"A PEM file with the certificates of additional trusted authorities for HTTPS, e.g. a private CA" env:"ARCHETYPE_CA_BUNDLE"`
+	ClientCert            string   `long:"client-cert" description:"A PEM file with the client certificate for servers requiring mutual TLS" env:"ARCHETYPE_CLIENT_CERT"`
+	ClientKey             string   `long:"client-key" description:"A PEM file with the key of the client certificate" env:"ARCHETYPE_CLIENT_KEY"`
+	InsecureSkipTLSVerify bool     `long:"insecure-skip-tls-verify" description:"Do not verify the certificates of HTTPS servers (insecure)" optional:"true" env:"ARCHETYPE_INSECURE_SKIP_TLS_VERIFY"`
+	NoCredentials         bool     `long:"no-credential-lookup" description:"Do not look up credentials in the git credential helpers and the netrc file" optional:"true" env:"ARCHETYPE_AUTH_NO_CREDENTIAL_LOOKUP"`
+	CacheDirectory        string   `long:"cache-dir" description:"The directory where remote repositories are cached" env:"ARCHETYPE_CACHE_DIR"`
+	NoCache               bool     `long:"no-cache" description:"Clone remote repositories into memory instead of using the cache" optional:"true" env:"ARCHETYPE_NO_CACHE"`
+	Offline               bool     `long:"offline" description:"Resolve tags and commits from the cache alone, without contacting the remote" optional:"true" env:"ARCHETYPE_OFFLINE"`
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Fetch            string            `long:"fetch" description:"The fetch strategy: shallow fetches only the selected tag or branch, into memory and bypassing the clone cache unless offline; auto does so unless the clone cache is enabled" choice:"auto" choice:"full" choice:"shallow" default:"auto" env:"ARCHETYPE_FETCH"`
+	PreRelease       bool              `long:"pre-release" description:"Consider pre-release tags when resolving version constraints" optional:"true" env:"ARCHETYPE_PRE_RELEASE"`
+	VerifySignatures bool              `long:"verify-signatures" description:"Refuse to use commits or tags that are not signed by a trusted key" optional:"true" env:"ARCHETYPE_VERIFY_SIGNATURES"`
+	Keyring          []string          `long:"keyring" description:"A file with trusted OpenPGP armored public keys or SSH allowed signers (repeatable)" env:"ARCHETYPE_KEYRING" env-delim:","`
+	Sparse           string            `long:"sparse" description:"Restrict the files in the archetype to those under the given path prefix" env:"ARCHETYPE_SPARSE"`
+	Path             string            `long:"path" description:"The subdirectory of the repository holding the archetype (also as repo//subdir)" env:"ARCHETYPE_PATH"`
+	NoSubmodules     bool              `long:"no-submodules" description:"Do not fetch the submodules in the archetype repository" optional:"true" env:"ARCHETYPE_NO_SUBMODULES"`
+	LFSStore         string            `long:"lfs-store" description:"A local Git LFS store (e.g. the .git/lfs directory of a clone) to look up LFS objects in before fetching them" env:"ARCHETYPE_LFS_STORE"`
+	NoLFS            bool              `long:"no-lfs" description:"Leave Git LFS pointer files as they are instead of fetching the actual files" optional:"true" env:"ARCHETYPE_NO_LFS"`
+	Shorthands       map[string]string `long:"shorthand" description:"A host shorthand for repository addresses, as name=prefix (repeatable)" key-value-delimiter:"=" env:"ARCHETYPE_SHORTHANDS" env-delim:","`
+	MirrorRules      []string          `long:"mirror" description:"A mirror for the repositories whose address starts with a prefix, as prefix=replacement, tried in order if they cannot be reached; online, the tag or branch the revision resolves to, or is based on (e.g. main~1), must be the same on all of them when a mirror is used (repeatable)" env:"ARCHETYPE_MIRRORS" env-delim:","`
+	VerifyMirrors    bool              `long:"verify-mirrors" description:"Check that the mirrors agree on the tag or branch even when the repository can be reached" optional:"true" env:"ARCHETYPE_VERIFY_MIRRORS"`
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Progress string        `long:"progress" description:"How to report progress: a progress bar on terminals and plain lines otherwise (auto), always plain lines, or JSON lines for CI" choice:"auto" choice:"bar" choice:"plain" choice:"json" default:"auto" env:"ARCHETYPE_PROGRESS"`
+	Timeout  time.Duration `long:"timeout" description:"The maximum time the command may take, e.g. 90s or 5m (no limit by default)" env:"ARCHETYPE_TIMEOUT"`
+	Quiet    bool          `short:"q" long:"quiet" description:"Do not report progress, only errors" optional:"true" env:"ARCHETYPE_QUIET"`
+	// mirrors are the normalised addresses of the mirrors of the repository.
+	mirrors []string
+}
+
+// NormaliseURL normalises the repository addresses on the command line, so
+// that scp-like addresses, local paths and host shorthands are accepted
+// wherever a URL is, and unsupported schemes are reported early; the first is
+// the repository, the others and those given by the mirror rules are its
+// mirrors.
+func (cmd *Command) NormaliseURL() error {
+	addresses := []string{}
+	for _, url := range cmd.URLs {
+		address, err := repository.NormaliseAddress(url, cmd.Shorthands)
+		if err != nil {
+			slog.Error("invalid repository address", "url", url, "error", err)
+			return fmt.Errorf("invalid repository address '%s': %w", url, err)
+		}
+		addresses = append(addresses, address)
+	}
+	cmd.URL, cmd.mirrors = addresses[0], addresses[1:]
+	rules := []repository.Mirror{}
+	for _, rule := range cmd.MirrorRules {
+		mirror, err := repository.ParseMirror(rule)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, mirror)
+	}
+	for _, mirror := range repository.Mirrors(cmd.URL, rules) {
+		if !slices.Contains(cmd.mirrors, mirror) {
+			cmd.mirrors = append(cmd.mirrors, mirror)
+		}
+	}
+	return nil
 }
 
 // HasAuthOptions checks whether any authentication options have been provided.
 func (cmd *Command) HasAuthOptions() bool {
 	return cmd.Username != nil && cmd.Password != nil ||
 		cmd.Token != nil ||
+		cmd.TokenFile != nil ||
+		cmd.TokenCommand != nil ||
 		cmd.SSHKey != nil ||
 		cmd.UseDefaultSSHKey ||
 		cmd.UseSSHAgent
@@ -35,22 +119,31 @@ func (cmd *Command) HasAuthOptions() bool {
 
 // AuthenticationOpts extracts authentication data from the command line options
 // and creates the repository.Option needed to configure authenticated requests
-// against the remote repository.
+// against the remote repository; if none are given, the credentials for HTTP
+// repositories are looked up in the git credential helpers and in the netrc
+// file, so that secrets need not be passed on the command line.
 func (cmd *Command) AuthenticationOpts() (repository.Option, error) {
 	if !cmd.HasAuthOptions() {
-		slog.Info("using anonymous authentication")
-		return nil, nil
+		return cmd.lookupCredentials()
 	}
-	if cmd.Token != nil {
-		if strings.HasPrefix(cmd.URL, "http") {
-			slog.Info("using token for authentication")
-			return repository.WithTokenAuth(*cmd.Token), nil
+	if cmd.Token != nil || cmd.TokenFile != nil || cmd.TokenCommand != nil {
+		if repository.IsHTTPAddress(cmd.URL) {
+			token, err := cmd.token()
+			if err != nil {
+				return nil, err
+			}
+			if cmd.TokenType == "bearer" {
+				slog.Info("using bearer token for authentication")
+				return repository.WithBearerAuth(token), nil
+			}
+			slog.Info("using token for authentication", "username", cmd.TokenUsername)
+			return repository.WithTokenAuth(token, cmd.TokenUsername), nil
 		} else {
 			slog.Error("token authentication is only supported for HTTP repositories")
 			return nil, errors.New("token authentication is only supported for HTTP repositories")
 		}
 	} else if cmd.Password != nil && cmd.Username != nil {
-		if strings.HasPrefix(cmd.URL, "http") {
+		if repository.IsHTTPAddress(cmd.URL) {
 			slog.Info("using username and password for authentication")
 			return repository.WithBasicAuth(*cmd.Username, *cmd.Password), nil
 		} else {
@@ -58,25 +151,25 @@ func (cmd *Command) AuthenticationOpts() (repository.Option, error) {
 			return nil, errors.New("username and password authentication is only supported for HTTP repositories")
 		}
 	} else if cmd.SSHKey != nil {
-		if strings.HasPrefix(cmd.URL, "ssh://") || strings.HasPrefix(cmd.URL, "git@") {
+		if repository.IsSSHAddress(cmd.URL) {
 			slog.Info("using SSH key for authentication")
-			return repository.WithSSHKey(*cmd.SSHKey, nil), nil
+			return cmd.withHostKeys(repository.WithSSHKey(*cmd.SSHKey, cmd.Passphrase())), nil
 		} else {
 			slog.Error("SSH key authentication is only supported for SSH repositories")
 			return nil, errors.New("SSH key authentication is only supported for SSH repositories")
 		}
 	} else if cmd.UseDefaultSSHKey {
-		if strings.HasPrefix(cmd.URL, "ssh://") || strings.HasPrefix(cmd.URL, "git@") {
+		if repository.IsSSHAddress(cmd.URL) {
 			slog.Info("using default SSH key for authentication")
-			return repository.WithDefaultSSHKey(), nil
+			return cmd.withHostKeys(repository.WithDefaultSSHKey(cmd.Passphrase())), nil
 		} else {
 			slog.Error("SSH key authentication is only supported for SSH repositories")
 			return nil, errors.New("SSH key authentication is only supported for SSH repositories")
 		}
 	} else if cmd.UseSSHAgent {
-		if strings.HasPrefix(cmd.URL, "ssh://") || strings.HasPrefix(cmd.URL, "git@") {
+		if repository.IsSSHAddress(cmd.URL) {
 			slog.Info("using SSH agent for authentication")
-			return repository.WithSSHAgent(), nil
+			return cmd.withHostKeys(repository.WithSSHAgent()), nil
 		} else {
 			slog.Error("SSH agent authentication is only supported for SSH repositories")
 			return nil, errors.New("SSH agent authentication is only supported for SSH repositories")
@@ -85,3 +178,173 @@ func (cmd *Command) AuthenticationOpts() (repository.Option, error) {
 	slog.Info("using anonymous authentication")
 	return nil, nil
 }
+
+// token returns the personal access token from the command line, the token
+// file or the token command, in this order of precedence.
+func (cmd *Command) token() (string, error) {
+	switch {
+	case cmd.Token != nil:
+		return *cmd.Token, nil
+	case cmd.TokenFile != nil:
+		slog.Debug("reading token from file", "path", *cmd.TokenFile)
+		return repository.ReadTokenFile(*cmd.TokenFile)
+	default:
+		slog.Debug("reading token from command")
+		return repository.RunTokenCommand(*cmd.TokenCommand)
+	}
+}
+
+// withHostKeys adds the known_hosts files and host key policy on the command
+// line, if any, to the given SSH authentication option.
+func (cmd *Command) withHostKeys(auth repository.Option) repository.Option {
+	options := []repository.Option{auth}
+	if len(cmd.KnownHosts) > 0 {
+		options = append(options, repository.WithKnownHosts(cmd.KnownHosts...))
+	}
+	if cmd.HostKeyPolicy != "" {
+		options = append(options, repository.WithHostKeyPolicy(repository.HostKeyPolicy(cmd.HostKeyPolicy), cmd.HostKeyFingerprints...))
+	}
+	return func(r *repository.Repository) error {
+		for _, option := range options {
+			if err := option(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// lookupCredentials looks up the credentials for HTTP repositories in the git
+// credential helpers first and in the netrc file then, unless disabled; it
+// falls back to anonymous authentication if none are found. The host key
+// options only apply to an SSH authentication method, so giving them for an
+// SSH repository without one is an error.
+func (cmd *Command) lookupCredentials() (repository.Option, error) {
+	if repository.IsSSHAddress(cmd.URL) && (len(cmd.KnownHosts) > 0 || cmd.HostKeyPolicy != "") {
+		option := "--known-hosts"
+		if len(cmd.KnownHosts) == 0 {
+			option = "--host-key-policy"
+		}
+		slog.Error("host key options given without an SSH authentication method", "option", option)
+		return nil, fmt.Errorf("%s requires --with-ssh-agent, --with-default-ssh-key or --sshkey", option)
+	}
+	if cmd.NoCredentials || !repository.IsHTTPAddress(cmd.URL) {
+		slog.Info("using anonymous authentication")
+		return nil, nil
+	}
+	return cmd.credentials(cmd.URL)
+}
+
+// credentials looks up the credentials for the given HTTP address in the git
+// credential helpers first and in the netrc file then; it returns nil if
+// there are none.
+func (cmd *Command) credentials(address string) (repository.Option, error) {
+	username := ""
+	if cmd.Username != nil {
+		username = *cmd.Username
+	}
+	for _, lookup := range []func(string, string) (*repository.Credentials, error){repository.CredentialHelper, repository.Netrc} {
+		credentials, err := lookup(address, username)
+		if err != nil {
+			slog.Error("error looking up credentials", "error", err)
+			return nil, fmt.Errorf("error looking up credentials: %w", err)
+		}
+		if credentials != nil {
+			slog.Info("using credentials for authentication", "address", address, "source", credentials.Source, "username", credentials.Username)
+			return repository.WithBasicAuth(credentials.Username, credentials.Password), nil
+		}
+	}
+	slog.Info("no credentials found, using anonymous authentication", "address", address)
+	return nil, nil
+}
+
+// CacheOpts creates the repository.Options needed to use the persistent clone
+// cache, unless disabled on the command line.
+func (cmd *Command) CacheOpts() ([]repository.Option, error) {
+	if cmd.NoCache {
+		if cmd.Offline {
+			slog.Error("offline mode requires the clone cache")
+			return nil, errors.New("offline mode cannot be used together with --no-cache")
+		}
+		slog.Info("clone cache disabled")
+		return nil, nil
+	}
+	cache, err := repository.NewCache(cmd.CacheDirectory)
+	if err != nil {
+		slog.Error("error opening clone cache", "directory", cmd.CacheDirectory, "error", err)
+		return nil, err
+	}
+	options := []repository.Option{
+		repository.WithCache(cache),
+	}
+	if cmd.Offline {
+		slog.Info("using offline mode")
+		options = append(options, repository.WithOffline())
+	}
+	return options, nil
+}
+
+// FetchOpts creates the repository.Options implementing the selected fetch
+// strategy for the given tag, the handling of pre-releases in version
+// constraints, the archetype path, the optional sparse path restriction and
+// whether submodules are fetched.
+func (cmd *Command) FetchOpts(tag string) []repository.Option {
+	options := []repository.Option{}
+	switch cmd.Fetch {
+	case "shallow":
+		slog.Info("using shallow fetch strategy", "tag", tag)
+		options = append(options, repository.WithShallow(tag))
+	case "auto":
+		if cmd.NoCache {
+			slog.Info("using shallow fetch strategy (clone cache disabled)", "tag", tag)
+			options = append(options, repository.WithShallow(tag))
+		} else {
+			slog.Info("using full fetch strategy (clone cache enabled)")
+		}
+	default:
+		slog.Info("using full fetch strategy")
+	}
+	if cmd.PreRelease {
+		slog.Info("including pre-releases in version constraints")
+		options = append(options, repository.WithPreReleases())
+	}
+	if cmd.Path != "" {
+		slog.Info("rooting the archetype at path", "path", cmd.Path)
+		options = append(options, repository.WithPath(cmd.Path))
+	}
+	if cmd.Sparse != "" {
+		slog.Info("restricting files to path prefix", "prefix", cmd.Sparse)
+		options = append(options, repository.WithSparse(cmd.Sparse))
+	}
+	if cmd.NoSubmodules {
+		slog.Info("not fetching submodules")
+		options = append(options, repository.WithoutSubmodules())
+	}
+	if cmd.NoLFS {
+		slog.Info("not fetching Git LFS objects")
+		options = append(options, repository.WithoutLFS())
+	} else if cmd.LFSStore != "" {
+		slog.Info("using local Git LFS store", "directory", cmd.LFSStore)
+		options = append(options, repository.WithLFSStore(cmd.LFSStore))
+	}
+	return options
+}
+
+// VerifySignature checks, if requested on the command line, that the resolved
+// annotated tag or commit is signed by one of the trusted keys in the keyring.
+func (cmd *Command) VerifySignature(resolution *repository.Resolution) (*repository.Verification, error) {
+	if !cmd.VerifySignatures {
+		slog.Debug("signature verification not requested")
+		return nil, nil
+	}
+	if len(cmd.Keyring) == 0 {
+		slog.Error("signature verification requires a keyring")
+		return nil, errors.New("signature verification requires at least one --keyring")
+	}
+	keyring, err := repository.LoadKeyring(cmd.Keyring...)
+	if err != nil {
+		slog.Error("error loading keyring", "error", err)
+		return nil, err
+	}
+	return keyring.Verify(resolution)
+}
@@ -0,0 +1,29 @@
+package base
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuthenticationOptsHostKeys(t *testing.T) {
+	// host key options without an SSH authentication method are refused
+	for option, cmd := range map[string]*Command{
+		"--known-hosts":     {URL: "ssh://git@example.com/archetype.git", KnownHosts: []string{"known_hosts"}},
+		"--host-key-policy": {URL: "git@example.com:archetype.git", HostKeyPolicy: "accept-new"},
+	} {
+		if _, err := cmd.AuthenticationOpts(); err == nil || !strings.HasPrefix(err.Error(), option+" requires") {
+			t.Errorf("expected %s to require an SSH authentication method, got %v", option, err)
+		}
+	}
+
+	// they are accepted along with one, and ignored for other repositories
+	for _, cmd := range []*Command{
+		{URL: "ssh://git@example.com/archetype.git", KnownHosts: []string{"known_hosts"}, UseSSHAgent: true},
+		{URL: "ssh://git@example.com/archetype.git", HostKeyPolicy: "strict", UseDefaultSSHKey: true},
+		{URL: "https://example.com/archetype.git", HostKeyPolicy: "strict", NoCredentials: true},
+	} {
+		if _, err := cmd.AuthenticationOpts(); err != nil {
+			t.Errorf("unexpected error for %s: %v", cmd.URL, err)
+		}
+	}
+}
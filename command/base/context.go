@@ -0,0 +1,77 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const (
+	// ExitInterrupted is the exit status when the command is interrupted by
+	// SIGINT (e.g. Ctrl-C) or SIGTERM, as for shells.
+	ExitInterrupted = 130
+	// ExitTimeout is the exit status when the command exceeds its timeout, as
+	// for the timeout utility.
+	ExitTimeout = 124
+)
+
+var (
+	// ErrInterrupted is the error returned when the command is interrupted by
+	// a signal.
+	ErrInterrupted = errors.New("interrupted")
+	// ErrTimeout is the error returned when the command exceeds its timeout.
+	ErrTimeout = errors.New("timed out")
+)
+
+// Context returns the context bounding the command: it is cancelled with
+// ErrInterrupted on SIGINT or SIGTERM, and with ErrTimeout once the timeout
+// on the command line, if any, expires. Further signals terminate the process
+// as usual. The returned function releases the resources of the context.
+func (cmd *Command) Context() (context.Context, context.CancelFunc) {
+	interruptible, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case received := <-signals:
+			slog.Warn("signal received, stopping", "signal", received.String())
+			cancel(fmt.Errorf("%w by %s", ErrInterrupted, received))
+		case <-interruptible.Done():
+		}
+		signal.Stop(signals)
+	}()
+	if cmd.Timeout <= 0 {
+		return interruptible, func() { cancel(nil) }
+	}
+	ctx, stop := context.WithTimeoutCause(interruptible, cmd.Timeout, fmt.Errorf("%w after %s", ErrTimeout, cmd.Timeout))
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// Stopped returns the reason why the context is done, if it is, and nil
+// otherwise, so that commands report the interruption or timeout rather than
+// the errors it caused.
+func Stopped(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
+
+// ExitStatus returns the exit status for the given error returned by a
+// command: ExitInterrupted, ExitTimeout, or 1 for any other error.
+func ExitStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInterrupted):
+		return ExitInterrupted
+	case errors.Is(err, ErrTimeout):
+		return ExitTimeout
+	}
+	return 1
+}
@@ -0,0 +1,31 @@
+package base
+
+import (
+	"log/slog"
+
+	"github.com/dihedron/archetype/repository"
+)
+
+// MirrorOpts creates the repository.Options adding the mirrors of the
+// repository, given on the command line either as further repository
+// addresses or through the mirror rules, along with the credentials for those
+// reached over HTTP, which are looked up for each mirror unless disabled; the
+// mirrors are checked even if the repository can be reached if requested.
+func (cmd *Command) MirrorOpts() ([]repository.Option, error) {
+	options := []repository.Option{}
+	for _, mirror := range cmd.mirrors {
+		var auth repository.Option
+		if !cmd.NoCredentials && repository.IsHTTPAddress(mirror) {
+			var err error
+			if auth, err = cmd.credentials(mirror); err != nil {
+				return nil, err
+			}
+		}
+		slog.Info("using mirror if the repository cannot be reached", "address", cmd.URL, "mirror", mirror)
+		options = append(options, repository.WithMirror(mirror, auth))
+	}
+	if cmd.VerifyMirrors && len(options) > 0 {
+		options = append(options, repository.WithMirrorVerification())
+	}
+	return options, nil
+}
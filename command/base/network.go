@@ -0,0 +1,70 @@
+package base
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/dihedron/archetype/repository"
+)
+
+// Proxy returns the proxy configuration on the command line, or the one in the
+// environment if there is none.
+func (cmd *Command) Proxy() *repository.Proxy {
+	proxy := &repository.Proxy{}
+	if cmd.ProxyURL != "" {
+		proxy.URL = cmd.ProxyURL
+	}
+	if cmd.ProxyUser != nil {
+		proxy.Username = *cmd.ProxyUser
+	}
+	if cmd.ProxyPassword != nil {
+		proxy.Password = *cmd.ProxyPassword
+	}
+	return proxy
+}
+
+// ProxyOpts creates the repository.Option needed to reach the repository
+// through the proxy on the command line or in the environment, if any.
+func (cmd *Command) ProxyOpts() repository.Option {
+	proxy := cmd.Proxy()
+	if proxy.URL == "" {
+		slog.Debug("using proxy from environment, if any")
+		return repository.WithProxyFromEnv()
+	}
+	slog.Info("using proxy from command line", "username", proxy.Username)
+	return repository.WithProxy(proxy.URL, proxy.Username, proxy.Password)
+}
+
+// TLS loads the CA bundle and client certificate on the command line, if any.
+func (cmd *Command) TLS() (*repository.TLS, error) {
+	return repository.LoadTLS(cmd.CABundle, cmd.ClientCert, cmd.ClientKey, cmd.InsecureSkipTLSVerify)
+}
+
+// TLSOpts creates the repository.Option needed to apply the TLS settings on
+// the command line, if any, to HTTPS repositories.
+func (cmd *Command) TLSOpts() (repository.Option, error) {
+	settings, err := cmd.TLS()
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, nil
+	}
+	return repository.WithTLS(settings), nil
+}
+
+// HTTPClient returns the HTTP client for the requests made by the templates,
+// e.g. through the api function, with the same proxy and TLS settings as the
+// git transport; the proxy URL is validated when opening the repository.
+func (cmd *Command) HTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = cmd.Proxy().HTTP()
+	settings, err := cmd.TLS()
+	if err != nil {
+		return nil, err
+	}
+	if settings != nil {
+		transport.TLSClientConfig = settings.Config()
+	}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,56 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/dihedron/archetype/repository"
+	"golang.org/x/term"
+)
+
+// PassphraseEnv is the environment variable holding the passphrase of the SSH
+// key; it is not a command line option so as not to leak it into the shell
+// history and the process list.
+const PassphraseEnv = "ARCHETYPE_AUTH_SSH_KEY_PASSPHRASE"
+
+// Passphrase returns the function providing the passphrase of the SSH key, if
+// it is protected by one: it is read from the passphrase file, if given, or
+// from the environment, or else asked for on the terminal without echoing it.
+func (cmd *Command) Passphrase() repository.Passphrase {
+	return func(path string) ([]byte, error) {
+		if cmd.SSHKeyPassphraseFile != nil {
+			slog.Debug("reading SSH key passphrase from file", "path", *cmd.SSHKeyPassphraseFile)
+			data, err := os.ReadFile(*cmd.SSHKeyPassphraseFile)
+			if err != nil {
+				slog.Error("failed to read SSH key passphrase file", "path", *cmd.SSHKeyPassphraseFile, "error", err)
+				return nil, fmt.Errorf("cannot read passphrase file: %w", err)
+			}
+			return []byte(strings.TrimRight(string(data), "\r\n")), nil
+		}
+		if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+			slog.Debug("reading SSH key passphrase from environment", "variable", PassphraseEnv)
+			return []byte(passphrase), nil
+		}
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			// e.g. on Windows, or when there is no controlling terminal
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				slog.Error("SSH key passphrase required but no terminal to ask for it", "path", path)
+				return nil, fmt.Errorf("no terminal to ask for the passphrase (use --sshkey-passphrase-file or %s)", PassphraseEnv)
+			}
+			fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", path)
+			defer fmt.Fprintln(os.Stderr)
+			return term.ReadPassword(int(os.Stdin.Fd()))
+		}
+		defer tty.Close()
+		if !term.IsTerminal(int(tty.Fd())) {
+			return nil, errors.New("no terminal to ask for the passphrase")
+		}
+		fmt.Fprintf(tty, "Enter passphrase for key '%s': ", path)
+		defer fmt.Fprintln(tty)
+		return term.ReadPassword(int(tty.Fd()))
+	}
+}
@@ -0,0 +1,27 @@
+package base
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/dihedron/archetype/progress"
+	"golang.org/x/term"
+)
+
+// Reporter returns the progress reporter selected on the command line, writing
+// to the given file: none in quiet mode, JSON lines, plain lines, or a progress
+// bar when the file is a terminal.
+func (cmd *Command) Reporter(file *os.File) progress.Reporter {
+	switch {
+	case cmd.Quiet:
+		return progress.Discard
+	case cmd.Progress == "json":
+		return progress.JSON(file)
+	case cmd.Progress == "plain":
+		return progress.Plain(file)
+	case cmd.Progress == "bar" || term.IsTerminal(int(file.Fd())):
+		return progress.Bar(file)
+	}
+	slog.Debug("not a terminal, reporting progress as plain lines", "file", file.Name())
+	return progress.Plain(file)
+}
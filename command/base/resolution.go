@@ -0,0 +1,42 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dihedron/archetype/repository"
+)
+
+// Describe returns a description of how the requested tag was resolved,
+// formatted as YAML comments so that it can precede the YAML settings on the
+// standard output without invalidating them.
+func Describe(resolution *repository.Resolution) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# revision: %s\n", resolution.Revision)
+	if resolution.Reference != nil && resolution.Reference.Name().IsTag() {
+		fmt.Fprintf(&builder, "# tag: %s\n", resolution.Reference.Name().Short())
+	} else if resolution.Reference != nil && resolution.Reference.Name().IsBranch() {
+		fmt.Fprintf(&builder, "# branch: %s\n", resolution.Reference.Name().Short())
+	}
+	if resolution.Version != nil {
+		fmt.Fprintf(&builder, "# version: %s\n", resolution.Version.String())
+	}
+	fmt.Fprintf(&builder, "# commit: %s\n", resolution.Commit.Hash.String())
+	if tag := resolution.Annotation; tag != nil {
+		fmt.Fprintf(&builder, "# tagger: %s <%s>\n", tag.Tagger.Name, tag.Tagger.Email)
+		fmt.Fprintf(&builder, "# date: %s\n", tag.Tagger.When.Format(time.RFC3339))
+		if format := repository.SignatureFormat(tag.PGPSignature); format != "" {
+			fmt.Fprintf(&builder, "# signature: %s\n", format)
+		} else {
+			fmt.Fprintf(&builder, "# signature: none\n")
+		}
+		if message := strings.TrimSpace(tag.Message); message != "" {
+			fmt.Fprintf(&builder, "# release notes:\n")
+			for _, line := range strings.Split(message, "\n") {
+				fmt.Fprintf(&builder, "%s\n", strings.TrimRight("#   "+line, " "))
+			}
+		}
+	}
+	return builder.String()
+}
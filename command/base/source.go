@@ -0,0 +1,83 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/dihedron/archetype/progress"
+	"github.com/dihedron/archetype/repository"
+)
+
+// Source opens the archetype source selected on the command line, using the
+// given options: plain directories and local tar or zip archives are used as
+// they are, while git repositories are cloned (or fetched into the cache), the
+// tag is resolved to a commit, checked against the mirrors if any (falling
+// back to them if the repository cannot be reached) and, if requested, its
+// signature is verified.
+// Besides the source, it returns a description of what was selected formatted
+// as YAML comments (see Describe). The context bounds the clone or fetch; the
+// outcome of the signature verification is reported to the given reporter.
+func (cmd *Command) Source(ctx context.Context, options []repository.Option, reporter progress.Reporter) (repository.Source, string, error) {
+	tag := "latest"
+	if cmd.Tag != nil {
+		tag = *cmd.Tag
+	}
+
+	// plain directories and local archives have no revisions nor signatures
+	if repository.IsPlainSource(cmd.URL) {
+		if cmd.VerifySignatures {
+			slog.Error("signature verification requires a git repository", "url", cmd.URL)
+			return nil, "", errors.New("signature verification requires a git repository")
+		}
+		if tag != "latest" {
+			slog.Warn("tag ignored for plain directories and archives", "url", cmd.URL, "tag", tag)
+		}
+		if len(cmd.mirrors) > 0 {
+			slog.Warn("mirrors ignored for plain directories and archives", "url", cmd.URL, "mirrors", len(cmd.mirrors))
+		}
+		source, err := repository.OpenSource(cmd.URL, options...)
+		if err != nil {
+			slog.Error("failed to open archetype source", "url", cmd.URL, "error", err)
+			return nil, "", fmt.Errorf("failed to open archetype source '%s': %w", cmd.URL, err)
+		}
+		return source, fmt.Sprintf("# source: %s\n", source.String()), nil
+	}
+
+	// clone (or fetch into the cache) the remote archetypal repository
+	repo, err := repository.New(ctx, cmd.URL, options...)
+	if err != nil {
+		slog.Error("failed to clone remote repository", "url", cmd.URL, "error", err)
+		return nil, "", fmt.Errorf("failed to clone remote repository '%s': %w", cmd.URL, err)
+	}
+
+	// checkout the specified tag
+	resolution, err := repo.Resolve(ctx, tag)
+	if err != nil {
+		slog.Error("failed to get commit for input tag", "tag", tag, "error", err)
+		return nil, "", fmt.Errorf("failed to get commit for input tag '%s': %w", tag, err)
+	}
+
+	// check that the mirrors agree on the tag or branch, whichever served it
+	if err := repo.VerifyMirrors(ctx, resolution); err != nil {
+		slog.Error("mirror verification failed", "tag", tag, "error", err)
+		return nil, "", fmt.Errorf("mirror verification failed for '%s': %w", tag, err)
+	}
+
+	// verify the signature of the tag or commit, if requested
+	if verification, err := cmd.VerifySignature(resolution); err != nil {
+		slog.Error("signature verification failed", "tag", tag, "error", err)
+		return nil, "", fmt.Errorf("signature verification failed for '%s': %w", tag, err)
+	} else if verification != nil {
+		slog.Info("signature verified", "format", verification.Format, "object", verification.Object, "hash", verification.Hash.String(), "signer", verification.Signer)
+		progress.Print(reporter, fmt.Sprintf("%s signature on %s %s verified (signed by %s)", verification.Format, verification.Object, verification.Hash.String(), verification.Signer))
+	}
+
+	description := Describe(resolution)
+	if repo.IsMirror() {
+		slog.Info("archetype fetched from mirror", "url", cmd.URL, "mirror", repo.Address())
+		description = fmt.Sprintf("# mirror: %s\n", repo.Address()) + description
+	}
+	return repo.Source(resolution.Commit), description, nil
+}
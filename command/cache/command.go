@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dihedron/archetype/printf"
+	"github.com/dihedron/archetype/repository"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Cache is the command to manage the persistent clone cache; it has no action
+// of its own and only groups the list, prune and clear subcommands.
+type Cache struct {
+	// List lists the repositories in the cache.
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	List List `command:"list" alias:"ls" alias:"l" description:"List the repositories in the clone cache"`
+	// Prune removes the repositories that have not been used recently.
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Prune Prune `command:"prune" alias:"p" description:"Remove the repositories that have not been used recently from the clone cache"`
+	// Clear removes all the repositories from the cache.
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Clear Clear `command:"clear" alias:"c" description:"Remove all the repositories from the clone cache"`
+}
+
+// Options contains the options common to all the cache subcommands.
+type Options struct {
+	Directory string `long:"cache-dir" description:"The directory where remote repositories are cached" env:"ARCHETYPE_CACHE_DIR"`
+}
+
+// List is the command to list the repositories in the cache.
+type List struct {
+	Options
+}
+
+// Execute is the main entry point for the cache list command.
+func (cmd *List) Execute(args []string) error {
+	slog.Info("executing Cache List command")
+	cache, err := repository.NewCache(cmd.Directory)
+	if err != nil {
+		slog.Error("failed to open clone cache", "error", err)
+		return fmt.Errorf("failed to open clone cache: %w", err)
+	}
+	entries, err := cache.Entries()
+	if err != nil {
+		slog.Error("failed to list clone cache entries", "error", err)
+		return fmt.Errorf("failed to list clone cache entries: %w", err)
+	}
+	writer := table.NewWriter()
+	writer.SetOutputMirror(os.Stdout)
+	writer.AppendHeader(table.Row{"Key", "URL", "Size", "Last Used"})
+	for _, entry := range entries {
+		writer.AppendRow(table.Row{entry.Key[:12], entry.URL, size(entry.Size), entry.LastUsed.Format(time.DateTime)})
+	}
+	writer.SetStyle(table.StyleLight)
+	writer.Render()
+	fmt.Printf("cache directory: %s\n", printf.Blue(cache.Directory()))
+	return nil
+}
+
+// Prune is the command to remove the repositories that have not been used
+// for longer than a given amount of time.
+type Prune struct {
+	Options
+	OlderThan time.Duration `short:"o" long:"older-than" description:"Remove repositories not used for longer than this" default:"720h"`
+}
+
+// Execute is the main entry point for the cache prune command.
+func (cmd *Prune) Execute(args []string) error {
+	slog.Info("executing Cache Prune command", "older-than", cmd.OlderThan)
+	cache, err := repository.NewCache(cmd.Directory)
+	if err != nil {
+		slog.Error("failed to open clone cache", "error", err)
+		return fmt.Errorf("failed to open clone cache: %w", err)
+	}
+	removed, err := cache.Prune(cmd.OlderThan)
+	for _, entry := range removed {
+		fmt.Printf("removed %s (%s, last used %s)\n", printf.Green(entry.URL), size(entry.Size), entry.LastUsed.Format(time.DateTime))
+	}
+	if err != nil {
+		slog.Error("failed to prune clone cache", "error", err)
+		return fmt.Errorf("failed to prune clone cache: %w", err)
+	}
+	fmt.Printf("%d repositories removed from cache\n", len(removed))
+	return nil
+}
+
+// Clear is the command to remove all the repositories from the cache.
+type Clear struct {
+	Options
+}
+
+// Execute is the main entry point for the cache clear command.
+func (cmd *Clear) Execute(args []string) error {
+	slog.Info("executing Cache Clear command")
+	cache, err := repository.NewCache(cmd.Directory)
+	if err != nil {
+		slog.Error("failed to open clone cache", "error", err)
+		return fmt.Errorf("failed to open clone cache: %w", err)
+	}
+	if err := cache.Clear(); err != nil {
+		slog.Error("failed to clear clone cache", "error", err)
+		return fmt.Errorf("failed to clear clone cache: %w", err)
+	}
+	fmt.Printf("cache %s cleared\n", printf.Blue(cache.Directory()))
+	return nil
+}
+
+// size returns a human readable representation of the given size in bytes.
+func size(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
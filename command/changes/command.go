@@ -0,0 +1,254 @@
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/dihedron/archetype/command/base"
+	"github.com/dihedron/archetype/printf"
+	"github.com/dihedron/archetype/repository"
+	"github.com/dihedron/archetype/settings"
+	"gopkg.in/yaml.v3"
+)
+
+// Changes is the command to show what changed in an archetype between two
+// versions: its parameters and its template files.
+type Changes struct {
+	base.Command
+	From string `long:"from" description:"The tag, branch, commit or revision expression of the older version" required:"true"`
+	To   string `long:"to" description:"The tag, branch, commit or revision expression of the newer version" default:"latest"`
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Output string `short:"o" long:"output" description:"The output format" choice:"text" choice:"json" default:"text" env:"ARCHETYPE_OUTPUT"`
+}
+
+// Version is one of the two versions being compared.
+type Version struct {
+	Revision string `json:"revision"`
+	Commit   string `json:"commit"`
+}
+
+// Report is what changed between the two versions, as printed in JSON.
+type Report struct {
+	From       Version                    `json:"from"`
+	To         Version                    `json:"to"`
+	Parameters []settings.ParameterChange `json:"parameters"`
+	Files      []*repository.Change       `json:"files"`
+}
+
+// Execute is the main entry point for the changes command.
+func (cmd *Changes) Execute(args []string) error {
+
+	slog.Info("executing Changes command")
+
+	ctx, cancel := cmd.Context()
+	defer cancel()
+
+	var options []repository.Option
+
+	// 1. check that the repository URL is specified
+	if len(cmd.URLs) == 0 || cmd.URLs[0] == "" {
+		slog.Error("repository URL not specified in settings")
+		return fmt.Errorf("repository URL not specified in settings")
+	}
+	if err := cmd.NormaliseURL(); err != nil {
+		return err
+	}
+	if repository.IsPlainSource(cmd.URL) {
+		slog.Error("changes require a git repository", "url", cmd.URL)
+		return errors.New("plain directories and archives have no versions to compare")
+	}
+
+	// 2. extract and validate the authentication options, or look up the
+	// credentials, and configure the proxy and TLS
+	if auth, err := cmd.AuthenticationOpts(); err != nil {
+		slog.Error("error validating authentication options", "error", err)
+		return fmt.Errorf("error validating authentication options: %w", err)
+	} else if auth != nil {
+		options = append(options, auth)
+	}
+	options = append(options, cmd.ProxyOpts())
+	if tls, err := cmd.TLSOpts(); err != nil {
+		slog.Error("error configuring TLS", "error", err)
+		return fmt.Errorf("error configuring TLS: %w", err)
+	} else if tls != nil {
+		options = append(options, tls)
+	}
+
+	// configure the persistent clone cache
+	if cache, err := cmd.CacheOpts(); err != nil {
+		slog.Error("error configuring clone cache", "error", err)
+		return fmt.Errorf("error configuring clone cache: %w", err)
+	} else {
+		options = append(options, cache...)
+	}
+
+	// configure the mirrors to fall back to
+	if mirrors, err := cmd.MirrorOpts(); err != nil {
+		slog.Error("error configuring mirrors", "error", err)
+		return fmt.Errorf("error configuring mirrors: %w", err)
+	} else {
+		options = append(options, mirrors...)
+	}
+
+	// report the progress of cloning and fetching on the standard error, so
+	// as not to mix it with the output
+	options = append(options, repository.WithProgress(cmd.Reporter(os.Stderr)))
+
+	// both versions are needed, whatever the fetch strategy
+	if cmd.Fetch == "shallow" {
+		slog.Warn("shallow fetch strategy ignored, both versions are needed")
+	}
+	cmd.Fetch = "full"
+	options = append(options, cmd.FetchOpts(cmd.To)...)
+
+	// 3. clone (or fetch into the cache) the remote archetypal repository and
+	// resolve the two versions
+	repo, err := repository.New(ctx, cmd.URL, options...)
+	if err != nil {
+		slog.Error("failed to clone remote repository", "url", cmd.URL, "error", err)
+		return fmt.Errorf("failed to clone remote repository '%s': %w", cmd.URL, err)
+	}
+	from, err := repo.Resolve(ctx, cmd.From)
+	if err != nil {
+		slog.Error("failed to get commit for older version", "tag", cmd.From, "error", err)
+		return fmt.Errorf("failed to get commit for older version '%s': %w", cmd.From, err)
+	}
+	to, err := repo.Resolve(ctx, cmd.To)
+	if err != nil {
+		slog.Error("failed to get commit for newer version", "tag", cmd.To, "error", err)
+		return fmt.Errorf("failed to get commit for newer version '%s': %w", cmd.To, err)
+	}
+
+	// 4. compare the parameters in the metadata of the two versions
+	older, err := metadata(repo.Source(from.Commit))
+	if err != nil {
+		return err
+	}
+	newer, err := metadata(repo.Source(to.Commit))
+	if err != nil {
+		return err
+	}
+	report := &Report{
+		From:       Version{Revision: cmd.From, Commit: from.Commit.Hash.String()},
+		To:         Version{Revision: cmd.To, Commit: to.Commit.Hash.String()},
+		Parameters: settings.CompareMetadata(older, newer),
+		Files:      []*repository.Change{},
+	}
+
+	// 5. compare the files, except for the metadata file compared above
+	changes, err := repo.Changes(ctx, from.Commit, to.Commit)
+	if err != nil {
+		slog.Error("failed to compare versions", "from", cmd.From, "to", cmd.To, "error", err)
+		return fmt.Errorf("failed to compare versions '%s' and '%s': %w", cmd.From, cmd.To, err)
+	}
+	for _, change := range changes {
+		if change.Name != repository.MetadataFile {
+			report.Files = append(report.Files, change)
+		}
+	}
+
+	// 6. print the report
+	if cmd.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(report)
+	}
+	printReport(report)
+	return nil
+}
+
+// metadata loads the archetype metadata in the given source; an archetype
+// without metadata has no parameters.
+func metadata(source repository.Source) (*settings.Metadata, error) {
+	file, err := repository.Metadata(source)
+	if err != nil {
+		slog.Warn("no archetype metadata file, assuming no parameters", "source", source.String())
+		return &settings.Metadata{}, nil
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		slog.Error("failed to get contents of archetype metadata file", "source", source.String(), "error", err)
+		return nil, err
+	}
+	parsed := &settings.Metadata{}
+	if err := yaml.Unmarshal([]byte(contents), parsed); err != nil {
+		slog.Error("failed to unmarshal archetype metadata file", "source", source.String(), "error", err)
+		return nil, fmt.Errorf("invalid archetype metadata in %s: %w", source.String(), err)
+	}
+	return parsed, nil
+}
+
+// printReport prints the report for humans: the parameter changes, the list
+// of the changed files and their unified diffs.
+func printReport(report *Report) {
+	fmt.Printf("# from: %s (%s)\n", report.From.Revision, report.From.Commit)
+	fmt.Printf("# to: %s (%s)\n", report.To.Revision, report.To.Commit)
+
+	fmt.Printf("---- %s ----\n", printf.Yellow("PARAMETERS"))
+	if len(report.Parameters) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, change := range report.Parameters {
+		switch change.Action {
+		case "added":
+			fmt.Printf("%s %s (type: %s, default: %v)\n", printf.Green("+"), change.Name, change.After.Type, change.After.Default)
+		case "removed":
+			fmt.Printf("%s %s (type: %s, default: %v)\n", printf.Red("-"), change.Name, change.Before.Type, change.Before.Default)
+		default:
+			details := []string{}
+			for _, field := range change.Fields {
+				switch field {
+				case "type":
+					details = append(details, fmt.Sprintf("type: %s => %s", change.Before.Type, change.After.Type))
+				case "default":
+					details = append(details, fmt.Sprintf("default: %v => %v", change.Before.Default, change.After.Default))
+				case "description":
+					details = append(details, "description changed")
+				}
+			}
+			fmt.Printf("%s %s (%s)\n", printf.Yellow("~"), change.Name, strings.Join(details, ", "))
+		}
+	}
+
+	fmt.Printf("---- %s ----\n", printf.Yellow("FILES"))
+	if len(report.Files) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, change := range report.Files {
+		switch change.Action {
+		case repository.Added:
+			fmt.Printf("%s %s\n", printf.Green("A"), change.Name)
+		case repository.Removed:
+			fmt.Printf("%s %s\n", printf.Red("D"), change.Name)
+		case repository.Renamed:
+			fmt.Printf("%s %s => %s\n", printf.Yellow("R"), change.Previous, change.Name)
+		default:
+			fmt.Printf("%s %s\n", printf.Yellow("M"), change.Name)
+		}
+	}
+	for _, change := range report.Files {
+		if change.Patch == "" {
+			continue
+		}
+		fmt.Println()
+		for _, line := range strings.Split(strings.TrimSuffix(change.Patch, "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+				fmt.Println(line)
+			case strings.HasPrefix(line, "+"):
+				fmt.Println(printf.Green(line))
+			case strings.HasPrefix(line, "-"):
+				fmt.Println(printf.Red(line))
+			case strings.HasPrefix(line, "@@"):
+				fmt.Println(printf.Blue(line))
+			default:
+				fmt.Println(line)
+			}
+		}
+	}
+}
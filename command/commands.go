@@ -1,10 +1,14 @@
 package command
 
 import (
+	"github.com/dihedron/archetype/command/cache"
+	"github.com/dihedron/archetype/command/changes"
 	"github.com/dihedron/archetype/command/describe"
 	"github.com/dihedron/archetype/command/generate"
+	"github.com/dihedron/archetype/command/list"
 	"github.com/dihedron/archetype/command/prepare"
 	"github.com/dihedron/archetype/command/version"
+	"github.com/dihedron/archetype/command/versions"
 )
 
 // Commands is the main container for all the commands of the application.
@@ -15,12 +19,24 @@ type Commands struct {
 	// Describe runs the Describe command which displays the settings needed for the specific project.
 	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
 	Describe describe.Describe `command:"describe" alias:"descr" alias:"d" description:"Describe the necessary settings"`
+	// List runs the List command which finds all the archetypes in the repository.
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	List list.List `command:"list" alias:"ls" alias:"l" description:"List the archetypes in the repository"`
+	// Versions runs the Versions command which lists the tags and branches of the repository.
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Versions versions.Versions `command:"versions" alias:"releases" alias:"tags" description:"List the versions (tags and branches) of the archetypes in the repository"`
+	// Changes runs the Changes command which shows what changed in the archetype between two versions.
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Changes changes.Changes `command:"changes" alias:"diff" alias:"changelog" description:"Show what changed in the archetype between two versions"`
 	// Escape runs the Escape command which escapes all Golang-template directives in the files in the repository.
 	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
 	Escape prepare.Escape `command:"escape" alias:"esc" alias:"e" description:"Escape all Golang-template directives in the given files"`
 	// Escape runs the Escape command which escapes all Golang-template directives in the files in the repository.
 	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
 	Unescape prepare.Unescape `command:"unescape" alias:"unesc" alias:"u" description:"Unescape all Golang-template directives in the given files"`
+	// Cache manages the persistent cache of cloned archetype repositories.
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Cache cache.Cache `command:"cache" description:"Manage the cache of cloned archetype repositories"`
 	// Version prints the application version information and exits.
 	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
 	Version version.Version `command:"version" alias:"ver" alias:"v" description:"Show the command version and exit"`
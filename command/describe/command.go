@@ -23,6 +23,9 @@ func (cmd *Describe) Execute(args []string) error {
 
 	slog.Info("executing Describe command")
 
+	ctx, cancel := cmd.Context()
+	defer cancel()
+
 	if len(cmd.Exclude) > 0 && len(cmd.Include) > 0 {
 		slog.Warn("both exclude and include patterns specified; include patterns will take precedence")
 		fmt.Fprintf(os.Stderr, "Both exclude and include patterns specified; include patterns will take precedence\n")
@@ -30,45 +33,70 @@ func (cmd *Describe) Execute(args []string) error {
 	var options []repository.Option
 
 	// 1. check that the repository URL is specified
-	if cmd.URL == "" {
+	if len(cmd.URLs) == 0 || cmd.URLs[0] == "" {
 		slog.Error("repository URL not specified in settings")
 		return fmt.Errorf("repository URL not specified in settings")
 	}
+	if err := cmd.NormaliseURL(); err != nil {
+		return err
+	}
 
-	// 2. extract authentication options
-	if cmd.HasAuthOptions() {
-		// extract and validate auth settings
-		if auth, err := cmd.AuthenticationOpts(); err != nil {
-			slog.Error("error validating authentication options", "error", err)
-			return fmt.Errorf("error validating authentication options: %w", err)
-		} else if auth != nil {
-			options = append(options, auth)
-		}
+	// 2. extract and validate the authentication options, or look up the
+	// credentials, and configure the proxy and TLS
+	if auth, err := cmd.AuthenticationOpts(); err != nil {
+		slog.Error("error validating authentication options", "error", err)
+		return fmt.Errorf("error validating authentication options: %w", err)
+	} else if auth != nil {
+		options = append(options, auth)
+	}
+	options = append(options, cmd.ProxyOpts())
+	if tls, err := cmd.TLSOpts(); err != nil {
+		slog.Error("error configuring TLS", "error", err)
+		return fmt.Errorf("error configuring TLS: %w", err)
+	} else if tls != nil {
+		options = append(options, tls)
 	}
-	//options = append(options, repository.WithProxyFromEnv())
 
-	// 3. create an in-memory clone of the remote archetypal repository
-	repo, err := repository.New(cmd.URL, options...)
-	if err != nil {
-		slog.Error("failed to clone remote repository", "url", cmd.URL, "error", err)
-		return fmt.Errorf("failed to clone remote repository '%s': %w", cmd.URL, err)
+	// configure the persistent clone cache
+	if cache, err := cmd.CacheOpts(); err != nil {
+		slog.Error("error configuring clone cache", "error", err)
+		return fmt.Errorf("error configuring clone cache: %w", err)
+	} else {
+		options = append(options, cache...)
 	}
 
-	// 4. checkout the specified tag (or 'latest' if none specified)
+	// configure the mirrors to fall back to
+	if mirrors, err := cmd.MirrorOpts(); err != nil {
+		slog.Error("error configuring mirrors", "error", err)
+		return fmt.Errorf("error configuring mirrors: %w", err)
+	} else {
+		options = append(options, mirrors...)
+	}
+
+	// report the progress of cloning and fetching on the standard error, so
+	// as not to mix it with the output
+	reporter := cmd.Reporter(os.Stderr)
+	options = append(options, repository.WithProgress(reporter))
+
+	// select the fetch strategy
 	if cmd.Tag == nil {
 		slog.Info("no tag specified, using 'latest' as default")
 		cmd.Tag = pointer.To("latest")
 	}
-	commit, err := repo.Commit(*cmd.Tag)
+	options = append(options, cmd.FetchOpts(*cmd.Tag)...)
+
+	// 3. open the archetype source: clone (or fetch into the cache) the remote
+	// archetypal repository and checkout the specified tag, or use the plain
+	// directory or local archive as it is
+	source, description, err := cmd.Source(ctx, options, reporter)
 	if err != nil {
-		slog.Error("failed to get commit for input tag", "tag", *cmd.Tag, "error", err)
-		return fmt.Errorf("failed to get commit for input tag '%s': %w", *cmd.Tag, err)
+		return err
 	}
 
-	// 5. validate the user-provided settings against the remote archetype metadata
-	file, err := commit.File(".archetype/metadata.yml")
+	// 4. validate the user-provided settings against the remote archetype metadata
+	file, err := repository.Metadata(source)
 	if err != nil {
-		slog.Error("failed to get archetype metadata file from repository", "error", err)
+		slog.Error("failed to get archetype metadata file from repository", "source", source.String(), "error", err)
 		return fmt.Errorf("failed to get archetype metadata file from repository: %w", err)
 	}
 	var contents string
@@ -89,10 +117,15 @@ func (cmd *Describe) Execute(args []string) error {
 	for key, value := range metadata.Parameters {
 		settings.Parameters[key] = value.Default
 	}
+	fmt.Printf("%s", description)
 	fmt.Printf("%s", logging.ToYAML(settings))
 
-	// 6. loop over the files and perform some processing
-	repo.ForEachFile(commit, FileVisitor(cmd.Exclude, cmd.Include))
+	// 5. loop over the files and perform some processing
+	if err := repository.ForEachFile(ctx, source, FileVisitor(cmd.Exclude, cmd.Include)); err != nil {
+		if stopped := base.Stopped(ctx); stopped != nil {
+			return stopped
+		}
+	}
 
 	return nil
 
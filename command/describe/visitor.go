@@ -1,6 +1,7 @@
 package describe
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"regexp"
@@ -8,7 +9,6 @@ import (
 
 	"github.com/dihedron/archetype/printf"
 	"github.com/dihedron/archetype/repository"
-	"github.com/go-git/go-git/v6/plumbing/object"
 )
 
 // FileVisitor returns a function that processes files in a directory using the provided context for template rendering.
@@ -44,46 +44,54 @@ func FileVisitor(excludePatterns []string, includePatterns []string) repository.
 		}
 	}
 
-	return func(file *object.File) error {
+	return func(ctx context.Context, file repository.File) error {
 		// 1. skip files in the archive metadata directory
-		if strings.HasPrefix(file.Name, ".archetype") {
-			slog.Info("skipping archetype files", "file", file.Name)
+		if strings.HasPrefix(file.Name(), ".archetype") {
+			slog.Info("skipping archetype files", "file", file.Name())
 			return nil
 		}
 
 		// fmt.Printf("exlcude: %d, include: %d\n", len(excludePatterns), len(includePatterns))
 
-		//fmt.Printf("checking file %s\n", file.Name)
+		//fmt.Printf("checking file %s\n", file.Name())
 		if len(includes) > 0 {
 			matched := false
 			for _, re := range includes {
-				if re.MatchString(file.Name) {
+				if re.MatchString(file.Name()) {
 					matched = true
 					break
 				}
 			}
 			if !matched {
-				slog.Info("skipping file not matching include patterns", "file", file.Name)
-				//fmt.Printf("skipping file %s (no include pattern matches)\n", file.Name)
+				slog.Info("skipping file not matching include patterns", "file", file.Name())
+				//fmt.Printf("skipping file %s (no include pattern matches)\n", file.Name())
 				return nil
 			}
 		} else if len(excludes) > 0 {
 			for _, re := range excludes {
-				if re.MatchString(file.Name) {
-					slog.Info("skipping file matching exclude pattern", "file", file.Name)
-					fmt.Printf("skipping file %s (exclude pattern matches)\n", file.Name)
+				if re.MatchString(file.Name()) {
+					slog.Info("skipping file matching exclude pattern", "file", file.Name())
+					fmt.Printf("skipping file %s (exclude pattern matches)\n", file.Name())
 					return nil
 				}
 			}
 		}
 
-		fmt.Printf("================================ %s ================================\n", printf.Green(file.Name))
+		fmt.Printf("================================ %s ================================\n", printf.Green(file.Name()))
+
+		// files stored in Git LFS are usually binary, and are not templates
+		if pointer := repository.LFS(file); pointer != nil {
+			slog.Debug("not showing Git LFS object", "file", file.Name(), "oid", pointer.OID)
+			fmt.Printf("(Git LFS object, %d bytes)\n", pointer.Size)
+			fmt.Printf("-------------------------------- %s --------------------------------\n\n", printf.Green(file.Name()))
+			return nil
+		}
 
 		// 1. extract file contents into string
 		text, err := file.Contents()
 		if err != nil {
 			fmt.Printf("%s getting file contents: %v\n", printf.Red("ERROR"), err)
-			slog.Error("error getting file contents", "file", file.Name, "error", err)
+			slog.Error("error getting file contents", "file", file.Name(), "error", err)
 			return err
 		}
 
@@ -94,12 +102,12 @@ func FileVisitor(excludePatterns []string, includePatterns []string) repository.
 
 		// 3. Check if any matches were found.
 		if len(matches) == 0 {
-			slog.Debug("no template actions found in file", "file", file.Name)
+			slog.Debug("no template actions found in file", "file", file.Name())
 			fmt.Println(text)
 			return nil
 		}
 
-		slog.Debug("found matches in file", "file", file.Name, "matches", len(matches))
+		slog.Debug("found matches in file", "file", file.Name(), "matches", len(matches))
 
 		// 4. Iterate through the string, printing in color.
 		// We'll use lastIdx to keep track of where the last match ended.
@@ -128,7 +136,7 @@ func FileVisitor(excludePatterns []string, includePatterns []string) repository.
 		// Add a final newline for clean terminal output
 		//fmt.Println()
 
-		fmt.Printf("-------------------------------- %s --------------------------------\n\n", printf.Green(file.Name))
+		fmt.Printf("-------------------------------- %s --------------------------------\n\n", printf.Green(file.Name()))
 
 		// --- Configuration ---
 		// The regex pattern to search for.
@@ -141,26 +149,26 @@ func FileVisitor(excludePatterns []string, includePatterns []string) repository.
 			// and needs being renamed according to the values in the context; for instance, a file
 			// named {{.ProjectName}}-config.yml should be rendered as myapp-config.yml if the
 			// ProjectName in the context is "myapp"
-			filename, err := template.New("filename").Parse(file.Name)
+			filename, err := template.New("filename").Parse(file.Name())
 			if err != nil {
-				slog.Error("cannot parse filename template", "template", file.Name, "error", err)
+				slog.Error("cannot parse filename template", "template", file.Name(), "error", err)
 				return err
 			}
 			var buffer bytes.Buffer
 			if err := filename.Execute(&buffer, context); err != nil {
-				slog.Error("cannot execute filename template", "template", file.Name, "error", err)
+				slog.Error("cannot execute filename template", "template", file.Name(), "error", err)
 				return err
 			}
 			output := path.Join(path.Clean(directory), buffer.String())
-			fmt.Printf("%v  %9d  %s => ", file.Mode, file.Size, file.Name)
-			//fmt.Printf("processing file %s (mode: %v, size: %d, hash: %s) as %s...\n", file.Name, file.Mode, file.Size, file.Hash.String(), output)
-			//fmt.Printf("%s (mode: %v, size: %d): ", file.Name, file.Mode, file.Size)
-			slog.Info("visiting file", "file", file.Name, "output", output, "mode", file.Mode, "size", file.Size, "output", output)
+			fmt.Printf("%v  %9d  %s => ", file.Mode(), file.Size(), file.Name())
+			//fmt.Printf("processing file %s (mode: %v, size: %d, hash: %s) as %s...\n", file.Name(), file.Mode(), file.Size(), file.Hash().String(), output)
+			//fmt.Printf("%s (mode: %v, size: %d): ", file.Name(), file.Mode(), file.Size())
+			slog.Info("visiting file", "file", file.Name(), "output", output, "mode", file.Mode(), "size", file.Size(), "output", output)
 
 			reader, err := file.Blob.Reader()
 			if err != nil {
 				fmt.Printf("%s getting file reader: %v\n", red("ERROR"), err)
-				slog.Error("error getting file reader", "file", file.Name, "error", err)
+				slog.Error("error getting file reader", "file", file.Name(), "error", err)
 				return err
 			}
 			defer reader.Close()
@@ -168,7 +176,7 @@ func FileVisitor(excludePatterns []string, includePatterns []string) repository.
 			contents, err := file.Contents()
 			if err != nil {
 				fmt.Printf("%s getting file contents: %v\n", red("ERROR"), err)
-				slog.Error("error getting file contents", "file", file.Name, "error", err)
+				slog.Error("error getting file contents", "file", file.Name(), "error", err)
 				return err
 			}
 
@@ -182,12 +190,12 @@ func FileVisitor(excludePatterns []string, includePatterns []string) repository.
 			}
 
 			// parse the templates
-			main := path.Base(file.Name)
+			main := path.Base(file.Name())
 			templates, err := template.New(main).Funcs(functions).Parse(contents)
 			if err != nil {
-				slog.Error("cannot parse template file", "file", file.Name, "error", err)
+				slog.Error("cannot parse template file", "file", file.Name(), "error", err)
 				fmt.Printf("%s parsing template: %v\n", red("ERROR"), err)
-				return fmt.Errorf("error parsing template file %v: %w", file.Name, err)
+				return fmt.Errorf("error parsing template file %v: %w", file.Name(), err)
 			}
 
 			// execute the template
@@ -199,13 +207,13 @@ func FileVisitor(excludePatterns []string, includePatterns []string) repository.
 			}
 
 			// output the rendered content
-			if err = os.WriteFile(output, buffer.Bytes(), os.FileMode(file.Mode)); err != nil {
-				slog.Error("error writing file", "file", file.Name, "error", err)
+			if err = os.WriteFile(output, buffer.Bytes(), file.Mode().Perm()); err != nil {
+				slog.Error("error writing file", "file", file.Name(), "error", err)
 				fmt.Printf("%s writing file as %s: %v\n", red("ERROR"), output, err)
-				return fmt.Errorf("error writing file %s: %w", file.Name, err)
+				return fmt.Errorf("error writing file %s: %w", file.Name(), err)
 			}
 			fmt.Printf("%s (as %s)\n", green("SUCCESS"), output)
-			//fmt.Printf("---- rendered content of %s ----\n%s\n---- end of rendered content of %s ----\n", file.Name, buffer.String(), file.Name)
+			//fmt.Printf("---- rendered content of %s ----\n%s\n---- end of rendered content of %s ----\n", file.Name(), buffer.String(), file.Name())
 		*/
 		return nil
 	}
@@ -1,15 +1,18 @@
 package generate
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"runtime"
 	"strings"
 
 	"github.com/dihedron/archetype/command/base"
 	"github.com/dihedron/archetype/logging"
 	"github.com/dihedron/archetype/pointer"
 	"github.com/dihedron/archetype/printf"
+	"github.com/dihedron/archetype/progress"
 	"github.com/dihedron/archetype/repository"
 	"github.com/dihedron/archetype/settings"
 	"gopkg.in/yaml.v3"
@@ -22,22 +25,51 @@ type Generate struct {
 	Settings settings.Settings `short:"s" long:"settings" description:"The settings used to transform the archetype into an actual repository" required:"true"`
 	// Directory is the path to the directory to use for the archetype files.
 	Directory string `short:"d" long:"directory" description:"The directory where the output files are stored" required:"true" default:".archetype/output"`
+	// Verbatim is the list of paths (e.g. submodules) whose files are copied without rendering them.
+	Verbatim []string `long:"verbatim" description:"A path (e.g. a submodule) whose files are copied as they are instead of being rendered (repeatable)" env:"ARCHETYPE_VERBATIM" env-delim:","`
+	// FailFast stops the generation at the first file that fails.
+	FailFast bool `long:"fail-fast" description:"Stop at the first file that fails to render" optional:"true" env:"ARCHETYPE_FAIL_FAST"`
+	// KeepGoing renders all the files even if some fail, which is the default.
+	KeepGoing bool `long:"keep-going" description:"Render all the files even if some fail, then report the failures (default)" optional:"true" env:"ARCHETYPE_KEEP_GOING"`
+	// Jobs is the number of files rendered and written at the same time; 0 means one per CPU.
+	Jobs int `short:"j" long:"jobs" description:"The number of files to render at the same time (0 for one per CPU)" default:"1" env:"ARCHETYPE_JOBS"`
+	// MaxTemplateSize is the size above which files are copied as they are instead of being rendered.
+	MaxTemplateSize Size `long:"max-template-size" description:"The size (e.g. 512KB, 10MB) above which files are copied as they are instead of being rendered" default:"10MB" env:"ARCHETYPE_MAX_TEMPLATE_SIZE"`
 }
 
 const (
 	CurrentVersion              = 1
 	DefaultDirectoryPermissions = 0755
 	DefaultFilePermissions      = 0644
+	// EmptyDirectoryMarker is the name of the files marking the directories to
+	// create even if they hold nothing else, since git cannot store empty
+	// directories; the markers themselves are not written.
+	EmptyDirectoryMarker = ".archetype-keep"
 )
 
 // Execute is the main entry point for the Generate command.
 // It clones the archetype repository, validates the provided settings against
 // the archetype's metadata, and then processes the files in the repository,
 // treating them as templates and executing them with the provided settings.
-// The resulting files are written to the output directory.
-func (cmd *Generate) Execute(args []string) error {
+// The resulting files are written to the output directory; if the command is
+// interrupted or times out, the files written so far are removed.
+func (cmd *Generate) Execute(args []string) (err error) {
 	slog.Info("executing Generate command")
 
+	ctx, cancel := cmd.Context()
+	defer cancel()
+
+	if cmd.FailFast && cmd.KeepGoing {
+		slog.Error("both fail-fast and keep-going specified")
+		return errors.New("--fail-fast and --keep-going cannot be used together")
+	}
+	if cmd.Jobs < 0 {
+		slog.Error("invalid number of jobs", "jobs", cmd.Jobs)
+		return fmt.Errorf("invalid number of jobs: %d", cmd.Jobs)
+	} else if cmd.Jobs == 0 {
+		cmd.Jobs = runtime.NumCPU()
+	}
+
 	if len(cmd.Exclude) > 0 && len(cmd.Include) > 0 {
 		slog.Warn("both exclude and include patterns specified; include patterns will take precedence")
 		fmt.Fprintf(os.Stderr, "Both exclude and include patterns specified; include patterns will take precedence\n")
@@ -47,13 +79,27 @@ func (cmd *Generate) Execute(args []string) error {
 	slog.Debug("command configuration", "settings", logging.ToJSON(cmd.Settings), "directory", cmd.Directory)
 
 	// 1. check that the repository URL is specified
-	if cmd.URL == "" {
+	if len(cmd.URLs) == 0 || cmd.URLs[0] == "" {
 		slog.Error("repository URL not specified in settings")
 		return fmt.Errorf("repository URL not specified in settings")
 	}
+	if err := cmd.NormaliseURL(); err != nil {
+		return err
+	}
 
-	// 2. create the output directory if it does not exist; check if it is empty
-	if err := os.MkdirAll(cmd.Directory, DefaultDirectoryPermissions); err != nil {
+	// 2. create the output directory if it does not exist; check if it is empty;
+	// remove whatever was written into it if the command is stopped
+	destination := NewOutput(cmd.Directory)
+	defer func() {
+		if stopped := base.Stopped(ctx); stopped != nil {
+			slog.Warn("generation stopped, removing the files written so far", "directory", cmd.Directory, "reason", stopped)
+			if e := destination.Remove(); e != nil {
+				slog.Error("failed to remove the files written so far", "directory", cmd.Directory, "error", e)
+			}
+			err = stopped
+		}
+	}()
+	if err := destination.MkdirAll(cmd.Directory, DefaultDirectoryPermissions); err != nil {
 		slog.Error("failed to create output directory", "directory", cmd.Directory, "error", err)
 		return fmt.Errorf("failed to create output directory '%s': %w", cmd.Directory, err)
 	}
@@ -64,46 +110,62 @@ func (cmd *Generate) Execute(args []string) error {
 		slog.Warn("output directory is not empty", "directory", cmd.Directory)
 	}
 
-	// 3. extract authentication options
-	if cmd.HasAuthOptions() {
-		// extract and validate auth settings
-		if auth, err := cmd.AuthenticationOpts(); err != nil {
-			slog.Error("error validating authentication options", "error", err)
-			return fmt.Errorf("error validating authentication options: %w", err)
-		} else if auth != nil {
-			options = append(options, auth)
-		}
+	// 3. extract and validate the authentication options, or look up the
+	// credentials, and configure the proxy and TLS
+	if auth, err := cmd.AuthenticationOpts(); err != nil {
+		slog.Error("error validating authentication options", "error", err)
+		return fmt.Errorf("error validating authentication options: %w", err)
+	} else if auth != nil {
+		options = append(options, auth)
+	}
+	options = append(options, cmd.ProxyOpts())
+	if tls, err := cmd.TLSOpts(); err != nil {
+		slog.Error("error configuring TLS", "error", err)
+		return fmt.Errorf("error configuring TLS: %w", err)
+	} else if tls != nil {
+		options = append(options, tls)
 	}
-	//options = append(options, repository.WithProxyFromEnv())
 
-	// 4. create an in-memory clone of the remote archetypal repository
-	repo, err := repository.New(cmd.URL, options...)
-	if err != nil {
-		slog.Error("failed to clone remote repository", "url", cmd.URL, "error", err)
-		return fmt.Errorf("failed to clone remote repository '%s': %w", cmd.URL, err)
+	// configure the persistent clone cache
+	if cache, err := cmd.CacheOpts(); err != nil {
+		slog.Error("error configuring clone cache", "error", err)
+		return fmt.Errorf("error configuring clone cache: %w", err)
+	} else {
+		options = append(options, cache...)
 	}
 
-	// 5. checkout the specified tag (or 'latest' if none specified)
+	// configure the mirrors to fall back to
+	if mirrors, err := cmd.MirrorOpts(); err != nil {
+		slog.Error("error configuring mirrors", "error", err)
+		return fmt.Errorf("error configuring mirrors: %w", err)
+	} else {
+		options = append(options, mirrors...)
+	}
+
+	// report the progress of cloning, fetching and rendering
+	reporter := cmd.Reporter(os.Stdout)
+	options = append(options, repository.WithProgress(reporter))
+
+	// select the fetch strategy
 	if cmd.Tag == nil {
 		slog.Info("no tag specified, using 'latest' as default")
 		cmd.Tag = pointer.To("latest")
 	}
-	commit, err := repo.Commit(*cmd.Tag)
+	options = append(options, cmd.FetchOpts(*cmd.Tag)...)
+
+	// 4. open the archetype source: clone (or fetch into the cache) the remote
+	// archetypal repository and checkout the specified tag, or use the plain
+	// directory or local archive as it is
+	source, description, err := cmd.Source(ctx, options, reporter)
 	if err != nil {
-		slog.Error("failed to get commit for input tag", "tag", *cmd.Tag, "error", err)
-		return fmt.Errorf("failed to get commit for input tag '%s': %w", *cmd.Tag, err)
+		return err
 	}
+	progress.Print(reporter, strings.TrimSuffix(description, "\n"))
 
-	// // 6. loop over the files and perform some processing
-	// repo.ForEachFile(commit, func(file *object.File) error {
-	// 	fmt.Printf("processing file %s (mode: %v, size: %d, hash: %s)...\n", file.Name, file.Mode, file.Size, file.Hash.String())
-	// 	return nil
-	// })
-
-	// 6. validate the user-provided settings against the remote archetype metadata
-	file, err := commit.File(".archetype/metadata.yml")
+	// 5. validate the user-provided settings against the remote archetype metadata
+	file, err := repository.Metadata(source)
 	if err != nil {
-		slog.Error("failed to get archetype metadata file from repository", "error", err)
+		slog.Error("failed to get archetype metadata file from repository", "source", source.String(), "error", err)
 		return fmt.Errorf("failed to get archetype metadata file from repository: %w", err)
 	}
 	var contents string
@@ -123,7 +185,7 @@ func (cmd *Generate) Execute(args []string) error {
 	}
 	// 7. load and validate the parameters from the settings
 	context := map[string]any{}
-	fmt.Printf("---- %s ----\n", printf.Yellow("PARAMETERS"))
+	progress.Print(reporter, fmt.Sprintf("---- %s ----", printf.Yellow("PARAMETERS")))
 	for key, value := range cmd.Settings.Parameters {
 		meta, ok := metadata.Parameters[key]
 		if !ok {
@@ -141,19 +203,57 @@ func (cmd *Generate) Execute(args []string) error {
 		} else {
 			context[key] = value
 		}
-		fmt.Printf("'%s' => '%s' (type: %s)\n",
+		progress.Print(reporter, fmt.Sprintf("'%s' => '%s' (type: %s)",
 			printf.Green(key),
 			fmt.Sprintf("%v", printf.Green(context[key])),
 			printf.Blue(fmt.Sprintf("%T", value)),
-		)
+		))
 		//fmt.Sprintf("%T", printf.Blue(parameter.Value)))
 	}
-	fmt.Printf("---- %s ----\n", printf.Yellow("PARAMETERS"))
+	progress.Print(reporter, fmt.Sprintf("---- %s ----", printf.Yellow("PARAMETERS")))
+
+	// 6. loop over the files and perform some processing, as many at a time
+	// as requested, reporting the outcome of each file in their order; the
+	// function map is shared by all the files, and the api function goes
+	// through the same proxy as the repository
+	client, err := cmd.HTTPClient()
+	if err != nil {
+		slog.Error("error configuring HTTP client", "error", err)
+		return fmt.Errorf("error configuring HTTP client: %w", err)
+	}
+	files, err := source.Files()
+	if err != nil {
+		slog.Error("failed to list files in source", "source", source.String(), "error", err)
+		return fmt.Errorf("failed to list files in source: %w", err)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	summary := &progress.Summary{}
+	sequence := progress.NewSequence(progress.Tee(reporter, summary), names)
+	visit := []repository.VisitOption{repository.WithVisitProgress(sequence, "render"), repository.WithJobs(cmd.Jobs)}
+	if cmd.FailFast {
+		visit = append(visit, repository.WithFailFast())
+	}
+	err = repository.ForEachFile(ctx, source, FileVisitor(destination, context, Functions(ctx, client), cmd.Include, cmd.Exclude, cmd.Verbatim, cmd.MaxTemplateSize, sequence), visit...)
+	sequence.Flush()
+	if base.Stopped(ctx) != nil {
+		return err
+	}
 
-	// 6. loop over the files and perform some processing
-	repo.ForEachFile(commit, FileVisitor(cmd.Directory, context, cmd.Include, cmd.Exclude))
+	// 7. sum up the outcome of each file, unless the output is for machines
+	// or only errors are wanted, and fail if any did
+	if !cmd.Quiet && cmd.Progress != "json" {
+		printSummary(os.Stdout, summary)
+	}
+	if err != nil {
+		failed := len(summary.Failed())
+		slog.Error("files failed to render", "count", failed)
+		return fmt.Errorf("%d file(s) failed to render:\n%w", failed, err)
+	}
 
-	// 7. launch the script for post processing (TODO)
+	// 8. launch the script for post processing (TODO)
 
 	return nil
 }
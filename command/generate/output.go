@@ -0,0 +1,307 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffer io.Copy uses, i.e. the memory it
+// takes to stream a file of any size.
+const copyBufferSize = 32 * 1024
+
+// Output is the directory the archetype is generated into; it writes files
+// atomically, so that none is ever left half written, and keeps track of the
+// files and directories it creates, so that they can be removed if the
+// generation is interrupted. No file or directory is ever written through the
+// symbolic links it creates, so that these cannot lead outside of it.
+type Output struct {
+	directory string
+	lock      sync.Mutex
+	created   []string
+	links     map[string]bool
+}
+
+// NewOutput returns the Output for the given directory.
+func NewOutput(directory string) *Output {
+	return &Output{directory: directory}
+}
+
+// Directory returns the path of the output directory.
+func (o *Output) Directory() string {
+	return o.directory
+}
+
+// MkdirAll creates the given directory along with any missing parents, as
+// os.MkdirAll does, and keeps track of the ones it creates.
+func (o *Output) MkdirAll(path string, perm os.FileMode) error {
+	if link := o.linked(path); link != "" {
+		return fmt.Errorf("cannot create directory %s through symbolic link %s", path, link)
+	}
+	var missing []string
+	for directory := filepath.Clean(path); ; directory = filepath.Dir(directory) {
+		if _, err := os.Lstat(directory); err == nil {
+			break
+		}
+		missing = append(missing, directory)
+		if filepath.Dir(directory) == directory {
+			break
+		}
+	}
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for i := len(missing) - 1; i >= 0; i-- {
+		o.created = append(o.created, missing[i])
+	}
+	return nil
+}
+
+// WriteFile writes the data to the named file, as os.WriteFile does, through
+// a temporary file in the same directory which replaces it only once complete
+// and only if the context is not done yet; it keeps track of the file unless
+// it existed already.
+func (o *Output) WriteFile(ctx context.Context, name string, data []byte, perm os.FileMode) error {
+	return o.write(ctx, name, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Copy writes what it reads from the reader to the named file as WriteFile
+// does, streaming it instead of holding it all in memory, and stops as soon
+// as the context is done; it returns the number of bytes copied.
+func (o *Output) Copy(ctx context.Context, name string, reader io.Reader, perm os.FileMode) (int64, error) {
+	var copied int64
+	err := o.write(ctx, name, perm, func(w io.Writer) (err error) {
+		copied, err = io.Copy(w, &contextReader{ctx: ctx, reader: reader})
+		return err
+	})
+	return copied, err
+}
+
+// write writes the named file atomically through a temporary file, which the
+// given function fills in.
+func (o *Output) write(ctx context.Context, name string, perm os.FileMode, fill func(io.Writer) error) error {
+	if link := o.linked(filepath.Dir(name)); link != "" {
+		return fmt.Errorf("cannot write file %s through symbolic link %s", name, link)
+	}
+	_, err := os.Lstat(name)
+	existed := err == nil
+	temporary, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if err := fill(temporary); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temporary.Name(), perm); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary.Name(), name); err != nil {
+		return err
+	}
+	if !existed {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+		o.created = append(o.created, name)
+	}
+	return nil
+}
+
+// Symlink creates the named symbolic link to the given target, which uses
+// forward slashes and must be relative and lead within the output directory,
+// following the links already there as the system would, so that no file can
+// be read or written outside it through the link; like WriteFile, it replaces
+// any file with the same name only if the context is not done yet.
+func (o *Output) Symlink(ctx context.Context, target string, name string) error {
+	target = filepath.FromSlash(target)
+	if link := o.linked(filepath.Dir(name)); link != "" {
+		return fmt.Errorf("cannot create symbolic link %s through symbolic link %s", name, link)
+	}
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("symbolic link %s points to an absolute path (%s)", name, target)
+	}
+	root, err := filepath.EvalSymlinks(o.directory)
+	if err != nil {
+		return err
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	if parent, err = filepath.Abs(parent); err != nil {
+		return err
+	}
+	location, _, err := resolve(parent, target, 0)
+	if err != nil {
+		return fmt.Errorf("cannot resolve symbolic link %s (%s): %w", name, target, err)
+	}
+	if !within(root, location) {
+		return fmt.Errorf("symbolic link %s points outside the output directory (%s)", name, target)
+	}
+	_, err = os.Lstat(name)
+	existed := err == nil
+	// reserve a temporary name next to the link, then replace it with the link
+	temporary, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	temporary.Close()
+	os.Remove(temporary.Name())
+	if err := os.Symlink(target, temporary.Name()); err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary.Name(), name); err != nil {
+		return err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if absolute, err := filepath.Abs(name); err == nil {
+		if o.links == nil {
+			o.links = map[string]bool{}
+		}
+		o.links[absolute] = true
+	}
+	if !existed {
+		o.created = append(o.created, name)
+	}
+	return nil
+}
+
+// linked returns the symbolic link created by Symlink that the given path is,
+// or goes through, if any.
+func (o *Output) linked(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for directory := absolute; ; directory = filepath.Dir(directory) {
+		if o.links[directory] {
+			return directory
+		}
+		if filepath.Dir(directory) == directory {
+			return ""
+		}
+	}
+}
+
+// maxLinks is the number of symbolic links resolve follows before giving up,
+// as the system does.
+const maxLinks = 40
+
+// resolve returns the path the given target leads to from the given directory,
+// which must be an absolute path free of symbolic links, following the links
+// on the way one component at a time as the system would; unlike a lexical
+// clean, going up from a link leads to the parent of its target. It also
+// returns whether the path does not exist (yet), and fails if the target goes
+// up from a path which does not exist, since that may become a link later.
+func resolve(directory string, target string, depth int) (string, bool, error) {
+	current, missing := directory, false
+	if filepath.IsAbs(target) {
+		current = filepath.VolumeName(target) + string(filepath.Separator)
+		target = target[len(filepath.VolumeName(target)):]
+	}
+	for _, component := range strings.Split(target, string(filepath.Separator)) {
+		switch component {
+		case "", ".":
+		case "..":
+			if missing {
+				return "", false, fmt.Errorf("cannot go up from %s, which does not exist", current)
+			}
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, component)
+			if missing {
+				continue
+			}
+			info, err := os.Lstat(current)
+			if err != nil {
+				missing = true
+				continue
+			}
+			if info.Mode()&fs.ModeSymlink == 0 {
+				continue
+			}
+			if depth >= maxLinks {
+				return "", false, errors.New("too many levels of symbolic links")
+			}
+			link, err := os.Readlink(current)
+			if err != nil {
+				return "", false, err
+			}
+			if current, missing, err = resolve(filepath.Dir(current), link, depth+1); err != nil {
+				return "", false, err
+			}
+		}
+	}
+	return current, missing, nil
+}
+
+// within returns whether the given absolute path is the given root directory
+// or is under it.
+func within(root string, location string) bool {
+	relative, err := filepath.Rel(root, location)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// contextReader is a reader failing as soon as the context is done, so that
+// copying large files can be interrupted.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// Remove removes the files and directories created so far, latest first;
+// directories are only removed if empty, so that files written by others are
+// kept.
+func (o *Output) Remove() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	var errs []error
+	for i := len(o.created) - 1; i >= 0; i-- {
+		if err := os.Remove(o.created[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			if entries, e := os.ReadDir(o.created[i]); e == nil && len(entries) > 0 {
+				slog.Debug("keeping non-empty directory", "path", o.created[i])
+				continue
+			}
+			slog.Error("failed to remove output", "path", o.created[i], "error", err)
+			errs = append(errs, err)
+		}
+	}
+	o.created = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,80 @@
+package generate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputSymlink(t *testing.T) {
+	directory := t.TempDir()
+	output := NewOutput(filepath.Join(directory, "out"))
+	at := func(name string) string {
+		return filepath.Join(output.Directory(), filepath.FromSlash(name))
+	}
+	ctx := context.Background()
+	if err := output.MkdirAll(at("sub/deep"), DefaultDirectoryPermissions); err != nil {
+		t.Fatalf("cannot create directories: %v", err)
+	}
+	if err := output.WriteFile(ctx, at("file.txt"), []byte("hello"), DefaultFilePermissions); err != nil {
+		t.Fatalf("cannot write file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		allowed bool
+	}{
+		{"link.txt", "file.txt", true},
+		{"sub/up.txt", "../file.txt", true},
+		{"alias", "sub", true},
+		{"dangling.txt", "not-yet-written.txt", true},
+		{"chained", "alias/deep", true},
+		{"back", "alias/..", true},
+		{"outside", "alias/../..", false},
+		{"escape", "../../etc/passwd", false},
+		{"sub/escape", "../..", false},
+		{"absolute", "/etc/passwd", false},
+		{"missing", "later/..", false},
+		{"alias/through", "deep", false},
+	}
+	for _, test := range tests {
+		err := output.Symlink(ctx, test.target, at(test.name))
+		if test.allowed && err != nil {
+			t.Errorf("expected %s -> %s to be allowed, got %v", test.name, test.target, err)
+		} else if !test.allowed && err == nil {
+			t.Errorf("expected %s -> %s to be refused", test.name, test.target)
+		}
+	}
+
+	// no file can be written through the links, whatever they point to
+	if err := output.WriteFile(ctx, at("alias/file.txt"), []byte("hello"), DefaultFilePermissions); err == nil {
+		t.Errorf("expected writing through a link to be refused")
+	}
+	if err := output.MkdirAll(at("alias/new"), DefaultDirectoryPermissions); err == nil {
+		t.Errorf("expected creating a directory through a link to be refused")
+	}
+	if _, err := os.Lstat(at("sub/file.txt")); err == nil {
+		t.Errorf("expected no file to be written through a link")
+	}
+
+	// a chain of links leading out of the output directory, which is only
+	// apparent when following them
+	if err := output.Symlink(ctx, ".", at("d")); err != nil {
+		t.Fatalf("expected link to the output directory to be allowed: %v", err)
+	}
+	if err := output.Symlink(ctx, "..", at("d/e")); err == nil {
+		t.Errorf("expected link through a link to be refused")
+	}
+	if err := output.Symlink(ctx, "d/..", at("up")); err == nil {
+		t.Errorf("expected link leading out through a link to be refused")
+	}
+
+	if err := output.Remove(); err != nil {
+		t.Fatalf("cannot remove output: %v", err)
+	}
+	if _, err := os.Lstat(output.Directory()); err == nil {
+		t.Errorf("expected output directory to be removed")
+	}
+}
@@ -0,0 +1,51 @@
+package generate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes, given on the command line either as a plain
+// number or with a unit, e.g. 512KB, 10MiB or 1G; units are powers of 1024
+// whether or not they have the binary "i" infix.
+type Size int64
+
+// units are the multipliers of the size suffixes, longest first.
+var units = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// UnmarshalFlag parses the size; it is used by the go-flags package to handle
+// custom flag types.
+func (s *Size) UnmarshalFlag(value string) error {
+	number, multiplier := strings.TrimSpace(value), int64(1)
+	for _, unit := range units {
+		if len(number) > len(unit.suffix) && strings.EqualFold(number[len(number)-len(unit.suffix):], unit.suffix) {
+			number, multiplier = strings.TrimSpace(number[:len(number)-len(unit.suffix)]), unit.multiplier
+			break
+		}
+	}
+	parsed, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("invalid size '%s': expected a number of bytes, optionally followed by KB, MB or GB", value)
+	}
+	*s = Size(parsed * multiplier)
+	return nil
+}
+
+// String returns the size in the largest unit it is a whole multiple of.
+func (s Size) String() string {
+	for i := 2; i >= 0; i-- {
+		if unit := units[i]; s != 0 && int64(s)%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", int64(s)/unit.multiplier, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(s))
+}
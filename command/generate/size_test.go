@@ -0,0 +1,42 @@
+package generate
+
+import "testing"
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Size
+		text     string
+	}{
+		{"0", 0, "0B"},
+		{"100", 100, "100B"},
+		{"1024", 1 << 10, "1KiB"},
+		{"512KB", 512 << 10, "512KiB"},
+		{"512kb", 512 << 10, "512KiB"},
+		{"10MiB", 10 << 20, "10MiB"},
+		{"10 MB", 10 << 20, "10MiB"},
+		{"1G", 1 << 30, "1GiB"},
+		{"2GiB", 2 << 30, "2GiB"},
+		{"1536K", 1536 << 10, "1536KiB"},
+		{"3B", 3, "3B"},
+	}
+	for _, test := range tests {
+		var size Size
+		if err := size.UnmarshalFlag(test.value); err != nil {
+			t.Errorf("cannot parse %q: %v", test.value, err)
+			continue
+		}
+		if size != test.expected {
+			t.Errorf("expected %q to be %d bytes, got %d", test.value, test.expected, size)
+		}
+		if size.String() != test.text {
+			t.Errorf("expected %q to print as %s, got %s", test.value, test.text, size.String())
+		}
+	}
+	for _, value := range []string{"", "MB", "-1", "1.5MB", "10TB", "ten"} {
+		var size Size
+		if err := size.UnmarshalFlag(value); err == nil {
+			t.Errorf("expected error parsing %q, got %d", value, size)
+		}
+	}
+}
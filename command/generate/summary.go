@@ -0,0 +1,31 @@
+package generate
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dihedron/archetype/printf"
+	"github.com/dihedron/archetype/progress"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// printSummary prints a table with the files skipped and failed, along with
+// the reasons why, and the number of files rendered, copied, skipped and
+// failed.
+func printSummary(w io.Writer, summary *progress.Summary) {
+	skipped, failed := summary.Skipped(), summary.Failed()
+	writer := table.NewWriter()
+	writer.SetOutputMirror(w)
+	writer.AppendHeader(table.Row{"Result", "File", "Reason"})
+	for _, event := range skipped {
+		writer.AppendRow(table.Row{printf.Yellow("SKIPPED"), event.File, event.Message})
+	}
+	for _, event := range failed {
+		writer.AppendRow(table.Row{printf.Red("FAILED"), event.File, event.Error})
+	}
+	writer.AppendFooter(table.Row{"Total", fmt.Sprintf("%d rendered, %d copied, %d skipped, %d failed", summary.Rendered(), summary.Copied(), len(skipped), len(failed)), ""})
+	writer.SetStyle(table.StyleLight)
+	writer.Style().Format.Footer = text.FormatDefault
+	writer.Render()
+}
@@ -2,9 +2,11 @@ package generate
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
+	"net/http"
 	"path"
 	"regexp"
 	"strings"
@@ -12,17 +14,28 @@ import (
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/dihedron/archetype/extensions"
-	"github.com/dihedron/archetype/printf"
+	"github.com/dihedron/archetype/progress"
 	"github.com/dihedron/archetype/repository"
-	"github.com/go-git/go-git/v6/plumbing/object"
 )
 
-// FileVisitor returns a function that processes files in a directory using the provided context for template rendering.
+// FileVisitor returns a function that processes files in a directory using the provided values for template rendering.
 // The returned function is of type repository.FileVisitor, which is a callback that is invoked for each file in the repository.
 // It skips files in the .archetype directory, and for all other files, it reads their content, parses them as text/template templates,
-// executes them with the provided context, and writes the output to the corresponding path in the destination directory.
-// It also adds the Sprig and custom template functions to the template.
-func FileVisitor(directory string, context any, includePatterns []string, excludePatterns []string) repository.FileVisitor {
+// executes them with the provided values, and writes the output to the corresponding path in the destination directory.
+// The templates are given the functions in the provided map, which is shared
+// by all the files and must not be modified while they are being rendered (see Functions).
+// Files under any of the verbatim paths (e.g. submodules holding shared files) are
+// copied as they are, without rendering either their names or their contents;
+// files stored in Git LFS, and files larger than the maximum template size, have
+// their names rendered but their contents streamed to disk as they are. The
+// memory each file takes while being processed is logged at debug level.
+// Files are written with the permissions of their git mode (0644, or 0755 for
+// executables), creating their parent directories as needed, and so are the
+// directories holding an EmptyDirectoryMarker, which is not written; symbolic
+// links are recreated, with their targets rendered as templates.
+// The outcome of each file is reported to the given reporter; files are written
+// only if the context is not done. The returned visitor is safe for concurrent use.
+func FileVisitor(destination *Output, values any, functions template.FuncMap, includePatterns []string, excludePatterns []string, verbatim []string, maxTemplateSize Size, reporter progress.Reporter) repository.FileVisitor {
 
 	includes := make([]*regexp.Regexp, 0)
 	excludes := make([]*regexp.Regexp, 0)
@@ -48,111 +61,225 @@ func FileVisitor(directory string, context any, includePatterns []string, exclud
 		}
 	}
 
-	return func(file *object.File) error {
+	return func(ctx context.Context, file repository.File) (err error) {
+
+		progress.Report(reporter, progress.FileStarted, func(e *progress.Event) { e.File = file.Name() })
+		skip := func(reason string) {
+			progress.Report(reporter, progress.FileSkipped, func(e *progress.Event) {
+				e.File = file.Name()
+				e.Message = reason
+			})
+		}
+		defer func() {
+			if err != nil {
+				progress.Report(reporter, progress.FileFailed, func(e *progress.Event) {
+					e.File = file.Name()
+					e.Error = err.Error()
+				})
+			}
+		}()
 
 		// 1. skip files in the archive metadata directory
-		if strings.HasPrefix(file.Name, ".archetype") {
-			slog.Info("skipping archetype files", "file", file.Name)
+		if strings.HasPrefix(file.Name(), ".archetype") {
+			slog.Info("skipping archetype files", "file", file.Name())
+			skip("archetype metadata")
 			return nil
 		}
 
 		// 2. process the filename as a template; the name of the file may be itself a template
-		// and needs being renamed according to the values in the context; for instance, a file
+		// and needs being renamed according to the values in the settings; for instance, a file
 		// named {{.ProjectName}}-config.yml should be rendered as myapp-config.yml if the
-		// ProjectName in the context is "myapp"
-		filename, err := template.New("filename").Parse(file.Name)
-		if err != nil {
-			slog.Error("cannot parse filename template", "template", file.Name, "error", err)
-			return err
-		}
+		// ProjectName in the settings is "myapp"; files to be copied verbatim keep their name
+		raw := isVerbatim(file.Name(), verbatim)
 		var buffer bytes.Buffer
-		if err := filename.Execute(&buffer, context); err != nil {
-			slog.Error("cannot execute filename template", "template", file.Name, "error", err)
-			return err
+		if raw {
+			buffer.WriteString(file.Name())
+		} else {
+			filename, err := template.New("filename").Parse(file.Name())
+			if err != nil {
+				slog.Error("cannot parse filename template", "template", file.Name(), "error", err)
+				return err
+			}
+			if err := filename.Execute(&buffer, values); err != nil {
+				slog.Error("cannot execute filename template", "template", file.Name(), "error", err)
+				return err
+			}
 		}
 
 		// 3. check include/exclude patterns to include/skip the file
 		if len(includes) > 0 {
 			matched := false
 			for _, re := range includes {
-				if re.MatchString(file.Name) {
+				if re.MatchString(file.Name()) {
 					matched = true
 					break
 				}
 			}
 			if !matched {
-				slog.Info("skipping file not matching include patterns", "file", file.Name)
-				fmt.Printf("skipping file %s (no include pattern matches)\n", file.Name)
+				slog.Info("skipping file not matching include patterns", "file", file.Name())
+				skip("no include pattern matches")
 				return nil
 			}
 		} else if len(excludes) > 0 {
 			for _, re := range excludes {
-				if re.MatchString(file.Name) {
-					slog.Info("skipping file matching exclude pattern", "file", file.Name)
-					fmt.Printf("skipping file %s (exclude pattern matches)\n", file.Name)
+				if re.MatchString(file.Name()) {
+					slog.Info("skipping file matching exclude pattern", "file", file.Name())
+					skip("exclude pattern matches")
 					return nil
 				}
 			}
 		}
-		fmt.Printf("processing file %s (mode: %v, size: %d, hash: %s)... ", file.Name, file.Mode, file.Size, file.Hash.String())
-
 		// 4. create the name of the output file
-		output := path.Join(path.Clean(directory), buffer.String())
-		//fmt.Printf("%v  %9d  %s => ", file.Mode, file.Size, file.Name)
-		//fmt.Printf("processing file %s (mode: %v, size: %d, hash: %s) as %s...\n", file.Name, file.Mode, file.Size, file.Hash.String(), output)
-		//fmt.Printf("%s (mode: %v, size: %d): ", file.Name, file.Mode, file.Size)
-		slog.Info("visiting file", "file", file.Name, "output", output, "mode", file.Mode, "size", file.Size, "output", output)
+		output := path.Join(path.Clean(destination.Directory()), buffer.String())
+		//fmt.Printf("%v  %9d  %s => ", file.Mode(), file.Size(), file.Name())
+		//fmt.Printf("processing file %s (mode: %v, size: %d, hash: %s) as %s...\n", file.Name(), file.Mode(), file.Size(), file.Hash().String(), output)
+		//fmt.Printf("%s (mode: %v, size: %d): ", file.Name(), file.Mode(), file.Size())
+		slog.Info("visiting file", "file", file.Name(), "output", output, "mode", file.Mode(), "size", file.Size(), "output", output)
 
 		// reader2, err := file.Blob.Reader()
 		// if err != nil {
 		// 	fmt.Printf("%s getting file reader: %v\n", red("ERROR"), err)
-		// 	slog.Error("error getting file reader", "file", file.Name, "error", err)
+		// 	slog.Error("error getting file reader", "file", file.Name(), "error", err)
 		// 	return err
 		// }
 		// defer reader2.Close()
 
-		// 5. read the file contents from git
-		contents, err := file.Contents()
-		if err != nil {
-			fmt.Printf("%s getting file contents: %v\n", printf.Red("ERROR"), err)
-			slog.Error("error getting file contents", "file", file.Name, "error", err)
-			return err
+		// create the parent directories of the output file, which is all there
+		// is to do for the markers of the directories to be created even if empty
+		if err := destination.MkdirAll(path.Dir(output), DefaultDirectoryPermissions); err != nil {
+			slog.Error("error creating directory", "directory", path.Dir(output), "error", err)
+			return fmt.Errorf("error creating directory %s: %w", path.Dir(output), err)
+		}
+		if path.Base(file.Name()) == EmptyDirectoryMarker {
+			slog.Info("creating directory marked as to be kept", "file", file.Name(), "directory", path.Dir(output))
+			skip("directory marker")
+			return nil
+		}
+
+		// 5. recreate symbolic links, whose target is a template as their name
+		// is, unless they are to be copied as they are
+		if file.Mode()&fs.ModeSymlink != 0 {
+			target, err := file.Contents()
+			if err != nil {
+				slog.Error("error getting symbolic link target", "file", file.Name(), "error", err)
+				return err
+			}
+			if !raw {
+				buffer.Reset()
+				link, err := template.New("target").Funcs(functions).Parse(target)
+				if err != nil {
+					slog.Error("cannot parse symbolic link target template", "file", file.Name(), "target", target, "error", err)
+					return fmt.Errorf("error parsing symbolic link target %v: %w", file.Name(), err)
+				}
+				if err := link.Execute(&buffer, values); err != nil {
+					slog.Error("cannot execute symbolic link target template", "file", file.Name(), "target", target, "error", err)
+					return fmt.Errorf("error applying data to symbolic link target %v: %w", file.Name(), err)
+				}
+				target = buffer.String()
+			}
+			if err := destination.Symlink(ctx, target, output); err != nil {
+				slog.Error("error creating symbolic link", "file", file.Name(), "target", target, "error", err)
+				return fmt.Errorf("error creating symbolic link %s: %w", file.Name(), err)
+			}
+			kind := progress.FileRendered
+			if raw {
+				kind = progress.FileCopied
+			}
+			progress.Report(reporter, kind, func(e *progress.Event) {
+				e.File = file.Name()
+				e.Output = output
+			})
+			return nil
 		}
 
-		// 6. populate the functions map
-		functions := template.FuncMap{}
-		for k, v := range extensions.FuncMap() {
-			functions[k] = v
+		// 6. stream the files to be left unrendered straight to disk, without
+		// loading them into memory: those to be copied as they are, those
+		// stored in Git LFS, which are usually binary, and those too large to
+		// be templates
+		if raw || repository.LFS(file) != nil || file.Size() > int64(maxTemplateSize) {
+			if !raw && repository.LFS(file) == nil {
+				slog.Info("file larger than the maximum template size, copying it as it is", "file", file.Name(), "size", file.Size(), "limit", maxTemplateSize.String())
+			}
+			reader, err := file.Reader()
+			if err != nil {
+				slog.Error("error getting file reader", "file", file.Name(), "error", err)
+				return err
+			}
+			defer reader.Close()
+			copied, err := destination.Copy(ctx, output, reader, file.Mode().Perm())
+			if err != nil {
+				slog.Error("error writing file", "file", file.Name(), "error", err)
+				return fmt.Errorf("error writing file %s: %w", file.Name(), err)
+			}
+			slog.Debug("file copied", "file", file.Name(), "size", copied, "memory", copyBufferSize)
+			progress.Report(reporter, progress.FileCopied, func(e *progress.Event) {
+				e.File = file.Name()
+				e.Output = output
+			})
+			return nil
 		}
-		for k, v := range sprig.FuncMap() {
-			functions[k] = v
+
+		// 7. read the file contents to render them
+		contents, err := file.Contents()
+		if err != nil {
+			slog.Error("error getting file contents", "file", file.Name(), "error", err)
+			return err
 		}
 
-		// 7. parse the file as a template
-		main := path.Base(file.Name)
+		// 8. parse the file as a template
+		main := path.Base(file.Name())
 		templates, err := template.New(main).Funcs(functions).Parse(contents)
 		if err != nil {
-			slog.Error("cannot parse template file", "file", file.Name, "error", err)
-			fmt.Printf("%s parsing template: %v\n", printf.Red("ERROR"), err)
-			return fmt.Errorf("error parsing template file %v: %w", file.Name, err)
+			slog.Error("cannot parse template file", "file", file.Name(), "error", err)
+			return fmt.Errorf("error parsing template file %v: %w", file.Name(), err)
 		}
 
-		// 8. execute the template
+		// 9. execute the template
 		buffer.Reset()
-		if err := templates.ExecuteTemplate(&buffer, main, context); err != nil {
+		if err := templates.ExecuteTemplate(&buffer, main, values); err != nil {
 			slog.Error("cannot apply data to template", "error", err, "type", fmt.Sprintf("%T", err))
-			fmt.Printf("%s applying data to template: %v (%T)\n", printf.Red("ERROR"), err, err)
 			return fmt.Errorf("error applying data to template: %w", err)
 		}
 
-		// 9. output the rendered content
-		if err = os.WriteFile(output, buffer.Bytes(), os.FileMode(file.Mode)); err != nil {
-			slog.Error("error writing file", "file", file.Name, "error", err)
-			fmt.Printf("%s writing file as %s: %v\n", printf.Red("ERROR"), output, err)
-			return fmt.Errorf("error writing file %s: %w", file.Name, err)
+		// 10. output the rendered content
+		if err = destination.WriteFile(ctx, output, buffer.Bytes(), file.Mode().Perm()); err != nil {
+			slog.Error("error writing file", "file", file.Name(), "error", err)
+			return fmt.Errorf("error writing file %s: %w", file.Name(), err)
 		}
-		fmt.Printf("%s (saved as %s)\n", printf.Green("SUCCESS"), output)
-		//fmt.Printf("---- rendered content of %s ----\n%s\n---- end of rendered content of %s ----\n", file.Name, buffer.String(), file.Name)
+		slog.Debug("file rendered", "file", file.Name(), "size", len(contents), "rendered", buffer.Len(), "memory", len(contents)+buffer.Cap())
+		progress.Report(reporter, progress.FileRendered, func(e *progress.Event) {
+			e.File = file.Name()
+			e.Output = output
+		})
+		//fmt.Printf("---- rendered content of %s ----\n%s\n---- end of rendered content of %s ----\n", file.Name(), buffer.String(), file.Name())
 		return nil
 	}
 }
+
+// isVerbatim returns whether the file with the given name is under any of the
+// paths whose files are copied without rendering.
+func isVerbatim(name string, verbatim []string) bool {
+	for _, prefix := range verbatim {
+		prefix = strings.Trim(prefix, "/")
+		if prefix != "" && (name == prefix || strings.HasPrefix(name, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Functions returns the functions available to the templates: the custom ones
+// in the extensions package and the Sprig ones, which take precedence; the
+// requests made by the api function go through the given client and are
+// cancelled along with the given context. The map is computed once and shared
+// by all the files.
+func Functions(ctx context.Context, client *http.Client) template.FuncMap {
+	functions := template.FuncMap{}
+	for k, v := range extensions.FuncMap(ctx, client) {
+		functions[k] = v
+	}
+	for k, v := range sprig.FuncMap() {
+		functions[k] = v
+	}
+	return functions
+}
@@ -0,0 +1,94 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/dihedron/archetype/repository"
+)
+
+func TestFileVisitorCopies(t *testing.T) {
+	files := map[string][]byte{
+		"README.md": []byte("hello {{.Name}}"),
+		// text in other encodings than UTF-8 is rendered all the same
+		"latin1.txt":        []byte("caf\xe9 {{.Name}}"),
+		"huge.txt":          bytes.Repeat([]byte("{{.Name}}"), 1024),
+		"vendor/keep.txt":   []byte("as {{.Name}} is"),
+		"{{.Name}}/doc.txt": []byte("doc {{.Name}}"),
+	}
+	directory := t.TempDir()
+	for name, contents := range files {
+		location := filepath.Join(directory, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(location), 0755)
+		if err := os.WriteFile(location, contents, 0644); err != nil {
+			t.Fatalf("cannot write file: %v", err)
+		}
+	}
+	source, err := repository.OpenSource(directory)
+	if err != nil {
+		t.Fatalf("cannot open source: %v", err)
+	}
+	destination := NewOutput(filepath.Join(t.TempDir(), "out"))
+	visitor := FileVisitor(destination, map[string]string{"Name": "demo"}, template.FuncMap{}, nil, nil, []string{"vendor"}, Size(1024), nil)
+	if err := repository.ForEachFile(context.Background(), source, visitor); err != nil {
+		t.Fatalf("cannot visit files: %v", err)
+	}
+
+	expected := map[string]string{
+		"README.md":       "hello demo",
+		"latin1.txt":      "caf\xe9 demo",
+		"huge.txt":        strings.Repeat("{{.Name}}", 1024),
+		"vendor/keep.txt": "as {{.Name}} is",
+		"demo/doc.txt":    "doc demo",
+	}
+	for name, contents := range expected {
+		data, err := os.ReadFile(filepath.Join(destination.Directory(), filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("cannot read %s: %v", name, err)
+		} else if string(data) != contents {
+			t.Errorf("unexpected contents of %s: %q", name, data)
+		}
+	}
+}
+
+// roundTripper answers every request with the given body.
+type roundTripper string
+
+func (body roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: io.NopCloser(strings.NewReader(string(body))), Request: request}, nil
+}
+
+func TestFunctions(t *testing.T) {
+	// Sprig functions take precedence over the custom ones
+	functions := Functions(context.Background(), nil)
+	for name, function := range sprig.FuncMap() {
+		if reflect.ValueOf(functions[name]).Pointer() != reflect.ValueOf(function).Pointer() {
+			t.Errorf("expected Sprig function %s to take precedence", name)
+		}
+	}
+
+	// each map makes its requests through its own client
+	for _, body := range []string{`{"name": "first"}`, `{"name": "second"}`} {
+		functions := Functions(context.Background(), &http.Client{Transport: roundTripper(body)})
+		templates, err := template.New("api").Funcs(functions).Parse(`{{ (api "http://example.com").Payload.name }}`)
+		if err != nil {
+			t.Fatalf("cannot parse template: %v", err)
+		}
+		var buffer bytes.Buffer
+		if err := templates.Execute(&buffer, nil); err != nil {
+			t.Fatalf("cannot execute template: %v", err)
+		}
+		if !strings.Contains(body, buffer.String()) || buffer.Len() == 0 {
+			t.Errorf("expected the response of the given client, got %q", buffer.String())
+		}
+	}
+}
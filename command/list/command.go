@@ -0,0 +1,140 @@
+package list
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dihedron/archetype/command/base"
+	"github.com/dihedron/archetype/pointer"
+	"github.com/dihedron/archetype/repository"
+	"github.com/dihedron/archetype/settings"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"gopkg.in/yaml.v3"
+)
+
+// List is the command to list all the archetypes in a repository, i.e. all the
+// directories containing an archetype metadata file.
+type List struct {
+	base.Command
+}
+
+// Execute is the main entry point for the list command.
+func (cmd *List) Execute(args []string) error {
+
+	slog.Info("executing List command")
+
+	ctx, cancel := cmd.Context()
+	defer cancel()
+
+	var options []repository.Option
+
+	// 1. check that the repository URL is specified
+	if len(cmd.URLs) == 0 || cmd.URLs[0] == "" {
+		slog.Error("repository URL not specified in settings")
+		return fmt.Errorf("repository URL not specified in settings")
+	}
+	if err := cmd.NormaliseURL(); err != nil {
+		return err
+	}
+
+	// 2. extract and validate the authentication options, or look up the
+	// credentials, and configure the proxy and TLS
+	if auth, err := cmd.AuthenticationOpts(); err != nil {
+		slog.Error("error validating authentication options", "error", err)
+		return fmt.Errorf("error validating authentication options: %w", err)
+	} else if auth != nil {
+		options = append(options, auth)
+	}
+	options = append(options, cmd.ProxyOpts())
+	if tls, err := cmd.TLSOpts(); err != nil {
+		slog.Error("error configuring TLS", "error", err)
+		return fmt.Errorf("error configuring TLS: %w", err)
+	} else if tls != nil {
+		options = append(options, tls)
+	}
+
+	// configure the persistent clone cache
+	if cache, err := cmd.CacheOpts(); err != nil {
+		slog.Error("error configuring clone cache", "error", err)
+		return fmt.Errorf("error configuring clone cache: %w", err)
+	} else {
+		options = append(options, cache...)
+	}
+
+	// configure the mirrors to fall back to
+	if mirrors, err := cmd.MirrorOpts(); err != nil {
+		slog.Error("error configuring mirrors", "error", err)
+		return fmt.Errorf("error configuring mirrors: %w", err)
+	} else {
+		options = append(options, mirrors...)
+	}
+
+	// report the progress of cloning and fetching on the standard error, so
+	// as not to mix it with the output
+	reporter := cmd.Reporter(os.Stderr)
+	options = append(options, repository.WithProgress(reporter))
+
+	// select the fetch strategy
+	if cmd.Tag == nil {
+		slog.Info("no tag specified, using 'latest' as default")
+		cmd.Tag = pointer.To("latest")
+	}
+	options = append(options, cmd.FetchOpts(*cmd.Tag)...)
+
+	// 3. open the archetype source: clone (or fetch into the cache) the remote
+	// archetypal repository and checkout the specified tag, or use the plain
+	// directory or local archive as it is
+	source, description, err := cmd.Source(ctx, options, reporter)
+	if err != nil {
+		return err
+	}
+
+	// 4. locate all the archetype metadata files in the tree
+	paths, err := repository.Archetypes(source)
+	if err != nil {
+		slog.Error("failed to list archetypes in repository", "error", err)
+		return fmt.Errorf("failed to list archetypes in repository: %w", err)
+	}
+	if len(paths) == 0 {
+		slog.Warn("no archetypes found in source", "source", source.String())
+		return fmt.Errorf("no archetypes found in %s", source.String())
+	}
+
+	// 5. load the metadata of each archetype and print a summary
+	fmt.Printf("%s", description)
+	writer := table.NewWriter()
+	writer.SetOutputMirror(os.Stdout)
+	writer.AppendHeader(table.Row{"Path", "Version", "Parameters"})
+	for _, p := range paths {
+		file, err := source.File(path.Join(p, repository.MetadataFile))
+		if err != nil {
+			slog.Error("failed to get archetype metadata file", "path", p, "error", err)
+			return fmt.Errorf("failed to get archetype metadata file for '%s': %w", p, err)
+		}
+		contents, err := file.Contents()
+		if err != nil {
+			slog.Error("failed to get contents of archetype metadata file", "path", p, "error", err)
+			return err
+		}
+		var metadata settings.Metadata
+		if err := yaml.Unmarshal([]byte(contents), &metadata); err != nil {
+			slog.Warn("invalid archetype metadata file", "path", p, "error", err)
+			writer.AppendRow(table.Row{p, "invalid", err.Error()})
+			continue
+		}
+		parameters := make([]string, 0, len(metadata.Parameters))
+		for key := range metadata.Parameters {
+			parameters = append(parameters, key)
+		}
+		sort.Strings(parameters)
+		writer.AppendRow(table.Row{p, metadata.Version, strings.Join(parameters, ", ")})
+	}
+	writer.SetStyle(table.StyleLight)
+	writer.Render()
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package versions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dihedron/archetype/command/base"
+	"github.com/dihedron/archetype/printf"
+	"github.com/dihedron/archetype/repository"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Versions is the command to list the versions of the archetypes in a
+// repository, i.e. its tags and branches.
+type Versions struct {
+	base.Command
+	//lint:ignore SA5008 go-flags uses multiple tags to define aliases and choices
+	Output string `short:"o" long:"output" description:"The output format" choice:"table" choice:"json" default:"table" env:"ARCHETYPE_OUTPUT"`
+}
+
+// Execute is the main entry point for the versions command.
+func (cmd *Versions) Execute(args []string) error {
+
+	slog.Info("executing Versions command")
+
+	ctx, cancel := cmd.Context()
+	defer cancel()
+
+	var options []repository.Option
+
+	// 1. check that the repository URL is specified
+	if len(cmd.URLs) == 0 || cmd.URLs[0] == "" {
+		slog.Error("repository URL not specified in settings")
+		return fmt.Errorf("repository URL not specified in settings")
+	}
+	if err := cmd.NormaliseURL(); err != nil {
+		return err
+	}
+	if repository.IsPlainSource(cmd.URL) {
+		slog.Error("versions require a git repository", "url", cmd.URL)
+		return errors.New("plain directories and archives have no versions")
+	}
+
+	// 2. extract and validate the authentication options, or look up the
+	// credentials, and configure the proxy and TLS
+	if auth, err := cmd.AuthenticationOpts(); err != nil {
+		slog.Error("error validating authentication options", "error", err)
+		return fmt.Errorf("error validating authentication options: %w", err)
+	} else if auth != nil {
+		options = append(options, auth)
+	}
+	options = append(options, cmd.ProxyOpts())
+	if tls, err := cmd.TLSOpts(); err != nil {
+		slog.Error("error configuring TLS", "error", err)
+		return fmt.Errorf("error configuring TLS: %w", err)
+	} else if tls != nil {
+		options = append(options, tls)
+	}
+
+	// configure the persistent clone cache
+	if cache, err := cmd.CacheOpts(); err != nil {
+		slog.Error("error configuring clone cache", "error", err)
+		return fmt.Errorf("error configuring clone cache: %w", err)
+	} else {
+		options = append(options, cache...)
+	}
+
+	// configure the mirrors to fall back to
+	if mirrors, err := cmd.MirrorOpts(); err != nil {
+		slog.Error("error configuring mirrors", "error", err)
+		return fmt.Errorf("error configuring mirrors: %w", err)
+	} else {
+		options = append(options, mirrors...)
+	}
+
+	// report the progress of cloning and fetching on the standard error, so
+	// as not to mix it with the output
+	options = append(options, repository.WithProgress(cmd.Reporter(os.Stderr)))
+
+	// all the tags and branches are needed, whatever the fetch strategy
+	if cmd.Fetch == "shallow" {
+		slog.Warn("shallow fetch strategy ignored, all tags and branches are needed")
+	}
+
+	// 3. clone (or fetch into the cache) the remote archetypal repository
+	repo, err := repository.New(ctx, cmd.URL, options...)
+	if err != nil {
+		slog.Error("failed to clone remote repository", "url", cmd.URL, "error", err)
+		return fmt.Errorf("failed to clone remote repository '%s': %w", cmd.URL, err)
+	}
+
+	// 4. collect the tags and branches and print them
+	versions, err := repo.Versions()
+	if err != nil {
+		slog.Error("failed to list versions", "url", cmd.URL, "error", err)
+		return fmt.Errorf("failed to list versions: %w", err)
+	}
+	if cmd.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(versions)
+	}
+	writer := table.NewWriter()
+	writer.SetOutputMirror(os.Stdout)
+	writer.AppendHeader(table.Row{"Version", "Kind", "Commit", "Date", "Author", "Message"})
+	for _, version := range versions {
+		name := version.Name
+		if version.Default {
+			name = printf.Green(name) + " (default)"
+		} else if version.SemVer != nil && version.SemVer.Prerelease() != "" {
+			name = printf.Yellow(name)
+		}
+		message, _, _ := strings.Cut(version.Message, "\n")
+		writer.AppendRow(table.Row{name, version.Kind, version.Commit[:12], version.Date.Format(time.DateTime), version.Author, message})
+	}
+	writer.SetStyle(table.StyleLight)
+	writer.Render()
+	fmt.Printf("repository: %s\n", printf.Blue(repo.Address()))
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package extensions
 
 import (
+	"context"
 	"io"
 	"net/http"
 
@@ -24,7 +25,24 @@ type Response struct {
 // object, using the rawdata.Unmarshal function, which supports JSON, YAML,
 // TOML and other formats.
 func CallAPI(url string) (*Response, error) {
-	response, err := http.Get(url)
+	return CallAPIContext(context.Background(), url)
+}
+
+// CallAPIContext is like CallAPI, but the request is cancelled along with the
+// given context.
+func CallAPIContext(ctx context.Context, url string) (*Response, error) {
+	return callAPI(ctx, http.DefaultClient, url)
+}
+
+// callAPI makes the request with the given client, which is safe for
+// concurrent use, and returns a new Response for each call, so that templates
+// rendered at the same time share nothing.
+func callAPI(ctx context.Context, client *http.Client, url string) (*Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -1,13 +1,26 @@
 package extensions
 
-import "text/template"
+import (
+	"context"
+	"net/http"
+	"text/template"
+)
 
-// FuncMap returns a map of all the custom functions that can be used in templates.
-func FuncMap() template.FuncMap {
+// FuncMap returns a map of all the custom functions that can be used in templates;
+// the requests made by the api function are cancelled along with the given context
+// and go through the given client (http.DefaultClient if nil), e.g. one configured
+// for a proxy. All the functions are safe for concurrent use, so the map can be
+// shared by templates rendered at the same time.
+func FuncMap(ctx context.Context, client *http.Client) template.FuncMap {
+	if client == nil {
+		client = http.DefaultClient
+	}
 	return template.FuncMap{
-		"include":  Include,
-		"dump":     DumpArgs,
-		"api":      CallAPI,
+		"include": Include,
+		"dump":    DumpArgs,
+		"api": func(url string) (*Response, error) {
+			return callAPI(ctx, client, url)
+		},
 		"fileSize": FileSize,
 		"dirSize":  DirSize,
 		"isFile":   IsFile,
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/dihedron/archetype/command"
+	"github.com/dihedron/archetype/command/base"
 	"github.com/dihedron/archetype/command/prepare"
 	"github.com/jessevdk/go-flags"
 )
@@ -68,6 +69,6 @@ func main() {
 			os.Exit(0)
 		}
 		slog.Error("error parsing command line", "error", err)
-		os.Exit(1)
+		os.Exit(base.ExitStatus(err))
 	}
 }
@@ -0,0 +1,142 @@
+// Package progress defines the events reported while fetching and rendering an
+// archetype, and the renderers showing them to the user: a progress bar on a
+// terminal, plain lines on a pipe, JSON lines for CI, or nothing at all.
+package progress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of an Event.
+type Kind string
+
+const (
+	// Message is a line of information for the user, e.g. the description of
+	// the archetype source.
+	Message Kind = "message"
+	// Clone is a line of progress information sent by the git server while
+	// cloning or fetching.
+	Clone Kind = "clone"
+	// PhaseStarted and PhaseFinished delimit a phase, e.g. fetching the
+	// repository or rendering the files; the latter carries its duration.
+	PhaseStarted  Kind = "phase-started"
+	PhaseFinished Kind = "phase-finished"
+	// FileStarted is reported before processing a file, and then one of
+	// FileSkipped, FileRendered, FileCopied or FileFailed.
+	FileStarted  Kind = "file-started"
+	FileSkipped  Kind = "file-skipped"
+	FileRendered Kind = "file-rendered"
+	FileCopied   Kind = "file-copied"
+	FileFailed   Kind = "file-failed"
+)
+
+// Event is something happening while fetching or rendering an archetype; only
+// the fields relevant to its kind are set.
+type Event struct {
+	Kind Kind      `json:"event"`
+	Time time.Time `json:"time"`
+	// Phase is the name of the phase, for phase events.
+	Phase string `json:"phase,omitempty"`
+	// Total is the number of items in the phase, if known, e.g. of the files
+	// to render.
+	Total int `json:"total,omitempty"`
+	// Duration is the time taken by the phase, for PhaseFinished events.
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	// File is the name of the file in the archetype, for file events.
+	File string `json:"file,omitempty"`
+	// Output is the path the file was written to.
+	Output string `json:"output,omitempty"`
+	// Message is the text of messages and git progress lines, or the reason
+	// why a file was skipped.
+	Message string `json:"message,omitempty"`
+	// Error is the error that made a file fail.
+	Error string `json:"error,omitempty"`
+}
+
+// Reporter receives the events; implementations must be safe for concurrent
+// use.
+type Reporter interface {
+	Report(event Event)
+}
+
+// Discard is a Reporter ignoring all events.
+var Discard Reporter = discard{}
+
+type discard struct{}
+
+func (discard) Report(Event) {}
+
+// Report sends an event of the given kind, timestamped now, to the reporter,
+// which may be nil; the event is filled in by the given function, if any.
+func Report(reporter Reporter, kind Kind, fill func(*Event)) {
+	if reporter == nil {
+		return
+	}
+	event := Event{Kind: kind, Time: time.Now()}
+	if fill != nil {
+		fill(&event)
+	}
+	reporter.Report(event)
+}
+
+// Print reports a message to the reporter, which may be nil.
+func Print(reporter Reporter, text string) {
+	Report(reporter, Message, func(e *Event) { e.Message = text })
+}
+
+// Phase reports the start of a phase with the given number of items (0 if
+// unknown) and returns the function to call when it is over, which reports
+// its duration.
+func Phase(reporter Reporter, phase string, total int) func() {
+	start := time.Now()
+	Report(reporter, PhaseStarted, func(e *Event) {
+		e.Phase = phase
+		e.Total = total
+	})
+	return func() {
+		Report(reporter, PhaseFinished, func(e *Event) {
+			e.Phase = phase
+			e.Total = total
+			e.Duration = time.Since(start)
+		})
+	}
+}
+
+// Writer returns a writer for the progress information sent by git servers
+// (the sideband), which reports each line as a Clone event; it returns nil,
+// so that servers are asked not to send any, if the reporter discards it.
+func Writer(reporter Reporter) io.Writer {
+	if reporter == nil || reporter == Discard {
+		return nil
+	}
+	return &writer{reporter: reporter}
+}
+
+// writer splits the sideband into lines, which git terminates with a carriage
+// return while updating a counter and with a newline when done.
+type writer struct {
+	lock     sync.Mutex
+	reporter Reporter
+	buffer   bytes.Buffer
+}
+
+func (w *writer) Write(data []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buffer.Write(data)
+	for {
+		index := bytes.IndexAny(w.buffer.Bytes(), "\r\n")
+		if index < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.buffer.Next(index + 1)))
+		if line != "" {
+			Report(w.reporter, Clone, func(e *Event) { e.Message = line })
+		}
+	}
+	return len(data), nil
+}
@@ -0,0 +1,138 @@
+package progress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is a Reporter keeping all events.
+type recorder struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Report(event Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestWriter(t *testing.T) {
+	if Writer(Discard) != nil || Writer(nil) != nil {
+		t.Fatalf("expected no sideband writer when discarding progress")
+	}
+	recorder := &recorder{}
+	writer := Writer(recorder)
+	writer.Write([]byte("Counting objects:  50% (1/2)\rCounting obj"))
+	writer.Write([]byte("ects: 100% (2/2), done.\n\nCompressing"))
+	expected := []string{"Counting objects:  50% (1/2)", "Counting objects: 100% (2/2), done."}
+	if len(recorder.events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(recorder.events))
+	}
+	for i, event := range recorder.events {
+		if event.Kind != Clone || event.Message != expected[i] {
+			t.Fatalf("unexpected event %d: %+v", i, event)
+		}
+	}
+}
+
+func TestPlain(t *testing.T) {
+	var buffer bytes.Buffer
+	reporter := Plain(&buffer)
+	done := Phase(reporter, "render", 2)
+	Report(reporter, Clone, func(e *Event) { e.Message = "Counting objects: 1" })
+	Report(reporter, FileStarted, func(e *Event) { e.File = "a.txt" })
+	Report(reporter, FileRendered, func(e *Event) { e.File, e.Output = "a.txt", "out/a.txt" })
+	Report(reporter, FileFailed, func(e *Event) { e.File, e.Error = "b.txt", "broken" })
+	done()
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+	if !strings.Contains(lines[0], "a.txt (saved as out/a.txt)") ||
+		!strings.Contains(lines[1], "b.txt: broken") ||
+		!strings.HasPrefix(lines[2], "render completed in") {
+		t.Fatalf("unexpected output %q", lines)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	reporter := JSON(&buffer)
+	done := Phase(reporter, "render", 1)
+	Report(reporter, FileSkipped, func(e *Event) { e.File, e.Message = "a.txt", "excluded" })
+	done()
+	var kinds []Kind
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		if event.Time.IsZero() {
+			t.Fatalf("event without time: %q", scanner.Text())
+		}
+		kinds = append(kinds, event.Kind)
+	}
+	expected := []Kind{PhaseStarted, FileSkipped, PhaseFinished}
+	if len(kinds) != len(expected) || kinds[0] != expected[0] || kinds[1] != expected[1] || kinds[2] != expected[2] {
+		t.Fatalf("expected events %v, got %v", expected, kinds)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	summary := &Summary{}
+	recorder := &recorder{}
+	reporter := Tee(recorder, nil, summary)
+	Report(reporter, FileStarted, func(e *Event) { e.File = "a.txt" })
+	Report(reporter, FileRendered, func(e *Event) { e.File = "a.txt" })
+	Report(reporter, FileCopied, func(e *Event) { e.File = "b.bin" })
+	Report(reporter, FileSkipped, func(e *Event) { e.File, e.Message = ".archetype/metadata.yml", "archetype metadata" })
+	Report(reporter, FileFailed, func(e *Event) { e.File, e.Error = "c.txt", "broken" })
+	if len(recorder.events) != 5 {
+		t.Fatalf("expected all events to be recorded, got %d", len(recorder.events))
+	}
+	if summary.Rendered() != 1 || summary.Copied() != 1 || len(summary.Skipped()) != 1 || len(summary.Failed()) != 1 {
+		t.Fatalf("unexpected summary: %d rendered, %d copied, %d skipped, %d failed", summary.Rendered(), summary.Copied(), len(summary.Skipped()), len(summary.Failed()))
+	}
+	if failed := summary.Failed()[0]; failed.File != "c.txt" || failed.Error != "broken" {
+		t.Fatalf("unexpected failure: %+v", failed)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	recorder := &recorder{}
+	sequence := NewSequence(recorder, []string{"a.txt", "b.txt", "c.txt", "d.txt"})
+	Report(sequence, PhaseStarted, func(e *Event) { e.Phase = "render" })
+	Report(sequence, FileStarted, func(e *Event) { e.File = "b.txt" })
+	Report(sequence, FileStarted, func(e *Event) { e.File = "a.txt" })
+	Report(sequence, FileRendered, func(e *Event) { e.File = "b.txt" })
+	if len(recorder.events) != 1 {
+		t.Fatalf("expected the events of b.txt to be held back, got %d events", len(recorder.events))
+	}
+	Report(sequence, FileSkipped, func(e *Event) { e.File = "a.txt" })
+	Report(sequence, FileStarted, func(e *Event) { e.File = "d.txt" })
+	Print(sequence, "held back")
+	sequence.Flush()
+
+	order := []string{}
+	for _, event := range recorder.events {
+		order = append(order, string(event.Kind)+" "+event.File+event.Phase+event.Message)
+	}
+	expected := []string{
+		"phase-started render",
+		"file-started a.txt",
+		"file-skipped a.txt",
+		"file-started b.txt",
+		"file-rendered b.txt",
+		"file-started d.txt",
+		"message held back",
+	}
+	if strings.Join(order, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected order of events:\n%s", strings.Join(order, "\n"))
+	}
+}
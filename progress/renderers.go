@@ -0,0 +1,158 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dihedron/archetype/printf"
+	"golang.org/x/term"
+)
+
+// Plain returns a Reporter writing one line per file and phase to the given
+// writer, suitable for pipes and log files; the git server progress is left
+// out.
+func Plain(w io.Writer) Reporter {
+	return &plain{w: w}
+}
+
+type plain struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (p *plain) Report(event Event) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if line := describe(event); line != "" {
+		fmt.Fprintln(p.w, line)
+	}
+}
+
+// describe returns the line describing the event for humans, or an empty
+// string for the events not worth a line of their own.
+func describe(event Event) string {
+	switch event.Kind {
+	case Message:
+		return event.Message
+	case PhaseFinished:
+		if event.Total > 0 {
+			return fmt.Sprintf("%s completed in %s (%d files)", event.Phase, round(event.Duration), event.Total)
+		}
+		return fmt.Sprintf("%s completed in %s", event.Phase, round(event.Duration))
+	case FileSkipped:
+		return fmt.Sprintf("%s %s (%s)", printf.Yellow("SKIPPED"), event.File, event.Message)
+	case FileRendered:
+		return fmt.Sprintf("%s %s (saved as %s)", printf.Green("SUCCESS"), event.File, event.Output)
+	case FileCopied:
+		return fmt.Sprintf("%s %s (copied as %s)", printf.Green("SUCCESS"), event.File, event.Output)
+	case FileFailed:
+		return fmt.Sprintf("%s %s: %s", printf.Red("ERROR"), event.File, event.Error)
+	}
+	return ""
+}
+
+// round rounds durations to a readable precision.
+func round(duration time.Duration) time.Duration {
+	if duration < time.Second {
+		return duration.Round(time.Millisecond)
+	}
+	return duration.Round(10 * time.Millisecond)
+}
+
+// JSON returns a Reporter writing each event as a line of JSON to the given
+// writer, for CI systems and other tools.
+func JSON(w io.Writer) Reporter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &jsonLines{encoder: encoder}
+}
+
+type jsonLines struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+func (j *jsonLines) Report(event Event) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.encoder.Encode(event)
+}
+
+// Bar returns a Reporter drawing a progress bar, along with the git server
+// progress and the file being processed, on the last line of the given
+// terminal, and printing skipped and failed files and finished phases above
+// it.
+func Bar(terminal *os.File) Reporter {
+	return &bar{terminal: terminal}
+}
+
+type bar struct {
+	lock     sync.Mutex
+	terminal *os.File
+	total    int
+	done     int
+	status   string
+	drawn    bool
+}
+
+func (b *bar) Report(event Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch event.Kind {
+	case Clone:
+		b.status = event.Message
+	case PhaseStarted:
+		b.total, b.done = event.Total, 0
+		b.status = event.Phase + "..."
+	case FileStarted:
+		b.status = event.File
+	case FileSkipped, FileRendered, FileCopied, FileFailed:
+		b.done++
+	}
+	switch event.Kind {
+	case Message, FileSkipped, FileFailed, PhaseFinished:
+		b.clear()
+		fmt.Fprintln(b.terminal, describe(event))
+	}
+	if event.Kind == PhaseFinished {
+		b.total, b.done, b.status = 0, 0, ""
+		return
+	}
+	b.draw()
+}
+
+// clear erases the bar, if drawn.
+func (b *bar) clear() {
+	if b.drawn {
+		fmt.Fprint(b.terminal, "\r\033[K")
+		b.drawn = false
+	}
+}
+
+// draw redraws the bar, fitting it to the width of the terminal.
+func (b *bar) draw() {
+	if b.total == 0 && b.status == "" {
+		return
+	}
+	width, _, err := term.GetSize(int(b.terminal.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	line := ""
+	if b.total > 0 {
+		const size = 30
+		filled := size * b.done / b.total
+		line = fmt.Sprintf("[%s%s] %d/%d ", strings.Repeat("=", filled), strings.Repeat(" ", size-filled), b.done, b.total)
+	}
+	line += b.status
+	if runes := []rune(line); len(runes) > width-1 {
+		line = string(runes[:width-1])
+	}
+	fmt.Fprint(b.terminal, "\r\033[K"+line)
+	b.drawn = true
+}
@@ -0,0 +1,91 @@
+package progress
+
+import "sync"
+
+// Sequence is a Reporter putting back in order the events of files processed
+// concurrently: the events of each file are held back until it is done, i.e.
+// skipped, rendered, copied or failed, and all the files before it in the
+// given order have been released. Other events go through at once, unless
+// some file events are held back, in which case they follow them.
+type Sequence struct {
+	lock     sync.Mutex
+	reporter Reporter
+	index    map[string]int
+	pending  map[int][]Event
+	done     map[int]bool
+	next     int
+	trailing []Event
+}
+
+// NewSequence returns a Sequence releasing the events of the files with the
+// given names, in this order, to the given reporter, which may be nil.
+func NewSequence(reporter Reporter, names []string) *Sequence {
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		index[name] = i
+	}
+	return &Sequence{
+		reporter: reporter,
+		index:    index,
+		pending:  map[int][]Event{},
+		done:     map[int]bool{},
+	}
+}
+
+func (s *Sequence) Report(event Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i, ok := s.index[event.File]
+	if !ok || event.File == "" || i < s.next {
+		if len(s.pending) > 0 || len(s.trailing) > 0 {
+			s.trailing = append(s.trailing, event)
+		} else {
+			s.release(event)
+		}
+		return
+	}
+	s.pending[i] = append(s.pending[i], event)
+	switch event.Kind {
+	case FileSkipped, FileRendered, FileCopied, FileFailed:
+		s.done[i] = true
+	}
+	for s.done[s.next] {
+		for _, event := range s.pending[s.next] {
+			s.release(event)
+		}
+		delete(s.pending, s.next)
+		delete(s.done, s.next)
+		s.next++
+	}
+	if len(s.pending) == 0 {
+		for _, event := range s.trailing {
+			s.release(event)
+		}
+		s.trailing = nil
+	}
+}
+
+// Flush releases all the events held back, in the order of the files, e.g.
+// once the iteration stopped before processing some of them.
+func (s *Sequence) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := s.next; len(s.pending) > 0; i++ {
+		for _, event := range s.pending[i] {
+			s.release(event)
+		}
+		delete(s.pending, i)
+		delete(s.done, i)
+	}
+	for _, event := range s.trailing {
+		s.release(event)
+	}
+	s.trailing = nil
+	s.next = len(s.index)
+}
+
+func (s *Sequence) release(event Event) {
+	if s.reporter != nil {
+		s.reporter.Report(event)
+	}
+}
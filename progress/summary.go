@@ -0,0 +1,72 @@
+package progress
+
+import "sync"
+
+// Summary is a Reporter keeping count of the files rendered and copied, and
+// track of those skipped and failed along with the reasons why, to sum up an
+// iteration over the files once it is over.
+type Summary struct {
+	lock     sync.Mutex
+	rendered int
+	copied   int
+	skipped  []Event
+	failed   []Event
+}
+
+func (s *Summary) Report(event Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch event.Kind {
+	case FileRendered:
+		s.rendered++
+	case FileCopied:
+		s.copied++
+	case FileSkipped:
+		s.skipped = append(s.skipped, event)
+	case FileFailed:
+		s.failed = append(s.failed, event)
+	}
+}
+
+// Rendered and Copied return the number of files rendered and copied.
+func (s *Summary) Rendered() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rendered
+}
+
+func (s *Summary) Copied() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.copied
+}
+
+// Skipped and Failed return the events of the files skipped, with the reason
+// in their Message, and failed, with their Error, in the order they occurred.
+func (s *Summary) Skipped() []Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Event(nil), s.skipped...)
+}
+
+func (s *Summary) Failed() []Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Event(nil), s.failed...)
+}
+
+// Tee returns a Reporter sending each event to all the given reporters, which
+// may be nil.
+func Tee(reporters ...Reporter) Reporter {
+	return tee(reporters)
+}
+
+type tee []Reporter
+
+func (t tee) Report(event Event) {
+	for _, reporter := range t {
+		if reporter != nil {
+			reporter.Report(event)
+		}
+	}
+}
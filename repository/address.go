@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SupportedSchemes is the list of URL schemes accepted in repository addresses.
+var SupportedSchemes = []string{"file", "ssh", "git", "http", "https"}
+
+var (
+	// scpLike matches the scp-like syntax for SSH addresses, [user@]host:path.
+	scpLike = regexp.MustCompile(`^(?:([^@/:]+)@)?([^@/:]+):(.+)$`)
+	// shorthandLike matches the <name>:<path> syntax of host shorthands.
+	shorthandLike = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_.+-]*):(.+)$`)
+	// driveLetter matches the beginning of an absolute path on Windows.
+	driveLetter = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+	// schemeLike matches the scheme of a URL.
+	schemeLike = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*)://`)
+)
+
+// WithShorthands configures the host shorthands to expand in the repository
+// address: each maps a name to the address prefix it stands for, so that with
+// "corp" mapped to "git@git.corp.example.com:" the address "corp:team/archetype"
+// becomes "git@git.corp.example.com:team/archetype".
+func WithShorthands(shorthands map[string]string) Option {
+	return func(repository *Repository) error {
+		repository.shorthands = shorthands
+		return nil
+	}
+}
+
+// NormaliseAddress turns the many ways of addressing a repository into one of
+// the forms understood by the rest of the package: host shorthands are
+// expanded, the scp-like syntax ([user@]host:path) is kept as it is, local
+// paths (relative, absolute or starting with ~) become absolute file:// URLs,
+// and URLs are checked against the supported schemes. A trailing //subdir
+// path selector is preserved.
+func NormaliseAddress(address string, shorthands map[string]string) (string, error) {
+	address, subdirectory := SplitAddress(strings.TrimSpace(address))
+	normalised, err := normaliseAddress(address, shorthands, true)
+	if err != nil {
+		slog.Error("invalid repository address", "address", address, "error", err)
+		return "", err
+	}
+	if subdirectory != "" {
+		normalised += "//" + subdirectory
+	}
+	if normalised != address {
+		slog.Debug("repository address normalised", "address", address, "normalised", normalised)
+	}
+	return normalised, nil
+}
+
+func normaliseAddress(address string, shorthands map[string]string, expand bool) (string, error) {
+	// 1. the current directory
+	if address == "" || address == "." {
+		return localAddress(".")
+	}
+
+	// 2. URLs, with a supported scheme
+	if match := schemeLike.FindStringSubmatch(address); match != nil {
+		scheme := strings.ToLower(match[1])
+		switch scheme {
+		case "git+ssh", "ssh+git":
+			scheme = "ssh"
+		}
+		if !slices.Contains(SupportedSchemes, scheme) {
+			return "", fmt.Errorf("unsupported scheme '%s' (supported schemes: %s)", match[1], strings.Join(SupportedSchemes, ", "))
+		}
+		rest := address[len(match[0]):]
+		if scheme == "file" {
+			return localAddress(rest)
+		}
+		return scheme + "://" + rest, nil
+	}
+
+	// 3. paths relative to a home directory
+	if strings.HasPrefix(address, "~") {
+		return localAddress(address)
+	}
+
+	// 4. host shorthands
+	if match := shorthandLike.FindStringSubmatch(address); match != nil && expand {
+		if prefix, ok := shorthands[match[1]]; ok {
+			slog.Debug("expanding host shorthand", "shorthand", match[1], "prefix", prefix)
+			return normaliseAddress(prefix+match[2], shorthands, false)
+		}
+	}
+
+	// 5. scp-like SSH addresses, unless they are Windows paths or existing
+	// local paths that happen to contain a colon
+	if !driveLetter.MatchString(address) && scpLike.MatchString(address) {
+		if _, err := os.Stat(address); err != nil {
+			return address, nil
+		}
+	}
+
+	// 6. anything else is a local path
+	return localAddress(address)
+}
+
+// localAddress returns the absolute file:// URL of the given local path,
+// expanding a leading ~ or ~user to the corresponding home directory.
+func localAddress(location string) (string, error) {
+	if strings.HasPrefix(location, "~") {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(location, "~"), "/")
+		var home string
+		if name == "" {
+			directory, err := os.UserHomeDir()
+			if err != nil {
+				slog.Error("failed to get user home directory", "error", err)
+				return "", err
+			}
+			home = directory
+		} else {
+			account, err := user.Lookup(name)
+			if err != nil {
+				slog.Error("failed to look up user", "user", name, "error", err)
+				return "", fmt.Errorf("cannot expand '~%s': %w", name, err)
+			}
+			home = account.HomeDir
+		}
+		location = filepath.Join(home, rest)
+	}
+	absolute, err := filepath.Abs(filepath.FromSlash(location))
+	if err != nil {
+		slog.Error("failed to get absolute path", "path", location, "error", err)
+		return "", err
+	}
+	absolute = filepath.ToSlash(absolute)
+	if !strings.HasPrefix(absolute, "/") {
+		// Windows paths, e.g. C:/archetypes
+		absolute = "/" + absolute
+	}
+	return "file://" + absolute, nil
+}
+
+// IsSSHAddress returns whether the given address is reached over SSH, either
+// as an ssh:// URL or in the scp-like syntax.
+func IsSSHAddress(address string) bool {
+	if match := schemeLike.FindStringSubmatch(address); match != nil {
+		return strings.EqualFold(match[1], "ssh")
+	}
+	return scpLike.MatchString(address) && !driveLetter.MatchString(address)
+}
+
+// IsHTTPAddress returns whether the given address is reached over HTTP(S).
+func IsHTTPAddress(address string) bool {
+	if match := schemeLike.FindStringSubmatch(address); match != nil {
+		return strings.EqualFold(match[1], "http") || strings.EqualFold(match[1], "https")
+	}
+	return false
+}
+
+// isRemoteAddress returns whether the given normalised address is reached
+// through a git transport, as opposed to being opened on the local filesystem.
+func isRemoteAddress(address string) bool {
+	if match := schemeLike.FindStringSubmatch(address); match != nil {
+		return !strings.EqualFold(match[1], "file")
+	}
+	return IsSSHAddress(address)
+}
+
+// scpToURL converts an scp-like address into the equivalent ssh:// URL, for
+// purposes (e.g. computing cache keys) where the exact path semantics do not
+// matter; other addresses are returned as they are.
+func scpToURL(address string) string {
+	if schemeLike.MatchString(address) || driveLetter.MatchString(address) {
+		return address
+	}
+	if match := scpLike.FindStringSubmatch(address); match != nil {
+		host := match[2]
+		if match[1] != "" {
+			host = match[1] + "@" + host
+		}
+		return "ssh://" + host + "/" + strings.TrimPrefix(match[3], "/")
+	}
+	return address
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormaliseAddress(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("no home directory: %v", err)
+	}
+	working, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("cannot get working directory: %v", err)
+	}
+	shorthands := map[string]string{
+		"corp": "git@git.corp.example.com:",
+		"gh":   "https://github.com/",
+	}
+	for _, test := range []struct {
+		address  string
+		expected string
+	}{
+		{"https://github.com/example/archetype.git", "https://github.com/example/archetype.git"},
+		{"HTTPS://github.com/example/archetype.git", "https://github.com/example/archetype.git"},
+		{"ssh://git@github.com/example/archetype.git", "ssh://git@github.com/example/archetype.git"},
+		{"git+ssh://git@github.com/example/archetype.git", "ssh://git@github.com/example/archetype.git"},
+		{"git@github.com:example/archetype.git", "git@github.com:example/archetype.git"},
+		{"github.com:example/archetype.git", "github.com:example/archetype.git"},
+		{"git@github.com:example/archetype.git//go-service", "git@github.com:example/archetype.git//go-service"},
+		{"corp:team/archetype", "git@git.corp.example.com:team/archetype"},
+		{"gh:example/archetype//helm-chart", "https://github.com/example/archetype//helm-chart"},
+		{"/srv/archetypes", "file:///srv/archetypes"},
+		{"file:///srv/archetypes", "file:///srv/archetypes"},
+		{"~/archetypes", "file://" + filepath.ToSlash(filepath.Join(home, "archetypes"))},
+		{"./archetypes", "file://" + filepath.ToSlash(filepath.Join(working, "archetypes"))},
+		{"archetypes", "file://" + filepath.ToSlash(filepath.Join(working, "archetypes"))},
+		{".", "file://" + filepath.ToSlash(working)},
+		{"", "file://" + filepath.ToSlash(working)},
+	} {
+		got, err := NormaliseAddress(test.address, shorthands)
+		if err != nil {
+			t.Errorf("NormaliseAddress(%q): unexpected error %v", test.address, err)
+		} else if got != test.expected {
+			t.Errorf("NormaliseAddress(%q): expected %q, got %q", test.address, test.expected, got)
+		}
+	}
+
+	for _, address := range []string{"ftp://example.com/archetype.git", "s3://bucket/archetype"} {
+		if _, err := NormaliseAddress(address, nil); err == nil {
+			t.Errorf("NormaliseAddress(%q): expected an error for an unsupported scheme", address)
+		}
+	}
+}
+
+func TestAddressKinds(t *testing.T) {
+	if !IsSSHAddress("git@github.com:example/archetype.git") || !IsSSHAddress("ssh://github.com/example/archetype.git") {
+		t.Errorf("SSH addresses not recognised")
+	}
+	if IsSSHAddress("https://github.com/example/archetype.git") || IsSSHAddress("file:///srv/archetypes") {
+		t.Errorf("non-SSH addresses recognised as SSH")
+	}
+	if !IsHTTPAddress("https://github.com/example/archetype.git") || IsHTTPAddress("git@github.com:example/archetype.git") {
+		t.Errorf("HTTP addresses not recognised")
+	}
+	if CacheURL("git@GitHub.com:example/archetype.git") != CacheURL("ssh://github.com/example/archetype") {
+		t.Errorf("scp-like and ssh:// addresses map to different cache entries")
+	}
+}
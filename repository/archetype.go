@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	// MetadataDirectory is the directory holding the archetype metadata, at
+	// the root of each archetype in the repository.
+	MetadataDirectory = ".archetype"
+	// MetadataFile is the path of the archetype metadata file, relative to the
+	// root of the archetype.
+	MetadataFile = MetadataDirectory + "/metadata.yml"
+)
+
+// SplitAddress splits an address in the repo//subdir form into the repository
+// address and the path of the archetype inside the repository; the double
+// slash after the scheme (e.g. https://) is not taken as a separator. If there
+// is no path selector, the path is empty.
+func SplitAddress(address string) (string, string) {
+	start := 0
+	if index := strings.Index(address, "://"); index >= 0 {
+		start = index + len("://")
+	}
+	index := strings.Index(address[start:], "//")
+	if index < 0 {
+		return address, ""
+	}
+	index += start
+	return address[:index], cleanPath(address[index+2:])
+}
+
+// WithPath roots the archetype at the given subdirectory of the repository:
+// the metadata is read from the .archetype directory under it, only the files
+// under it are visited, and their names are relative to it. It takes
+// precedence over the path in the repo//subdir form of the address.
+func WithPath(path string) Option {
+	return func(repository *Repository) error {
+		repository.path = cleanPath(path)
+		return nil
+	}
+}
+
+// Path returns the path of the archetype inside the repository, or an empty
+// string if the archetype is at the repository root.
+func (r *Repository) Path() string {
+	return r.path
+}
+
+// relative returns the name of the given file relative to the archetype path,
+// and whether the file is under that path at all.
+func (r *Repository) relative(name string) (string, bool) {
+	if r.path == "" {
+		return name, true
+	}
+	if !strings.HasPrefix(name, r.path+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(name, r.path+"/"), true
+}
+
+// cleanPath normalises a path inside the repository, removing the leading and
+// trailing slashes; the repository root is returned as an empty string.
+func cleanPath(p string) string {
+	p = strings.Trim(path.Clean("/"+strings.TrimSpace(p)), "/")
+	if p == "." {
+		return ""
+	}
+	return p
+}
@@ -0,0 +1,25 @@
+package repository
+
+import "testing"
+
+func TestSplitAddress(t *testing.T) {
+	for _, test := range []struct {
+		address    string
+		repository string
+		path       string
+	}{
+		{"https://github.com/example/archetypes.git", "https://github.com/example/archetypes.git", ""},
+		{"https://github.com/example/archetypes.git//go-service", "https://github.com/example/archetypes.git", "go-service"},
+		{"https://github.com/example/archetypes//java/lib/", "https://github.com/example/archetypes", "java/lib"},
+		{"ssh://git@github.com/example/archetypes.git//helm-chart", "ssh://git@github.com/example/archetypes.git", "helm-chart"},
+		{"git@github.com:example/archetypes.git//helm-chart", "git@github.com:example/archetypes.git", "helm-chart"},
+		{"file:///srv/archetypes//go-service", "file:///srv/archetypes", "go-service"},
+		{"file://./", "file://./", ""},
+		{"https://github.com/example/archetypes//", "https://github.com/example/archetypes", ""},
+	} {
+		repository, path := SplitAddress(test.address)
+		if repository != test.repository || path != test.path {
+			t.Errorf("SplitAddress(%q): expected (%q, %q), got (%q, %q)", test.address, test.repository, test.path, repository, path)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// archiveFormat returns the format of the archive at the given location as
+// inferred from its extension ("tar", "tar.gz" or "zip"), or an empty string
+// if it is not a supported archive.
+func archiveFormat(location string) string {
+	name := strings.ToLower(location)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// archiveSource is the Source of the files in a local tar or zip archive; the
+// archive is read into memory when opened. If all the entries are under a
+// single top-level directory, as in the archives produced by git hosting
+// services, that directory is taken as the root of the archive; as for plain
+// directories, the .git directory is skipped.
+type archiveSource struct {
+	location string
+	filter   *Repository
+	files    map[string]*archiveFile
+}
+
+// openArchive reads the archive at the given location.
+func openArchive(location string, filter *Repository) (*archiveSource, error) {
+	var (
+		entries []*archiveFile
+		err     error
+	)
+	switch archiveFormat(location) {
+	case "tar":
+		entries, err = readTar(location, false)
+	case "tar.gz":
+		entries, err = readTar(location, true)
+	case "zip":
+		entries, err = readZip(location)
+	default:
+		err = fmt.Errorf("unsupported archive format")
+	}
+	if err != nil {
+		slog.Error("error reading archive", "archive", location, "error", err)
+		return nil, fmt.Errorf("error reading archive '%s': %w", location, err)
+	}
+
+	// strip the single top-level directory, if any
+	root := ""
+	for i, entry := range entries {
+		top, _, nested := strings.Cut(entry.name, "/")
+		if !nested || (i > 0 && top != root) {
+			root = ""
+			break
+		}
+		root = top
+	}
+	source := &archiveSource{
+		location: location,
+		filter:   filter,
+		files:    map[string]*archiveFile{},
+	}
+	for _, entry := range entries {
+		if root != "" {
+			entry.name = strings.TrimPrefix(entry.name, root+"/")
+		}
+		if entry.name == ".git" || strings.HasPrefix(entry.name, ".git/") {
+			continue
+		}
+		source.files[entry.name] = entry
+	}
+	slog.Debug("archive read", "archive", location, "entries", len(entries), "root", root)
+	return source, nil
+}
+
+func (s *archiveSource) Files() ([]File, error) {
+	files := []File{}
+	for _, entry := range s.files {
+		if name, ok := s.filter.include(entry.name); ok {
+			files = append(files, &archiveFile{name: name, mode: entry.mode, data: entry.data})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+func (s *archiveSource) File(name string) (File, error) {
+	entry, ok := s.files[path.Join(s.filter.path, name)]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return &archiveFile{name: name, mode: entry.mode, data: entry.data}, nil
+}
+
+func (s *archiveSource) String() string {
+	if s.filter.path != "" {
+		return fmt.Sprintf("archive %s (path %s)", s.location, s.filter.path)
+	}
+	return fmt.Sprintf("archive %s", s.location)
+}
+
+// archiveFile is a File in a local archive, held in memory.
+type archiveFile struct {
+	name string
+	mode fs.FileMode
+	data []byte
+}
+
+func (f *archiveFile) Name() string {
+	return f.name
+}
+
+func (f *archiveFile) Mode() fs.FileMode {
+	return f.mode
+}
+
+func (f *archiveFile) Size() int64 {
+	return int64(len(f.data))
+}
+
+func (f *archiveFile) Hash() plumbing.Hash {
+	return blobHash(f.data)
+}
+
+func (f *archiveFile) Reader() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (f *archiveFile) Contents() (string, error) {
+	return string(f.data), nil
+}
+
+// readTar reads the regular files and symbolic links in a tar archive,
+// optionally gzip-compressed.
+func readTar(location string, compressed bool) ([]*archiveFile, error) {
+	file, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	entries := []*archiveFile{}
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name, ok := archiveName(header.Name)
+		if !ok {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			data, err := io.ReadAll(archive)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &archiveFile{name: name, mode: fs.FileMode(header.Mode).Perm(), data: data})
+		case tar.TypeSymlink:
+			entries = append(entries, &archiveFile{name: name, mode: fs.ModeSymlink | 0777, data: []byte(header.Linkname)})
+		default:
+			slog.Debug("skipping archive entry", "name", header.Name, "type", header.Typeflag)
+		}
+	}
+	return entries, nil
+}
+
+// readZip reads the regular files and symbolic links in a zip archive.
+func readZip(location string) ([]*archiveFile, error) {
+	archive, err := zip.OpenReader(location)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	entries := []*archiveFile{}
+	for _, file := range archive.File {
+		name, ok := archiveName(file.Name)
+		if !ok || file.FileInfo().IsDir() {
+			continue
+		}
+		mode := file.Mode()
+		if !mode.IsRegular() && mode&fs.ModeSymlink == 0 {
+			slog.Debug("skipping archive entry", "name", file.Name, "mode", mode)
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if mode.IsRegular() {
+			mode = mode.Perm()
+		}
+		entries = append(entries, &archiveFile{name: name, mode: mode, data: data})
+	}
+	return entries, nil
+}
+
+// archiveName normalises the name of an archive entry, and returns false for
+// entries that would escape the archive root.
+func archiveName(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+	return name, true
+}
@@ -7,14 +7,18 @@ import (
 	"github.com/go-git/go-git/v6/plumbing"
 )
 
-// Branch returns the reference to the given branch.
+// Branch returns the reference to the given branch; a missing branch is only
+// logged at debug level, since callers often try other names next.
 func (r *Repository) Branch(name string) (*plumbing.Reference, error) {
 	if r == nil || r.repository == nil {
 		slog.Error("repository not initialized")
 		return nil, errors.New("repository not initialized")
 	}
 	reference, err := r.repository.Reference(plumbing.NewBranchReferenceName(name), true)
-	if err != nil {
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		slog.Debug("branch not found", "name", name)
+		return nil, err
+	} else if err != nil {
 		slog.Error("failed to get branch", "name", name, "error", err)
 		return nil, err
 	}
@@ -25,7 +29,12 @@ func (r *Repository) Branch(name string) (*plumbing.Reference, error) {
 // It first tries to find a branch named "main", and if it does not exist,
 // it falls back to "master".
 func (r *Repository) MainBranch() (*plumbing.Reference, error) {
-	reference, err := r.Branch("main")
+	if r == nil || r.repository == nil {
+		slog.Error("repository not initialized")
+		return nil, errors.New("repository not initialized")
+	}
+	// a missing "main" is no error, so it is looked up without logging one
+	reference, err := r.repository.Reference(plumbing.NewBranchReferenceName("main"), true)
 	if errors.Is(err, plumbing.ErrReferenceNotFound) {
 		slog.Debug("branch 'main' not found, trying 'master'...")
 		// try "master" as a fallback
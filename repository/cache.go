@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// CacheEntryFile is the name of the file holding the metadata of a cache
+	// entry, stored alongside the bare clone.
+	CacheEntryFile = "entry.json"
+	// CacheRepositoryDirectory is the name of the directory holding the bare
+	// clone inside a cache entry.
+	CacheRepositoryDirectory = "repository"
+	// CacheLFSDirectory is the name of the directory holding the Git LFS
+	// objects of the repository inside a cache entry.
+	CacheLFSDirectory = "lfs"
+	// CacheLockSuffix is the suffix of the files, next to the entries, locked
+	// while an entry is being fetched into or removed.
+	CacheLockSuffix = ".lock"
+)
+
+// Cache is a persistent, on-disk store of bare clones of remote repositories;
+// each repository is stored in a directory whose name is the SHA-256 hash of
+// its normalised URL, so that the same repository addressed in slightly
+// different ways (e.g. with or without the trailing .git) maps to the same
+// entry and can be fetched incrementally. The cache may be shared by several
+// processes at once: each entry is locked, through a file lock, while it is
+// being fetched into or removed.
+type Cache struct {
+	directory string
+}
+
+// CacheEntry describes a single repository stored in the cache.
+type CacheEntry struct {
+	Key      string    `json:"key" yaml:"key"`
+	URL      string    `json:"url" yaml:"url"`
+	Path     string    `json:"path" yaml:"path"`
+	Size     int64     `json:"size" yaml:"size"`
+	Created  time.Time `json:"created" yaml:"created"`
+	LastUsed time.Time `json:"lastUsed" yaml:"lastUsed"`
+}
+
+// DefaultCacheDirectory returns the default location of the clone cache,
+// under the user cache directory (e.g. ~/.cache/archetype/repositories).
+func DefaultCacheDirectory() (string, error) {
+	directory, err := os.UserCacheDir()
+	if err != nil {
+		slog.Error("failed to get user cache directory", "error", err)
+		return "", err
+	}
+	return filepath.Join(directory, "archetype", "repositories"), nil
+}
+
+// NewCache creates a new Cache rooted at the given directory, creating the
+// directory if it does not exist; if the directory is empty, the default cache
+// directory is used.
+func NewCache(directory string) (*Cache, error) {
+	if directory == "" {
+		var err error
+		if directory, err = DefaultCacheDirectory(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		slog.Error("failed to create cache directory", "directory", directory, "error", err)
+		return nil, fmt.Errorf("failed to create cache directory '%s': %w", directory, err)
+	}
+	slog.Debug("using clone cache", "directory", directory)
+	return &Cache{
+		directory: directory,
+	}, nil
+}
+
+// Directory returns the root directory of the cache.
+func (c *Cache) Directory() string {
+	return c.directory
+}
+
+// Key returns the cache key for the given repository address, i.e. the
+// hex-encoded SHA-256 hash of its normalised form.
+func (c *Cache) Key(address string) string {
+	hash := sha256.Sum256([]byte(CacheURL(address)))
+	return hex.EncodeToString(hash[:])
+}
+
+// Path returns the path to the bare clone of the given repository address.
+func (c *Cache) Path(address string) string {
+	return filepath.Join(c.directory, c.Key(address), CacheRepositoryDirectory)
+}
+
+// LFSPath returns the path to the Git LFS store of the given repository address.
+func (c *Cache) LFSPath(address string) string {
+	return filepath.Join(c.directory, c.Key(address), CacheLFSDirectory)
+}
+
+// Has returns whether the cache already contains a clone of the given address.
+func (c *Cache) Has(address string) bool {
+	info, err := os.Stat(c.Path(address))
+	return err == nil && info.IsDir()
+}
+
+// Entries returns the list of repositories in the cache, sorted by URL.
+func (c *Cache) Entries() ([]*CacheEntry, error) {
+	items, err := os.ReadDir(c.directory)
+	if err != nil {
+		slog.Error("failed to read cache directory", "directory", c.directory, "error", err)
+		return nil, err
+	}
+	entries := []*CacheEntry{}
+	for _, item := range items {
+		if !item.IsDir() {
+			continue
+		}
+		entry, err := c.entry(item.Name())
+		if err != nil {
+			slog.Warn("skipping invalid cache entry", "key", item.Name(), "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+	return entries, nil
+}
+
+// Remove deletes the cache entry with the given key.
+func (c *Cache) Remove(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\.`) {
+		return fmt.Errorf("invalid cache key '%s'", key)
+	}
+	unlock, err := c.lock(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	slog.Debug("removing cache entry", "key", key)
+	if err := os.RemoveAll(filepath.Join(c.directory, key)); err != nil {
+		slog.Error("failed to remove cache entry", "key", key, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Prune removes all the entries that have not been used for longer than the
+// given duration, and returns the list of removed entries.
+func (c *Cache) Prune(age time.Duration) ([]*CacheEntry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	threshold := time.Now().Add(-age)
+	removed := []*CacheEntry{}
+	for _, entry := range entries {
+		if entry.LastUsed.Before(threshold) {
+			if err := c.Remove(entry.Key); err != nil {
+				return removed, err
+			}
+			removed = append(removed, entry)
+		}
+	}
+	return removed, nil
+}
+
+// Clear removes all the entries in the cache.
+func (c *Cache) Clear() error {
+	items, err := os.ReadDir(c.directory)
+	if err != nil {
+		slog.Error("failed to read cache directory", "directory", c.directory, "error", err)
+		return err
+	}
+	for _, item := range items {
+		if !item.IsDir() {
+			continue
+		}
+		if err := c.Remove(item.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock takes the lock of the entry with the given key, waiting for any other
+// process holding it, and returns the function releasing it; the lock files
+// are left in place, so that all the processes lock the same file.
+func (c *Cache) lock(key string) (func(), error) {
+	location := filepath.Join(c.directory, key+CacheLockSuffix)
+	file, err := os.OpenFile(location, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		slog.Error("failed to open cache lock", "path", location, "error", err)
+		return nil, fmt.Errorf("failed to open cache lock '%s': %w", location, err)
+	}
+	slog.Debug("locking cache entry", "key", key)
+	if err := lockFile(file); err != nil {
+		file.Close()
+		slog.Error("failed to lock cache entry", "key", key, "error", err)
+		return nil, fmt.Errorf("failed to lock cache entry '%s': %w", key, err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// touch records that the given address has just been used, creating the
+// entry metadata if necessary.
+func (c *Cache) touch(address string) error {
+	key := c.Key(address)
+	entry, err := c.entry(key)
+	if err != nil {
+		entry = &CacheEntry{
+			Key:     key,
+			URL:     CacheURL(address),
+			Created: time.Now(),
+		}
+	}
+	entry.LastUsed = time.Now()
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.directory, key, CacheEntryFile), data, 0644)
+}
+
+// entry reads the metadata of the cache entry with the given key.
+func (c *Cache) entry(key string) (*CacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(c.directory, key, CacheEntryFile))
+	if err != nil {
+		return nil, err
+	}
+	entry := &CacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	entry.Key = key
+	entry.Path = filepath.Join(c.directory, key, CacheRepositoryDirectory)
+	entry.Size = 0
+	err = filepath.WalkDir(filepath.Join(c.directory, key), func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				entry.Size += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// CacheURL returns the normalised form of the given address used to compute
+// the cache key: scp-like addresses are turned into ssh:// URLs, the scheme and
+// host are lower-cased, the user information is dropped, and any trailing
+// slash or .git suffix is removed.
+func CacheURL(address string) string {
+	address = scpToURL(strings.TrimSpace(address))
+	if parsed, err := url.Parse(address); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		parsed.Scheme = strings.ToLower(parsed.Scheme)
+		parsed.Host = strings.ToLower(parsed.Host)
+		parsed.User = nil
+		parsed.RawQuery = ""
+		parsed.Fragment = ""
+		address = parsed.String()
+	}
+	address = strings.TrimRight(address, "/")
+	address = strings.TrimSuffix(address, ".git")
+	return address
+}
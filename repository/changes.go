@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/utils/merkletrie"
+)
+
+const (
+	// Added, Removed, Modified and Renamed are the actions of a Change.
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+	Renamed  = "renamed"
+)
+
+// Change is a file of the archetype added, removed, modified or renamed
+// between two commits.
+type Change struct {
+	// Action is one of Added, Removed, Modified and Renamed.
+	Action string `json:"action"`
+	// Name is the name of the file relative to the archetype path; for
+	// removed files, it is the name they had.
+	Name string `json:"name"`
+	// Previous is the name the file had before being renamed.
+	Previous string `json:"previous,omitempty"`
+	// Binary is set for binary files, whose patch has no contents.
+	Binary bool `json:"binary,omitempty"`
+	// Patch is the unified diff of the file.
+	Patch string `json:"patch,omitempty"`
+}
+
+// Changes returns the changes to the files of the archetype, i.e. under the
+// archetype path and the sparse prefix, between the given commits, sorted by
+// name, along with their unified diffs; renames are detected.
+func (r *Repository) Changes(ctx context.Context, from *object.Commit, to *object.Commit) ([]*Change, error) {
+	before, err := from.Tree()
+	if err != nil {
+		slog.Error("failed to get tree of commit", "commit", from.Hash.String(), "error", err)
+		return nil, err
+	}
+	after, err := to.Tree()
+	if err != nil {
+		slog.Error("failed to get tree of commit", "commit", to.Hash.String(), "error", err)
+		return nil, err
+	}
+	differences, err := object.DiffTreeWithOptions(ctx, before, after, object.DefaultDiffTreeOptions)
+	if err != nil {
+		slog.Error("failed to compare commit trees", "from", from.Hash.String(), "to", to.Hash.String(), "error", err)
+		return nil, err
+	}
+
+	changes := []*Change{}
+	for _, difference := range differences {
+		previous, inBefore := r.include(difference.From.Name)
+		name, inAfter := r.include(difference.To.Name)
+		if !inBefore && !inAfter {
+			continue
+		}
+		action, err := difference.Action()
+		if err != nil {
+			slog.Error("failed to get action of change", "change", difference.String(), "error", err)
+			return nil, err
+		}
+		change := &Change{Name: name}
+		switch {
+		case action == merkletrie.Insert || !inBefore:
+			change.Action = Added
+		case action == merkletrie.Delete || !inAfter:
+			change.Action, change.Name = Removed, previous
+		case previous != name:
+			change.Action, change.Previous = Renamed, previous
+		default:
+			change.Action = Modified
+		}
+		patch, err := difference.PatchContext(ctx)
+		if err != nil {
+			slog.Error("failed to compute patch of change", "change", difference.String(), "error", err)
+			return nil, err
+		}
+		for _, file := range patch.FilePatches() {
+			change.Binary = change.Binary || file.IsBinary()
+		}
+		change.Patch = patch.String()
+		changes = append(changes, change)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+func TestChanges(t *testing.T) {
+	directory := t.TempDir()
+	origin, err := git.PlainInit(directory, false)
+	if err != nil {
+		t.Fatalf("cannot initialise repository: %v", err)
+	}
+	worktree, _ := origin.Worktree()
+	commit := func(files map[string]string, removed ...string) *object.Commit {
+		t.Helper()
+		for name, contents := range files {
+			location := filepath.Join(directory, filepath.FromSlash(name))
+			os.MkdirAll(filepath.Dir(location), 0755)
+			if err := os.WriteFile(location, []byte(contents), 0644); err != nil {
+				t.Fatalf("cannot write file: %v", err)
+			}
+			worktree.Add(name)
+		}
+		for _, name := range removed {
+			worktree.Remove(name)
+		}
+		hash, err := worktree.Commit("change", &git.CommitOptions{
+			Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatalf("cannot commit: %v", err)
+		}
+		result, _ := origin.CommitObject(hash)
+		return result
+	}
+	from := commit(map[string]string{
+		"api/README.md": "hello {{.Name}}\n",
+		"api/old.txt":   "obsolete\n",
+		"api/moved.txt": "one\ntwo\nthree\nfour\nfive\n",
+		"web/index.md":  "outside the archetype\n",
+	})
+	to := commit(map[string]string{
+		"api/README.md":       "hello {{.Name}}\nwelcome\n",
+		"api/new.txt":         "brand new\n",
+		"api/renamed/one.txt": "one\ntwo\nthree\nfour\nfive\n",
+		"web/index.md":        "still outside the archetype\n",
+	}, "api/old.txt", "api/moved.txt")
+
+	repository, err := New(context.Background(), directory, WithPath("api"))
+	if err != nil {
+		t.Fatalf("cannot open repository: %v", err)
+	}
+	changes, err := repository.Changes(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("cannot compare commits: %v", err)
+	}
+	expected := []struct {
+		action   string
+		name     string
+		previous string
+	}{
+		{Modified, "README.md", ""},
+		{Added, "new.txt", ""},
+		{Removed, "old.txt", ""},
+		{Renamed, "renamed/one.txt", "moved.txt"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("unexpected number of changes: expected %d, got %d", len(expected), len(changes))
+	}
+	for i, change := range changes {
+		if change.Action != expected[i].action || change.Name != expected[i].name || change.Previous != expected[i].previous {
+			t.Fatalf("unexpected change at %d: expected %v, got %s %s (from %s)", i, expected[i], change.Action, change.Name, change.Previous)
+		}
+	}
+	if !strings.Contains(changes[0].Patch, "+welcome\n") {
+		t.Fatalf("unexpected patch: %s", changes[0].Patch)
+	}
+}
@@ -1,204 +1,202 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net/url"
-	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/dihedron/archetype/progress"
 	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
 	"github.com/go-git/go-git/v6/plumbing/transport"
 	"github.com/go-git/go-git/v6/plumbing/transport/http"
-	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
 	"github.com/go-git/go-git/v6/storage/memory"
 )
 
 // Repository represents a Git repository, either local or remote.
 type Repository struct {
-	address    string
-	auth       transport.AuthMethod
-	repository *git.Repository
-	proxy      *transport.ProxyOptions
+	address       string
+	auth          transport.AuthMethod
+	repository    *git.Repository
+	proxy         *transport.ProxyOptions
+	cache         *Cache
+	offline       bool
+	shallow       bool
+	revision      string
+	sparse        string
+	prerelease    bool
+	path          string
+	shorthands    map[string]string
+	noSubmodules  bool
+	lfsStore      string
+	noLFS         bool
+	lfsClient     *lfsClient
+	ssh           *sshSettings
+	proxySettings *Proxy
+	tls           *TLS
+	reporter      progress.Reporter
+	ctx           context.Context
+	mirrors       []mirror
+	verifyMirrors bool
+	origin        string
+	options       []Option
 }
 
 // Option is a functional option for configuring a Repository.
-type Option func(*Repository)
+type Option func(*Repository) error
 
-// New creates a new Repository with the given address and options.
-func New(address string, options ...Option) (*Repository, error) {
-	if address == "" || address == "." || address == "./" || address == "./." {
-		slog.Debug("using default address", "address", "file://./")
-		address = "file://./"
-	} else {
-		slog.Debug("using explicitly provided address", "address", address)
-	}
-	repository := &Repository{
-		address: address,
-	}
-	for _, option := range options {
-		if option != nil {
-			option(repository)
-		}
+// New creates a new Repository with the given address and options; the
+// address is normalised first (see NormaliseAddress). The context bounds the
+// clone or fetch, and any later network access such as fetching submodules
+// and Git LFS objects. If the repository cannot be reached, its mirrors (see
+// WithMirror) are tried in order.
+func New(ctx context.Context, address string, options ...Option) (*Repository, error) {
+	repository, err := configure(address, options...)
+	if err != nil {
+		return nil, err
 	}
-	if strings.HasPrefix(repository.address, "file://") {
-		err := repository.open()
-		if err != nil {
-			slog.Error("failed to open local repository", "error", err)
-			return nil, err
-		}
-		return repository, nil
-	} else if strings.HasPrefix(repository.address, "ssh://") || strings.HasPrefix(repository.address, "http://") || strings.HasPrefix(repository.address, "https://") {
-		err := repository.clone()
-		if err != nil {
-			slog.Error("failed to clone remote repository", "error", err)
+	repository.ctx = ctx
+	repository.origin = repository.address
+	repository.options = options
+	if err := repository.load(); err != nil {
+		if len(repository.mirrors) == 0 || ctx.Err() != nil {
 			return nil, err
 		}
+		return repository.fallback(err)
 	}
 	return repository, nil
 }
 
-// WithBasicAuth configures the Repository to use HTTP basic authentication.
-func WithBasicAuth(username string, password string) Option {
-	return func(repository *Repository) {
-		repository.auth = &http.BasicAuth{
-			Username: username,
-			Password: password,
-		}
+// context returns the context the repository was created with.
+func (r *Repository) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
 	}
+	return r.ctx
 }
 
-// WithTokenAuth configures the Repository to use a personal access token for
-// authentication.
-func WithTokenAuth(token string) Option {
-	return func(repository *Repository) {
-		repository.auth = &http.BasicAuth{
-			Username: "git",
-			Password: token,
+// load opens the local repository, or clones (or fetches into the cache) the
+// remote one, according to the address.
+func (r *Repository) load() error {
+	if strings.HasPrefix(r.address, "file://") {
+		err := r.open()
+		if err != nil {
+			slog.Error("failed to open local repository", "error", err)
+			return err
+		}
+		return nil
+	} else if isRemoteAddress(r.address) {
+		if r.cache != nil && r.shallow && !r.offline {
+			slog.Warn("shallow fetch requested, bypassing the clone cache", "address", r.address)
+		}
+		if r.cache != nil && (!r.shallow || r.offline) {
+			err := r.synchronise()
+			if err != nil {
+				slog.Error("failed to synchronise cached repository", "error", err)
+				return err
+			}
+		} else if r.offline {
+			slog.Error("offline mode requires the clone cache")
+			return errors.New("offline mode requires the clone cache")
+		} else {
+			err := r.clone()
+			if err != nil {
+				slog.Error("failed to clone remote repository", "error", err)
+				return err
+			}
 		}
+		return nil
 	}
+	slog.Error("unsupported repository address", "address", r.address)
+	return fmt.Errorf("unsupported repository address '%s'", r.address)
 }
 
-// WithSSHKey configures the Repository to use an SSH key for authentication.
-func WithSSHKey(path string, password *string) Option {
-	return func(repository *Repository) {
-		slog.Info("setting up SSH authentication...")
-		pwd := ""
-		if password != nil {
-			pwd = *password
+// configure creates a Repository with the given options, then normalises the
+// address expanding the configured host shorthands, and extracts the archetype
+// path from it unless one was set explicitly.
+func configure(address string, options ...Option) (*Repository, error) {
+	repository := &Repository{}
+	for _, option := range options {
+		if option != nil {
+			if err := option(repository); err != nil {
+				return nil, err
+			}
 		}
-		keys, err := ssh.NewPublicKeysFromFile("git", path, pwd)
-		if err != nil {
-			slog.Error("failed to generate public keys from file", "error", err)
-			os.Exit(1)
+	}
+	normalised, err := NormaliseAddress(address, repository.shorthands)
+	if err != nil {
+		return nil, err
+	}
+	address, path := SplitAddress(normalised)
+	if path != "" {
+		if repository.path == "" {
+			slog.Debug("using archetype path from address", "address", address, "path", path)
+			repository.path = path
+		} else if repository.path != path {
+			slog.Warn("archetype path overrides the one in the repository address", "address", path, "path", repository.path)
 		}
-		// determine the known hosts dynamically from the user's home directory
-		home, err := os.UserHomeDir()
-		if err != nil {
-			slog.Error("failed to get user home directory", "error", err)
-			os.Exit(1)
+	}
+	slog.Debug("using repository address", "address", address)
+	repository.address = address
+	if repository.proxySettings != nil {
+		if repository.proxy, err = repository.proxySettings.options(address); err != nil {
+			return nil, err
 		}
-		callback, err := ssh.NewKnownHostsCallback(filepath.Join(home, ".ssh", "known_hosts"))
-		if err != nil {
-			slog.Error("failed to create known_hosts callback", "error", err)
-			os.Exit(1)
+	}
+	if repository.tls != nil {
+		repository.tls.install(address)
+	}
+	if repository.ssh != nil {
+		if !IsSSHAddress(address) {
+			slog.Error("SSH options given for a repository not reached over SSH", "address", address)
+			return nil, fmt.Errorf("SSH authentication is only supported for SSH repositories")
+		}
+		if err := repository.setupSSH(); err != nil {
+			return nil, err
 		}
-		keys.HostKeyCallback = callback
-		repository.auth = keys
 	}
+	return repository, nil
 }
 
-// WithDefaultSSHKey configures the Repository to use the default SSH key for
-// authentication.
-func WithDefaultSSHKey() Option {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		slog.Error("failed to get user home directory", "error", err)
-		os.Exit(1)
+// WithBasicAuth configures the Repository to use HTTP basic authentication.
+func WithBasicAuth(username string, password string) Option {
+	return func(repository *Repository) error {
+		repository.auth = &http.BasicAuth{
+			Username: username,
+			Password: password,
+		}
+		return nil
 	}
-	return WithSSHKey(filepath.Join(home, ".ssh", "id_rsa"), nil)
 }
 
-// WithSSHAgent configures the Repository to use an SSH agent for authentication.
-func WithSSHAgent() Option {
-	return func(repository *Repository) {
-		slog.Info("setting up SSH authentication using SSH agent...")
-		authMethod, err := ssh.NewSSHAgentAuth("git")
-		if err != nil {
-			slog.Error("failed to connect to SSH agent", "error", err)
-			os.Exit(1)
-		}
-		repository.auth = authMethod
+// WithCache configures the Repository to keep a persistent bare clone in the
+// given on-disk cache and to fetch it incrementally instead of cloning the
+// whole repository into memory every time.
+func WithCache(cache *Cache) Option {
+	return func(repository *Repository) error {
+		repository.cache = cache
+		return nil
 	}
 }
 
-// WithProxy configures the Repository to use a proxy.
-func WithProxy(proxyURL string, username string, password string) Option {
-	return func(repository *Repository) {
-		slog.Info("setting up proxy for git transport", "proxy", proxyURL)
-		repository.proxy = &transport.ProxyOptions{
-			URL:      proxyURL,
-			Username: username,
-			Password: password,
-		}
+// WithProgress configures the Repository to report the progress of cloning
+// and fetching to the given reporter instead of discarding it.
+func WithProgress(reporter progress.Reporter) Option {
+	return func(repository *Repository) error {
+		repository.reporter = reporter
+		return nil
 	}
 }
 
-// WithProxyFromEnv configures the Repository to use a proxy from the environment
-// variables (HTTP_PROXY, HTTPS_PROXY).
-func WithProxyFromEnv() Option {
-	return func(repository *Repository) {
-		slog.Info("setting up proxy for git transport", "repository", repository.address)
-		var (
-			proxyURL string
-			ok       bool
-		)
-		if strings.HasPrefix(repository.address, "https") {
-			slog.Debug("retrieving proxy for HTTPs address")
-			if proxyURL, ok = os.LookupEnv("HTTPS_PROXY"); !ok {
-				proxyURL, ok = os.LookupEnv("https_proxy")
-				if !ok {
-					slog.Debug("no proxy")
-				}
-			}
-		} else if strings.HasPrefix(repository.address, "http") {
-			slog.Debug("retrieving proxy for HTTP address")
-			if proxyURL, ok = os.LookupEnv("HTTP_PROXY"); !ok {
-				proxyURL, ok = os.LookupEnv("http_proxy")
-				if !ok {
-					slog.Debug("no proxy")
-				}
-			}
-		}
-		if proxyURL == "" {
-			slog.Debug("no proxy available in environment")
-			return
-		}
-		slog.Debug("retrieved HTTP(s) proxy URL from environment", "url", proxyURL)
-		if parsed, err := url.Parse(proxyURL); err != nil {
-			slog.Error("invalid proxy URL", "error", err)
-		} else {
-			var (
-				username string
-				password string
-				address  string
-			)
-			if parsed.User != nil {
-				username = parsed.User.Username()
-				if password, ok = parsed.User.Password(); !ok {
-					username = ""
-				}
-			}
-			parsed.User = nil
-			address = parsed.String()
-			slog.Debug("setting auto proxy", "url", address, "username", username, "password", password)
-			repository.proxy = &transport.ProxyOptions{
-				URL:      address,
-				Username: username,
-				Password: password,
-			}
-		}
+// WithOffline configures the Repository to resolve tags and commits from the
+// clone cache alone, without contacting the remote.
+func WithOffline() Option {
+	return func(repository *Repository) error {
+		repository.offline = true
+		return nil
 	}
 }
 
@@ -208,7 +206,11 @@ func (r *Repository) open() error {
 		slog.Error("repository address not set")
 		return fmt.Errorf("repository address not set")
 	}
-	directory := strings.TrimPrefix(r.address, "file://")
+	directory, ok := localPath(r.address)
+	if !ok {
+		slog.Error("not a local repository", "address", r.address)
+		return fmt.Errorf("'%s' is not a local repository", r.address)
+	}
 	slog.Debug("opening repository", "address", r.address, "directory", directory)
 	repository, err := git.PlainOpen(directory)
 	if err != nil {
@@ -225,18 +227,29 @@ func (r *Repository) clone() error {
 	storage := memory.NewStorage()
 
 	slog.Info("cloning repository into memory", "address", r.address)
+	defer progress.Phase(r.reporter, "clone", 0)()
 
 	options := &git.CloneOptions{
 		URL:      r.address,
 		Auth:     r.auth,
-		Progress: os.Stdout,
+		Progress: progress.Writer(r.reporter),
 	}
-	if r.proxy != nil && strings.HasPrefix(r.proxy.URL, "http") {
-		slog.Debug("applying proxy settings", "address", r.address, "proxy", r.proxy.URL)
+	if r.proxy != nil {
 		options.ProxyOptions = *r.proxy
 	}
+	if r.tls != nil {
+		options.CABundle, options.InsecureSkipTLS = r.tls.CABundle, r.tls.InsecureSkipVerify
+	}
+
+	if r.shallow {
+		if ok, err := r.cloneShallow(options); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+	}
 
-	repository, err := git.Clone(storage, nil, options)
+	repository, err := git.CloneContext(r.context(), storage, nil, options)
 	if err != nil {
 		slog.Error("failed to clone repository", "error", err)
 		return err
@@ -252,3 +265,138 @@ func (r *Repository) clone() error {
 	r.repository = repository
 	return nil
 }
+
+// synchronise opens the bare clone of the repository in the cache, creating
+// it if necessary, and fetches any new objects and references from the remote
+// unless in offline mode; the entry is locked meanwhile, so that concurrent
+// runs fetch into it one at a time.
+func (r *Repository) synchronise() error {
+	path := r.cache.Path(r.address)
+	unlock, err := r.cache.lock(r.cache.Key(r.address))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if r.offline {
+		slog.Info("opening repository from cache (offline)", "address", r.address, "path", path)
+		repository, err := git.PlainOpen(path)
+		if err != nil {
+			slog.Error("failed to open cached repository", "path", path, "error", err)
+			if errors.Is(err, git.ErrRepositoryNotExists) {
+				return fmt.Errorf("repository '%s' not available in cache (offline mode)", r.address)
+			}
+			return err
+		}
+		r.repository = repository
+		return r.cache.touch(r.address)
+	}
+
+	repository, err := git.PlainOpen(path)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		slog.Info("initialising cached repository", "address", r.address, "path", path)
+		if repository, err = git.PlainInit(path, true); err != nil {
+			slog.Error("failed to initialise cached repository", "path", path, "error", err)
+			return err
+		}
+		_, err = repository.CreateRemote(&config.RemoteConfig{
+			Name: git.DefaultRemoteName,
+			URLs: []string{r.address},
+			Fetch: []config.RefSpec{
+				"+refs/heads/*:refs/heads/*",
+				"+refs/tags/*:refs/tags/*",
+			},
+		})
+		if err != nil {
+			slog.Error("failed to create remote in cached repository", "path", path, "error", err)
+			return err
+		}
+	} else if err != nil {
+		slog.Error("failed to open cached repository", "path", path, "error", err)
+		return err
+	}
+
+	remote, err := repository.Remote(git.DefaultRemoteName)
+	if err != nil {
+		slog.Error("failed to get remote of cached repository", "path", path, "error", err)
+		return err
+	}
+
+	// align the local HEAD with the remote default branch
+	list := &git.ListOptions{
+		Auth: r.auth,
+	}
+	if r.proxy != nil {
+		list.ProxyOptions = *r.proxy
+	}
+	if r.tls != nil {
+		list.CABundle, list.InsecureSkipTLS = r.tls.CABundle, r.tls.InsecureSkipVerify
+	}
+	references, err := remote.ListContext(r.context(), list)
+	if err != nil {
+		slog.Error("failed to list remote references", "address", r.address, "error", err)
+		return err
+	}
+
+	slog.Info("fetching repository into cache", "address", r.address, "path", path)
+	defer progress.Phase(r.reporter, "fetch", 0)()
+	options := &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		Auth:       r.auth,
+		Progress:   progress.Writer(r.reporter),
+		Tags:       plumbing.AllTags,
+		Force:      true,
+	}
+	if r.proxy != nil {
+		options.ProxyOptions = *r.proxy
+	}
+	if r.tls != nil {
+		options.CABundle, options.InsecureSkipTLS = r.tls.CABundle, r.tls.InsecureSkipVerify
+	}
+	if err = repository.FetchContext(r.context(), options); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		slog.Error("failed to fetch repository", "error", err)
+		return err
+	}
+	slog.Info("fetch successful!")
+
+	if head := remoteHead(references); head != "" {
+		slog.Debug("setting HEAD of cached repository", "target", head)
+		if err := repository.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head)); err != nil {
+			slog.Error("failed to set HEAD of cached repository", "error", err)
+			return err
+		}
+	}
+	r.repository = repository
+	return r.cache.touch(r.address)
+}
+
+// remoteHead returns the name of the branch the remote HEAD points to, as
+// advertised by the remote, or an empty name if it cannot be determined.
+func remoteHead(references []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+	for _, reference := range references {
+		if reference.Name() == plumbing.HEAD {
+			head = reference
+			break
+		}
+	}
+	if head == nil {
+		return ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+	// the remote did not advertise the symbolic reference: pick the
+	// first branch pointing to the same commit, preferring main and master
+	var candidate plumbing.ReferenceName
+	for _, reference := range references {
+		if reference.Name().IsBranch() && reference.Hash() == head.Hash() {
+			if reference.Name() == plumbing.Main || reference.Name() == plumbing.Master {
+				return reference.Name()
+			}
+			if candidate == "" {
+				candidate = reference.Name()
+			}
+		}
+	}
+	return candidate
+}
@@ -1,24 +1,72 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"regexp"
+	"strings"
 
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/go-git/go-git/v6"
 	"github.com/go-git/go-git/v6/plumbing"
 	"github.com/go-git/go-git/v6/plumbing/object"
 )
 
-// Commit returns the commit object for the given tag or hash.
-// It supports resolving tags, long and short commit hashes, as well as 'latest' and 'HEAD'.
-func (r *Repository) Commit(tag string) (*object.Commit, error) {
+// Resolution describes how a revision was resolved to a commit.
+type Resolution struct {
+	// Revision is the revision as provided by the user.
+	Revision string
+	// Reference is the tag or branch the revision resolved to, if any.
+	Reference *plumbing.Reference
+	// Base is the tag or branch a revision expression such as main~1,
+	// v1.2.0^ or main@{2025-01-01} was evaluated from, if any.
+	Base *plumbing.Reference
+	// Version is the semantic version of the tag, if the revision was a
+	// version constraint or the latest-release keyword.
+	Version *semver.Version
+	// Annotation is the tag object, if the revision resolved to an annotated
+	// tag; it carries the tagger, the date, the release notes and the
+	// signature.
+	Annotation *object.Tag
+	// Commit is the commit the revision resolved to.
+	Commit *object.Commit
+}
+
+// Commit returns the commit object for the given revision; see Resolve for
+// the supported syntax.
+func (r *Repository) Commit(ctx context.Context, tag string) (*object.Commit, error) {
+	resolution, err := r.Resolve(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	return resolution.Commit, nil
+}
+
+// Resolve resolves the given revision to a commit and reports how it did so.
+// It supports 'latest' and 'HEAD', long commit hashes, the 'latest-release'
+// keyword and semantic version constraints (e.g. ^1.4, ~2.0 or >=1.2 <2),
+// which are evaluated against the tags, and any other revision supported by
+// CommitFromRevision: tags, branches, remote-tracking branches, full reference
+// names, abbreviated hashes, ancestry operators and dates. The context bounds
+// the fetch of references not available locally.
+func (r *Repository) Resolve(ctx context.Context, tag string) (*Resolution, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r == nil || r.repository == nil {
+		slog.Error("repository not initialized")
+		return nil, errors.New("repository not initialized")
+	}
 
 	// 1. select the right commit (based on tag or hash, in long or short form)
 	longCommit := regexp.MustCompile(`(?m)^[0-9a-fA-F]{40}$`)
-	shortCommit := regexp.MustCompile(`(?m)^[0-9a-fA-F]{7}$`)
 
-	var commit *object.Commit
+	resolution := &Resolution{
+		Revision: tag,
+	}
 	if tag == "latest" || tag == "HEAD" {
 		// 2a. retrieve the HEAD reference
 		slog.Debug("retrieving reference to latest (HEAD)")
@@ -28,40 +76,96 @@ func (r *Repository) Commit(tag string) (*object.Commit, error) {
 			return nil, err
 		}
 		// 2b. get the commit referred to by the reference
-		commit, err = r.CommitFromReference(reference)
+		commit, err := r.CommitFromReference(reference)
 		if err != nil {
 			slog.Error("failed to get commit for reference", "reference", reference.Name(), "error", err)
 			return nil, err
 		}
 		slog.Debug("retrieved commit for reference", "reference", reference.Name(), "hash", commit.Hash.String())
-	} else if longCommit.MatchString(tag) || shortCommit.MatchString(tag) {
-		// 2. retrieve the commit given the hash, in either short or long
-		// form); this is resolved internally by the Commit() method.
+		resolution.Reference = reference
+		resolution.Commit = commit
+	} else if longCommit.MatchString(tag) {
+		// 2. retrieve the commit given the full hash
 		slog.Debug("retrieving commit for specific hash", "hash", tag)
-		var err error
-		commit, err = r.CommitFromHash(tag)
+		commit, err := r.CommitFromHash(tag)
 		if err != nil {
 			slog.Error("failed to get commit", "error", err)
 			return nil, err
 		}
 		slog.Debug("retrieved commit for hash", "hash", tag, "commit hash", commit.Hash.String())
-	} else {
-		// 2a. retrieve the tag reference
-		slog.Debug("retrieving reference to tag", "tag", tag)
-		reference, err := r.Tag(tag)
+		resolution.Commit = commit
+	} else if !r.named(tag) && (tag == LatestRelease || IsConstraint(tag)) {
+		// 2a. select the highest tag satisfying the version constraint,
+		// unless a tag or branch with the very same name exists
+		slog.Debug("resolving version constraint", "constraint", tag, "pre-releases", r.prerelease)
+		release, err := r.Release(tag, r.prerelease)
 		if err != nil {
-			slog.Error("failed to get tag", "error", err)
+			slog.Error("failed to resolve version constraint", "constraint", tag, "error", err)
 			return nil, err
 		}
 		// 2b. get the commit referred to by the tag reference
-		commit, err = r.CommitFromReference(reference)
+		commit, err := r.CommitFromReference(release.Reference)
 		if err != nil {
-			slog.Error("failed to get commit for tag reference", "tag", tag, "reference", reference.Name(), "error", err)
+			slog.Error("failed to get commit for tag reference", "tag", release.Name(), "error", err)
 			return nil, err
 		}
-		slog.Debug("retrieved commit for tag reference", "tag", tag, "reference", reference.Name(), "hash", commit.Hash.String())
+		slog.Debug("retrieved commit for version constraint", "constraint", tag, "tag", release.Name(), "hash", commit.Hash.String())
+		resolution.Reference = release.Reference
+		resolution.Version = release.Version
+		resolution.Commit = commit
+	} else {
+		// 2. resolve any other revision (tag, branch, remote reference,
+		// abbreviated hash, ancestry or date expression)
+		slog.Debug("resolving revision", "revision", tag)
+		if !strings.ContainsAny(tag, "~^@:") {
+			if reference, err := r.reference(ctx, tag); err == nil {
+				resolution.Reference = reference
+			}
+		}
+		var err error
+		if resolution.Reference != nil {
+			resolution.Commit, err = r.CommitFromReference(resolution.Reference)
+		} else {
+			resolution.Commit, err = r.commitFromRevision(ctx, tag)
+		}
+		if err != nil {
+			slog.Error("failed to resolve revision", "revision", tag, "error", err)
+			return nil, err
+		}
+		slog.Debug("retrieved commit for revision", "revision", tag, "hash", resolution.Commit.Hash.String())
+		// 3. record the tag or branch a revision expression is based on
+		if base := revisionBase(tag); resolution.Reference == nil && base == "HEAD" {
+			resolution.Base, _ = r.repository.Head()
+		} else if resolution.Reference == nil && base != tag {
+			resolution.Base, _ = r.reference(ctx, base)
+		}
 	}
-	return commit, nil
+	if resolution.Reference != nil && resolution.Reference.Name().IsTag() {
+		tag, err := r.TagObject(resolution.Reference)
+		if err != nil && !errors.Is(err, ErrLightweightTag) {
+			slog.Error("failed to get tag object", "reference", resolution.Reference.Name(), "error", err)
+			return nil, err
+		}
+		resolution.Annotation = tag
+	}
+	return resolution, nil
+}
+
+// named returns whether a tag, branch or remote-tracking branch has exactly
+// the given name, which then takes precedence over the version constraint
+// spelled the same way (e.g. a release branch named 1.x); no error is logged
+// if there is none.
+func (r *Repository) named(name string) bool {
+	for _, candidate := range []plumbing.ReferenceName{
+		plumbing.NewTagReferenceName(name),
+		plumbing.NewBranchReferenceName(name),
+		plumbing.NewRemoteReferenceName(git.DefaultRemoteName, name),
+	} {
+		if _, err := r.repository.Reference(candidate, true); err == nil {
+			return true
+		}
+	}
+	return false
 }
 
 // CommitFromHash returns the commit object for the given hash.
@@ -72,7 +176,7 @@ func (r *Repository) CommitFromHash(hash string) (*object.Commit, error) {
 		return nil, errors.New("repository not initialized")
 	}
 	var h plumbing.Hash
-	if len(hash) == 7 {
+	if len(hash) < 40 {
 		slog.Debug("using short hash", "hash", hash)
 		ref, err := r.repository.ResolveRevision(plumbing.Revision(hash))
 		if err != nil {
@@ -92,10 +196,32 @@ func (r *Repository) CommitFromHash(hash string) (*object.Commit, error) {
 	return commit, nil
 }
 
-// CommitFromReference returns the commit object for the given reference.
+// CommitFromReference returns the commit object for the given reference;
+// references to annotated tags are peeled to the commit they point to.
 func (r *Repository) CommitFromReference(reference *plumbing.Reference) (*object.Commit, error) {
+	if r == nil || r.repository == nil {
+		slog.Error("repository not initialized")
+		return nil, errors.New("repository not initialized")
+	}
+	// ... peeling annotated tags, possibly pointing to other tags
+	hash := reference.Hash()
+	for {
+		tag, err := r.repository.TagObject(hash)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			break
+		} else if err != nil {
+			slog.Error("error getting tag object for reference", "reference", reference.Name(), "error", err)
+			return nil, err
+		}
+		slog.Debug("peeling annotated tag", "reference", reference.Name(), "tag", tag.Name, "target", tag.Target.String(), "type", tag.TargetType.String())
+		if tag.TargetType != plumbing.TagObject && tag.TargetType != plumbing.CommitObject {
+			slog.Error("annotated tag does not point to a commit", "reference", reference.Name(), "type", tag.TargetType.String())
+			return nil, fmt.Errorf("tag '%s' points to a %s, not to a commit", tag.Name, tag.TargetType.String())
+		}
+		hash = tag.Target
+	}
 	// ... retrieving the commit object
-	commit, err := r.repository.CommitObject(reference.Hash())
+	commit, err := r.repository.CommitObject(hash)
 	if err != nil {
 		slog.Error("error getting commit object for reference", "reference", reference.Name(), "error", err)
 		return nil, err
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/transport/http"
+)
+
+// DefaultTokenUsername is the username sent along with personal access tokens
+// in HTTP basic authentication when none is given; most git hosting services
+// ignore it, but e.g. GitLab deploy tokens require their own username.
+const DefaultTokenUsername = "git"
+
+// Credentials are a username and password (or token) for HTTP authentication.
+type Credentials struct {
+	Username string
+	Password string
+	// Source describes where the credentials come from, for logging.
+	Source string
+}
+
+// WithTokenAuth configures the Repository to use a personal access token for
+// HTTP basic authentication, with the given username or DefaultTokenUsername.
+func WithTokenAuth(token string, username string) Option {
+	return func(repository *Repository) error {
+		if username == "" {
+			username = DefaultTokenUsername
+		}
+		repository.auth = &http.BasicAuth{
+			Username: username,
+			Password: token,
+		}
+		return nil
+	}
+}
+
+// WithBearerAuth configures the Repository to send the given token in a bearer
+// Authorization header, e.g. for OAuth access tokens.
+func WithBearerAuth(token string) Option {
+	return func(repository *Repository) error {
+		repository.auth = &http.TokenAuth{
+			Token: token,
+		}
+		return nil
+	}
+}
+
+// ReadTokenFile reads a token from the given file, ignoring any surrounding
+// whitespace; the file must not be empty.
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("failed to read token file", "path", path, "error", err)
+		return "", fmt.Errorf("cannot read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		slog.Error("empty token file", "path", path)
+		return "", fmt.Errorf("token file '%s' is empty", path)
+	}
+	return token, nil
+}
+
+// RunTokenCommand runs the given command through the shell and returns its
+// standard output, without surrounding whitespace, as the token; the command
+// must succeed and print a non-empty token.
+func RunTokenCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		// the command line itself may contain secrets, so it is not logged
+		slog.Error("token command failed", "error", err, "stderr", strings.TrimSpace(stderr.String()))
+		return "", fmt.Errorf("token command failed: %w", err)
+	}
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		slog.Error("token command printed no token")
+		return "", errors.New("token command printed no token")
+	}
+	return token, nil
+}
+
+// CredentialHelper asks the git credential helpers configured by the user (as
+// in `git config credential.helper`) for the credentials to access the given
+// HTTP(S) address, optionally for the given username, through the `git
+// credential fill` protocol; it never prompts, and returns nil if there are no
+// credentials or git is not installed.
+func CredentialHelper(address string, username string) (*Credentials, error) {
+	target, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		slog.Debug("git not installed, skipping credential helpers")
+		return nil, nil
+	}
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "protocol=%s\n", target.Scheme)
+	fmt.Fprintf(&input, "host=%s\n", target.Host)
+	if path := strings.TrimPrefix(target.Path, "/"); path != "" {
+		fmt.Fprintf(&input, "path=%s\n", path)
+	}
+	if username == "" && target.User != nil {
+		username = target.User.Username()
+	}
+	if username != "" {
+		fmt.Fprintf(&input, "username=%s\n", username)
+	}
+	input.WriteString("\n")
+
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = &input
+	// never fall back to prompting the user, on the terminal or otherwise
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	output, err := cmd.Output()
+	if err != nil {
+		slog.Debug("no credentials from git credential helpers", "host", target.Host, "error", err)
+		return nil, nil
+	}
+	credentials := &Credentials{Source: "git credential helper"}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "username":
+			credentials.Username = value
+		case "password":
+			credentials.Password = value
+		}
+	}
+	if credentials.Password == "" {
+		return nil, nil
+	}
+	return credentials, nil
+}
+
+// Netrc looks up the credentials for the host of the given HTTP(S) address,
+// optionally for the given username, in the user's netrc file ($NETRC, or
+// ~/.netrc, or ~/_netrc on Windows); it returns nil if there is no such file
+// or no matching entry.
+func Netrc(address string, username string) (*Credentials, error) {
+	target, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			slog.Debug("no home directory, skipping netrc", "error", err)
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+		if runtime.GOOS == "windows" {
+			if _, err := os.Stat(path); err != nil {
+				path = filepath.Join(home, "_netrc")
+			}
+		}
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		slog.Error("failed to read netrc file", "path", path, "error", err)
+		return nil, fmt.Errorf("cannot read netrc file: %w", err)
+	}
+	if username == "" && target.User != nil {
+		username = target.User.Username()
+	}
+	for _, entry := range parseNetrc(string(data)) {
+		if (entry.machine == target.Hostname() || entry.machine == target.Host || entry.machine == "") &&
+			(username == "" || entry.login == username) && entry.password != "" {
+			slog.Debug("found credentials in netrc file", "path", path, "host", target.Host)
+			return &Credentials{Username: entry.login, Password: entry.password, Source: path}, nil
+		}
+	}
+	return nil, nil
+}
+
+// netrcEntry is a machine (or, with an empty name, the default) entry in a
+// netrc file.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parses the contents of a netrc file into its entries, in order and
+// with the default entry, if any, last; macro definitions are skipped.
+func parseNetrc(data string) []netrcEntry {
+	var entries []netrcEntry
+	var fallback *netrcEntry
+	var current *netrcEntry
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for j := 0; j < len(fields); j++ {
+			next := func() string {
+				if j+1 < len(fields) {
+					j++
+					return fields[j]
+				}
+				return ""
+			}
+			switch fields[j] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: next()})
+				current = &entries[len(entries)-1]
+			case "default":
+				fallback = &netrcEntry{}
+				current = fallback
+			case "login":
+				if value := next(); current != nil {
+					current.login = value
+				}
+			case "password":
+				if value := next(); current != nil {
+					current.password = value
+				}
+			case "account":
+				next()
+			case "macdef":
+				// a macro definition runs until the next empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+	if fallback != nil {
+		entries = append(entries, *fallback)
+	}
+	return entries
+}
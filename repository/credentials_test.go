@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestNetrc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	contents := `# comment
+machine github.com login alice password secret-a
+machine gitlab.example.com
+	login deploy-token
+	password secret-d
+macdef init
+	machine evil.example.com login mallory password nope
+
+machine github.com login bob password secret-b
+default login anonymous password guest
+`
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("cannot write netrc file: %v", err)
+	}
+	t.Setenv("NETRC", path)
+
+	for _, test := range []struct {
+		address  string
+		username string
+		login    string
+		password string
+	}{
+		{"https://github.com/team/archetype", "", "alice", "secret-a"},
+		{"https://github.com/team/archetype", "bob", "bob", "secret-b"},
+		{"https://bob@github.com/team/archetype", "", "bob", "secret-b"},
+		{"https://gitlab.example.com:8443/team/archetype", "", "deploy-token", "secret-d"},
+		{"https://evil.example.com/team/archetype", "", "anonymous", "guest"},
+	} {
+		credentials, err := Netrc(test.address, test.username)
+		if err != nil || credentials == nil {
+			t.Fatalf("%s: no credentials (%v)", test.address, err)
+		}
+		if credentials.Username != test.login || credentials.Password != test.password {
+			t.Fatalf("%s: unexpected credentials %s:%s", test.address, credentials.Username, credentials.Password)
+		}
+	}
+
+	if credentials, err := Netrc("https://github.com/team/archetype", "carol"); err != nil || credentials != nil {
+		t.Fatalf("expected no credentials for unknown user, got %v (%v)", credentials, err)
+	}
+
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+	if credentials, err := Netrc("https://github.com/team/archetype", ""); err != nil || credentials != nil {
+		t.Fatalf("expected no credentials without netrc file, got %v (%v)", credentials, err)
+	}
+}
+
+func TestCredentialHelper(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("shell credential helper not available")
+	}
+	// configure a credential helper through the environment alone, ignoring
+	// the user's and the system's git configuration
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "credential.https://git.example.com.helper")
+	t.Setenv("GIT_CONFIG_VALUE_0", `!f() { test "$1" = get && echo username=helper-user && echo password=helper-secret; }; f`)
+
+	credentials, err := CredentialHelper("https://git.example.com/team/archetype", "")
+	if err != nil || credentials == nil {
+		t.Fatalf("no credentials from helper (%v)", err)
+	}
+	if credentials.Username != "helper-user" || credentials.Password != "helper-secret" {
+		t.Fatalf("unexpected credentials %s:%s", credentials.Username, credentials.Password)
+	}
+
+	// no helper for other hosts, and no prompting either
+	credentials, err = CredentialHelper("https://other.example.com/team/archetype", "")
+	if err != nil || credentials != nil {
+		t.Fatalf("expected no credentials for other host, got %v (%v)", credentials, err)
+	}
+}
+
+func TestTokenSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("  glpat-123\n"), 0600); err != nil {
+		t.Fatalf("cannot write token file: %v", err)
+	}
+	if token, err := ReadTokenFile(path); err != nil || token != "glpat-123" {
+		t.Fatalf("unexpected token from file: %q (%v)", token, err)
+	}
+	os.WriteFile(path, []byte("\n"), 0600)
+	if _, err := ReadTokenFile(path); err == nil {
+		t.Fatalf("expected error for empty token file")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if token, err := RunTokenCommand("echo ghp-456"); err != nil || token != "ghp-456" {
+		t.Fatalf("unexpected token from command: %q (%v)", token, err)
+	}
+	if _, err := RunTokenCommand("exit 1"); err == nil {
+		t.Fatalf("expected error for failing token command")
+	}
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"github.com/go-git/go-git/v6/storage/memory"
+)
+
+var (
+	// longHash matches a full, 40 characters commit hash.
+	longHash = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+	// partialHash matches an abbreviated commit hash.
+	partialHash = regexp.MustCompile(`^[0-9a-fA-F]{4,39}$`)
+)
+
+// WithShallow configures the Repository to fetch only the reference that the
+// given revision (a tag, a branch, a full commit hash or latest/HEAD) resolves
+// to, with a depth of 1; revisions that cannot be mapped onto a single remote
+// reference, like abbreviated hashes, fall back to a full fetch. Shallow
+// fetches are always performed in memory, bypassing the clone cache with a
+// warning; offline, the cache is used as it is.
+func WithShallow(revision string) Option {
+	return func(repository *Repository) error {
+		repository.shallow = true
+		repository.revision = revision
+		return nil
+	}
+}
+
+// WithSparse restricts the files visited by Files and ForEachFile to those
+// under the given path prefix; the rest of the tree is ignored. When an
+// archetype path is set, the prefix is relative to it.
+func WithSparse(prefix string) Option {
+	return func(repository *Repository) error {
+		repository.sparse = strings.Trim(prefix, "/")
+		return nil
+	}
+}
+
+// cloneShallow tries to clone only the reference the configured revision
+// resolves to, with a depth of 1; it returns false if the revision cannot be
+// fetched this way and the caller should fall back to a full clone.
+func (r *Repository) cloneShallow(options *git.CloneOptions) (bool, error) {
+	revision := r.revision
+	switch {
+	case revision == "" || revision == "latest" || revision == "HEAD":
+		slog.Info("shallow cloning default branch", "address", r.address)
+		options.SingleBranch = true
+		options.Depth = 1
+		options.Tags = plumbing.NoTags
+	case longHash.MatchString(revision):
+		return r.fetchShallowCommit(options, plumbing.NewHash(revision))
+	case partialHash.MatchString(revision):
+		slog.Info("abbreviated hash requires full history, falling back to full fetch", "revision", revision)
+		return false, nil
+	default:
+		name, err := r.remoteReference(options, revision)
+		if err != nil {
+			return false, err
+		}
+		if name == "" {
+			slog.Info("revision does not match any remote reference, falling back to full fetch", "revision", revision)
+			return false, nil
+		}
+		slog.Info("shallow cloning single reference", "address", r.address, "reference", name)
+		options.ReferenceName = name
+		options.SingleBranch = true
+		options.Depth = 1
+		options.Tags = plumbing.NoTags
+	}
+	repository, err := git.CloneContext(r.context(), memory.NewStorage(), nil, options)
+	if err != nil {
+		if errors.Is(err, transport.ErrShallowNotSupported) || strings.Contains(err.Error(), "does not support shallow") {
+			slog.Info("remote does not support shallow fetches, falling back to full fetch", "error", err)
+			options.ReferenceName = ""
+			options.SingleBranch = false
+			options.Depth = 0
+			options.Tags = plumbing.InvalidTagMode
+			return false, nil
+		}
+		slog.Error("failed to shallow clone repository", "error", err)
+		return false, err
+	}
+	slog.Info("shallow clone successful!")
+	r.repository = repository
+	return true, nil
+}
+
+// fetchShallowCommit fetches the single commit with the given hash, provided
+// the remote allows fetching commits by hash; it returns false otherwise.
+func (r *Repository) fetchShallowCommit(options *git.CloneOptions, hash plumbing.Hash) (bool, error) {
+	slog.Info("shallow fetching single commit", "address", r.address, "hash", hash.String())
+	repository, err := git.Init(memory.NewStorage())
+	if err != nil {
+		slog.Error("failed to initialise in-memory repository", "error", err)
+		return false, err
+	}
+	_, err = repository.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{r.address},
+	})
+	if err != nil {
+		slog.Error("failed to create remote", "error", err)
+		return false, err
+	}
+	reference := plumbing.NewBranchReferenceName("archetype")
+	err = repository.FetchContext(r.context(), &git.FetchOptions{
+		RemoteName:      git.DefaultRemoteName,
+		RefSpecs:        []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", hash.String(), reference))},
+		Depth:           1,
+		Auth:            options.Auth,
+		Progress:        options.Progress,
+		Tags:            plumbing.NoTags,
+		InsecureSkipTLS: options.InsecureSkipTLS,
+		CABundle:        options.CABundle,
+		ProxyOptions:    options.ProxyOptions,
+	})
+	if err != nil {
+		if errors.Is(err, git.ErrExactSHA1NotSupported) {
+			slog.Info("remote does not support fetching by hash, falling back to full fetch", "hash", hash.String())
+			return false, nil
+		}
+		slog.Error("failed to fetch commit", "hash", hash.String(), "error", err)
+		return false, err
+	}
+	if err := repository.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, hash)); err != nil {
+		slog.Error("failed to set HEAD", "error", err)
+		return false, err
+	}
+	r.repository = repository
+	return true, nil
+}
+
+// remoteReference lists the remote references and returns the name of the tag
+// or branch matching the given revision, or an empty name if none matches.
+func (r *Repository) remoteReference(options *git.CloneOptions, revision string) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{r.address},
+	})
+	references, err := remote.ListContext(r.context(), &git.ListOptions{
+		Auth:            options.Auth,
+		InsecureSkipTLS: options.InsecureSkipTLS,
+		CABundle:        options.CABundle,
+		ProxyOptions:    options.ProxyOptions,
+	})
+	if err != nil {
+		slog.Error("failed to list remote references", "address", r.address, "error", err)
+		return "", err
+	}
+	if revision == LatestRelease || IsConstraint(revision) {
+		release, err := selectRelease(releases(references, true), revision, r.prerelease)
+		if err != nil {
+			return "", err
+		}
+		return release.Reference.Name(), nil
+	}
+	candidates := []plumbing.ReferenceName{
+		plumbing.NewTagReferenceName(revision),
+		plumbing.NewBranchReferenceName(revision),
+		plumbing.ReferenceName(revision),
+	}
+	for _, candidate := range candidates {
+		for _, reference := range references {
+			if reference.Name() == candidate && (candidate.IsTag() || candidate.IsBranch()) {
+				return candidate, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// inSparse returns whether the given file path is within the sparse prefix.
+func (r *Repository) inSparse(name string) bool {
+	return r.sparse == "" || name == r.sparse || strings.HasPrefix(name, r.sparse+"/")
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// fetchFixture creates a repository on disk with three commits on master, a
+// feature branch at the first and a v1.0.0 tag at the second; it returns its
+// address and the commits in order.
+func fetchFixture(t *testing.T) (string, []plumbing.Hash) {
+	t.Helper()
+	directory := t.TempDir()
+	repository, err := git.PlainInit(directory, false)
+	if err != nil {
+		t.Fatalf("cannot initialise repository: %v", err)
+	}
+	worktree, _ := repository.Worktree()
+	hashes := []plumbing.Hash{}
+	for _, contents := range []string{"first", "second", "third"} {
+		if err := os.WriteFile(filepath.Join(directory, "README.md"), []byte(contents), 0644); err != nil {
+			t.Fatalf("cannot write file: %v", err)
+		}
+		worktree.Add("README.md")
+		hash, err := worktree.Commit(contents, &git.CommitOptions{
+			Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatalf("cannot commit: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if _, err := repository.CreateTag("v1.0.0", hashes[1], nil); err != nil {
+		t.Fatalf("cannot tag: %v", err)
+	}
+	if err := repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), hashes[0])); err != nil {
+		t.Fatalf("cannot create branch: %v", err)
+	}
+	return "file://" + filepath.ToSlash(directory), hashes
+}
+
+func TestCloneShallow(t *testing.T) {
+	address, hashes := fetchFixture(t)
+	tests := []struct {
+		revision  string
+		shallow   bool
+		reference plumbing.ReferenceName
+		expected  plumbing.Hash
+	}{
+		{"latest", true, "refs/heads/master", hashes[2]},
+		{"", true, "refs/heads/master", hashes[2]},
+		{"v1.0.0", true, "refs/tags/v1.0.0", hashes[1]},
+		{"feature", true, "refs/heads/feature", hashes[0]},
+		{"refs/heads/feature", true, "refs/heads/feature", hashes[0]},
+		{"^1.0", true, "refs/tags/v1.0.0", hashes[1]},
+		// abbreviated hashes, unknown revisions and commits the remote does
+		// not serve by hash fall back to a full fetch
+		{hashes[0].String()[:8], false, "", plumbing.ZeroHash},
+		{"main~1", false, "", plumbing.ZeroHash},
+		{hashes[0].String(), false, "", plumbing.ZeroHash},
+	}
+	for _, test := range tests {
+		r := &Repository{address: address, shallow: true, revision: test.revision}
+		options := &git.CloneOptions{URL: address}
+		ok, err := r.cloneShallow(options)
+		if err != nil {
+			t.Errorf("cannot clone %q: %v", test.revision, err)
+			continue
+		}
+		if ok != test.shallow {
+			t.Errorf("expected shallow clone of %q to be %v, got %v", test.revision, test.shallow, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		// a single reference is fetched, with a depth of 1 (which the local
+		// transport ignores) and without the tags pointing elsewhere
+		if options.Depth != 1 || !options.SingleBranch || options.Tags != plumbing.NoTags {
+			t.Errorf("expected a single reference with a depth of 1 for %q, got %+v", test.revision, options)
+		}
+		references := []plumbing.ReferenceName{}
+		iterator, _ := r.repository.References()
+		iterator.ForEach(func(reference *plumbing.Reference) error {
+			if reference.Name().IsBranch() || reference.Name().IsTag() {
+				references = append(references, reference.Name())
+			}
+			return nil
+		})
+		if !reflect.DeepEqual(references, []plumbing.ReferenceName{test.reference}) {
+			t.Errorf("expected only %s to be fetched for %q, got %v", test.reference, test.revision, references)
+		}
+		head, err := r.repository.Head()
+		if err != nil || head.Hash() != test.expected {
+			t.Errorf("expected HEAD at %s for %q, got %v (%v)", test.expected, test.revision, head, err)
+		}
+	}
+
+	// unknown version constraints are errors, not full fetches
+	r := &Repository{address: address, shallow: true, revision: "^2"}
+	if _, err := r.cloneShallow(&git.CloneOptions{URL: address}); err == nil {
+		t.Errorf("expected error for a constraint no tag satisfies")
+	}
+}
+
+func TestSparse(t *testing.T) {
+	tests := []struct {
+		path     string
+		sparse   string
+		name     string
+		expected string
+		included bool
+	}{
+		{"", "", "README.md", "README.md", true},
+		{"", "docs", "docs/index.md", "docs/index.md", true},
+		{"", "docs", "docs", "docs", true},
+		{"", "docs", "docs-old/index.md", "", false},
+		{"", "docs", "README.md", "", false},
+		{"", "docs/api", "docs/api/v1.md", "docs/api/v1.md", true},
+		{"", "docs/api", "docs/guide.md", "", false},
+		{"services/api", "", "services/api/main.go", "main.go", true},
+		{"services/api", "cmd", "services/api/cmd/main.go", "cmd/main.go", true},
+		{"services/api", "cmd", "services/api/main.go", "", false},
+		{"services/api", "cmd", "cmd/main.go", "", false},
+	}
+	for _, test := range tests {
+		r := &Repository{path: test.path}
+		if err := WithSparse(test.sparse)(r); err != nil {
+			t.Fatalf("cannot set sparse prefix: %v", err)
+		}
+		name, ok := r.include(test.name)
+		if ok != test.included || name != test.expected {
+			t.Errorf("expected %s with path %q and sparse %q to be %q (%v), got %q (%v)", test.name, test.path, test.sparse, test.expected, test.included, name, ok)
+		}
+	}
+	r := &Repository{}
+	WithSparse("/docs/")(r)
+	if !r.inSparse("docs/index.md") || r.inSparse("index.md") {
+		t.Errorf("expected slashes around the sparse prefix to be ignored")
+	}
+}
@@ -1,45 +1,58 @@
 package repository
 
 import (
+	"context"
 	"fmt"
-	"log/slog"
 
 	"github.com/go-git/go-git/v6/plumbing/object"
 )
 
-// Files returns the list of files in the given commit.
+// Files returns the list of files in the given commit, including the files in
+// its submodules at their pinned commits; if an archetype path is set, only the
+// files under it are returned, with names relative to it.
 func (r *Repository) Files(commit *object.Commit) ([]*object.File, error) {
+	entries, err := r.files(commit)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*object.File, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.file)
+	}
+	return files, nil
+}
+
+// files returns the entries in the given commit, including those in its
+// submodules, restricted to the archetype path and sparse prefix and with
+// names relative to the archetype path.
+func (r *Repository) files(commit *object.Commit) ([]*entry, error) {
 
 	if commit == nil {
 		return nil, fmt.Errorf("invalid commit")
 	}
 
-	// ... retrieve the tree from the commit
-	tree, err := commit.Tree()
+	// ... retrieve the files in the commit, including those in submodules
+	all, err := r.tree(commit)
 	if err != nil {
-		slog.Error("error getting tree for commit", "commit", commit.Hash, "error", err)
 		return nil, err
 	}
 
-	files := []*object.File{}
-	// ... get the files iterator and print the file
-	tree.Files().ForEach(func(f *object.File) error {
-		files = append(files, f)
-		return nil
-	})
-	return files, nil
+	entries := []*entry{}
+	for _, e := range all {
+		name, ok := r.include(e.file.Name)
+		if !ok {
+			continue
+		}
+		if name != e.file.Name {
+			e.file = object.NewFile(name, e.file.Mode, &e.file.Blob)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
 }
 
 // ForEachFile iterates over all the files in the given commit and calls the
-// visitor function for each file.
-func (r *Repository) ForEachFile(commit *object.Commit, visitor FileVisitor) error {
-	files, err := r.Files(commit)
-	if err != nil {
-		slog.Error("error getting files for commit", "hash", commit.Hash.String(), "error", err)
-		return err
-	}
-	for _, file := range files {
-		visitor(file)
-	}
-	return nil
+// visitor function for each file, until the context is done.
+func (r *Repository) ForEachFile(ctx context.Context, commit *object.Commit, visitor FileVisitor, options ...VisitOption) error {
+	return ForEachFile(ctx, r.Source(commit), visitor, options...)
 }
@@ -0,0 +1,651 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	format "github.com/go-git/go-git/v6/plumbing/format/config"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+)
+
+const (
+	// LFSPointerMaxSize is the maximum size of a Git LFS pointer file; larger
+	// blobs are never considered as pointers.
+	LFSPointerMaxSize = 1024
+	// LFSMediaType is the media type of the Git LFS batch API.
+	LFSMediaType = "application/vnd.git-lfs+json"
+	// lfsVersion is the first line of every Git LFS pointer file.
+	lfsVersion = "version https://git-lfs.github.com/spec/v1"
+)
+
+// lfsOID matches the object ID of a Git LFS object, i.e. its SHA-256 hash.
+var lfsOID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ErrLFSObjectNotFound is returned when a Git LFS object is neither in any of
+// the local stores nor available from the LFS server.
+var ErrLFSObjectNotFound = errors.New("Git LFS object not found")
+
+// LFSPointer is the contents of a Git LFS pointer file, which stands in the
+// git tree for the actual file, stored on the LFS server.
+type LFSPointer struct {
+	// OID is the SHA-256 hash of the actual file contents.
+	OID string
+	// Size is the size of the actual file in bytes.
+	Size int64
+}
+
+// ParseLFSPointer parses the given blob contents as a Git LFS pointer, and
+// returns false if they are not one.
+func ParseLFSPointer(data []byte) (*LFSPointer, bool) {
+	if len(data) > LFSPointerMaxSize || !bytes.HasPrefix(data, []byte(lfsVersion+"\n")) {
+		return nil, false
+	}
+	pointer := &LFSPointer{Size: -1}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			oid, ok := strings.CutPrefix(value, "sha256:")
+			if !ok || !lfsOID.MatchString(oid) {
+				return nil, false
+			}
+			pointer.OID = oid
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, false
+			}
+			pointer.Size = size
+		}
+	}
+	if pointer.OID == "" || pointer.Size < 0 {
+		return nil, false
+	}
+	return pointer, true
+}
+
+// WithLFSStore configures a local Git LFS store, laid out as the .git/lfs
+// directory of a git clone, where LFS objects are looked up before fetching
+// them from the LFS server, and where fetched objects are saved.
+func WithLFSStore(directory string) Option {
+	return func(repository *Repository) error {
+		repository.lfsStore = directory
+		return nil
+	}
+}
+
+// WithoutLFS configures the Repository to leave the Git LFS pointers as they
+// are, instead of replacing them with the actual files.
+func WithoutLFS() Option {
+	return func(repository *Repository) error {
+		repository.noLFS = true
+		return nil
+	}
+}
+
+// lfsClient resolves the Git LFS pointers in a repository: objects are looked
+// up in the local stores first and fetched in batches from the LFS server
+// otherwise; all the pointers seen in the tree are registered in advance, so
+// that the first fetch downloads all the missing ones at once. Objects are
+// streamed from and to the stores, and only held in memory if none of them
+// can be written to. The mutex only guards the maps: objects are verified and
+// transferred without holding it, so that files are rendered concurrently
+// while objects are fetched, and those needed while being fetched are waited
+// for instead of being fetched again.
+type lfsClient struct {
+	repository *Repository
+	endpoint   string
+	stores     []string
+	writable   string
+	mutex      sync.Mutex
+	memory     map[string][]byte
+	verified   map[string]string
+	pending    map[string]*LFSPointer
+	transfers  map[string]*lfsTransfer
+}
+
+// lfsTransfer is a batch of objects being fetched, which the files needing
+// any of them wait for.
+type lfsTransfer struct {
+	done chan struct{}
+	err  error
+}
+
+// lfs returns the Git LFS client of the repository, creating it on first use;
+// the LFS endpoint is read from the .lfsconfig file in the given commit, if
+// any, or derived from the repository address.
+func (r *Repository) lfs(commit *object.Commit) *lfsClient {
+	if r.lfsClient != nil {
+		return r.lfsClient
+	}
+	client := &lfsClient{
+		repository: r,
+		endpoint:   lfsEndpoint(r.address),
+		memory:     map[string][]byte{},
+		verified:   map[string]string{},
+		pending:    map[string]*LFSPointer{},
+		transfers:  map[string]*lfsTransfer{},
+	}
+	if commit != nil {
+		if file, err := commit.File(".lfsconfig"); err == nil {
+			if contents, err := file.Contents(); err == nil {
+				config := format.New()
+				if err := format.NewDecoder(strings.NewReader(contents)).Decode(config); err != nil {
+					slog.Warn("invalid .lfsconfig file", "error", err)
+				} else if endpoint := config.Section("lfs").Option("url"); endpoint != "" {
+					slog.Debug("using LFS endpoint from .lfsconfig", "endpoint", endpoint)
+					client.endpoint = endpoint
+				}
+			}
+		}
+	}
+	// the explicitly configured store, then the clone cache, then the store of
+	// the local clone, if any
+	if r.lfsStore != "" {
+		client.stores = append(client.stores, r.lfsStore)
+		client.writable = r.lfsStore
+	}
+	if r.cache != nil && isRemoteAddress(r.address) {
+		client.stores = append(client.stores, r.cache.LFSPath(r.address))
+		if client.writable == "" {
+			client.writable = r.cache.LFSPath(r.address)
+		}
+	}
+	if location, ok := localPath(r.address); ok {
+		if info, err := os.Stat(filepath.Join(location, ".git")); err == nil && info.IsDir() {
+			client.stores = append(client.stores, filepath.Join(location, ".git", "lfs"))
+		} else {
+			client.stores = append(client.stores, filepath.Join(location, "lfs"))
+		}
+	}
+	slog.Debug("Git LFS client created", "address", r.address, "endpoint", client.endpoint, "stores", client.stores)
+	r.lfsClient = client
+	return client
+}
+
+// register records a pointer whose object will be needed, so that it is
+// fetched together with the others.
+func (c *lfsClient) register(pointer *LFSPointer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending[pointer.OID] = pointer
+}
+
+// open returns a reader over the contents of the LFS object the given pointer
+// refers to, fetching it if it is not available locally.
+func (c *lfsClient) open(pointer *LFSPointer) (io.ReadCloser, error) {
+	// 1. objects available locally are streamed from their store
+	if c.local(pointer) {
+		return c.reader(pointer)
+	}
+	if c.repository.offline {
+		slog.Error("Git LFS object not available locally", "oid", pointer.OID)
+		return nil, fmt.Errorf("%w: %s is not in any local store (offline mode)", ErrLFSObjectNotFound, pointer.OID)
+	}
+	if c.endpoint == "" {
+		slog.Error("Git LFS object not available locally and no LFS endpoint", "oid", pointer.OID)
+		return nil, fmt.Errorf("%w: %s is not in any local store and there is no LFS server", ErrLFSObjectNotFound, pointer.OID)
+	}
+
+	// 2. objects already being fetched are waited for
+	missing := c.missing(pointer)
+	c.mutex.Lock()
+	if transfer, ok := c.transfers[pointer.OID]; ok {
+		c.mutex.Unlock()
+		slog.Debug("waiting for Git LFS object being fetched", "oid", pointer.OID)
+		<-transfer.done
+		if transfer.err != nil {
+			return nil, transfer.err
+		}
+		return c.fetched(pointer)
+	}
+
+	// 3. the others are fetched along with the missing ones registered so
+	// far and not being fetched yet, without holding the lock
+	transfer := &lfsTransfer{done: make(chan struct{})}
+	batch := []*LFSPointer{pointer}
+	c.transfers[pointer.OID] = transfer
+	for _, other := range missing {
+		if _, ok := c.transfers[other.OID]; !ok && !c.known(other) {
+			batch = append(batch, other)
+			c.transfers[other.OID] = transfer
+		}
+	}
+	c.mutex.Unlock()
+	transfer.err = c.fetch(batch)
+	c.mutex.Lock()
+	for _, fetched := range batch {
+		delete(c.transfers, fetched.OID)
+	}
+	c.mutex.Unlock()
+	close(transfer.done)
+	if transfer.err != nil {
+		return nil, transfer.err
+	}
+	return c.fetched(pointer)
+}
+
+// fetched returns a reader over an object just fetched, or an error if the
+// server did not return it.
+func (c *lfsClient) fetched(pointer *LFSPointer) (io.ReadCloser, error) {
+	if c.local(pointer) {
+		return c.reader(pointer)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrLFSObjectNotFound, pointer.OID)
+}
+
+// missing returns the registered objects other than the given one that are
+// not available locally.
+func (c *lfsClient) missing(pointer *LFSPointer) []*LFSPointer {
+	c.mutex.Lock()
+	pending := make([]*LFSPointer, 0, len(c.pending))
+	for oid, other := range c.pending {
+		if oid != pointer.OID {
+			pending = append(pending, other)
+		}
+	}
+	c.mutex.Unlock()
+	missing := []*LFSPointer{}
+	for _, other := range pending {
+		if !c.local(other) {
+			missing = append(missing, other)
+		}
+	}
+	return missing
+}
+
+// known returns whether the object is in memory or was found in a store
+// already; the lock must be held.
+func (c *lfsClient) known(pointer *LFSPointer) bool {
+	if _, ok := c.memory[pointer.OID]; ok {
+		return true
+	}
+	_, ok := c.verified[pointer.OID]
+	return ok
+}
+
+// local returns whether the object is in memory or in one of the local
+// stores; objects in the stores are verified the first time they are found.
+func (c *lfsClient) local(pointer *LFSPointer) bool {
+	c.mutex.Lock()
+	known := c.known(pointer)
+	c.mutex.Unlock()
+	if known {
+		return true
+	}
+	for _, store := range c.stores {
+		location := lfsObjectPath(store, pointer.OID)
+		file, err := os.Open(location)
+		if err != nil {
+			continue
+		}
+		err = verify(pointer, file)
+		file.Close()
+		if err != nil {
+			slog.Warn("corrupted Git LFS object in store", "store", store, "oid", pointer.OID, "error", err)
+			continue
+		}
+		slog.Debug("Git LFS object found in store", "store", store, "oid", pointer.OID)
+		c.mutex.Lock()
+		c.verified[pointer.OID] = location
+		delete(c.pending, pointer.OID)
+		c.mutex.Unlock()
+		return true
+	}
+	return false
+}
+
+// reader returns a reader over an object available locally, streaming it
+// from its store unless it is held in memory.
+func (c *lfsClient) reader(pointer *LFSPointer) (io.ReadCloser, error) {
+	c.mutex.Lock()
+	data, ok := c.memory[pointer.OID]
+	location := c.verified[pointer.OID]
+	c.mutex.Unlock()
+	if ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	file, err := os.Open(location)
+	if err != nil {
+		slog.Error("cannot open Git LFS object in store", "oid", pointer.OID, "error", err)
+		c.mutex.Lock()
+		delete(c.verified, pointer.OID)
+		c.mutex.Unlock()
+		return nil, err
+	}
+	return file, nil
+}
+
+// lfsBatchRequest is the request body of the Git LFS batch API.
+type lfsBatchRequest struct {
+	Operation string           `json:"operation"`
+	Transfers []string         `json:"transfers"`
+	Objects   []lfsBatchObject `json:"objects"`
+}
+
+// lfsBatchObject is an object in the requests and responses of the Git LFS
+// batch API.
+type lfsBatchObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions map[string]struct {
+		Href   string            `json:"href"`
+		Header map[string]string `json:"header,omitempty"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// lfsBatchResponse is the response body of the Git LFS batch API.
+type lfsBatchResponse struct {
+	Transfer string           `json:"transfer"`
+	Objects  []lfsBatchObject `json:"objects"`
+	Message  string           `json:"message"`
+}
+
+// fetch downloads the given objects from the LFS server through the batch API
+// and the basic transfer adapter, and streams them into the writable store (or
+// into memory if there is none).
+func (c *lfsClient) fetch(pointers []*LFSPointer) error {
+	slog.Info("fetching Git LFS objects", "endpoint", c.endpoint, "count", len(pointers))
+	request := lfsBatchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+	}
+	for _, pointer := range pointers {
+		request.Objects = append(request.Objects, lfsBatchObject{OID: pointer.OID, Size: pointer.Size})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.repository.context(), http.MethodPost, strings.TrimSuffix(c.endpoint, "/")+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", LFSMediaType)
+	req.Header.Set("Content-Type", LFSMediaType)
+	c.authenticate(req)
+	client := c.repository.httpClient()
+	res, err := client.Do(req)
+	if err != nil {
+		slog.Error("error calling Git LFS batch API", "endpoint", c.endpoint, "error", err)
+		return fmt.Errorf("error calling Git LFS batch API at '%s': %w", c.endpoint, err)
+	}
+	defer res.Body.Close()
+	var response lfsBatchResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil && res.StatusCode == http.StatusOK {
+		slog.Error("invalid Git LFS batch API response", "endpoint", c.endpoint, "error", err)
+		return fmt.Errorf("invalid Git LFS batch API response from '%s': %w", c.endpoint, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		slog.Error("Git LFS batch API request failed", "endpoint", c.endpoint, "status", res.Status, "message", response.Message)
+		return fmt.Errorf("Git LFS batch API request to '%s' failed: %s %s", c.endpoint, res.Status, response.Message)
+	}
+
+	sizes := map[string]*LFSPointer{}
+	for _, pointer := range pointers {
+		sizes[pointer.OID] = pointer
+	}
+	for _, object := range response.Objects {
+		pointer, ok := sizes[object.OID]
+		if !ok {
+			slog.Warn("unexpected object in Git LFS batch API response", "oid", object.OID)
+			continue
+		}
+		if object.Error != nil {
+			slog.Error("Git LFS object not available", "oid", object.OID, "code", object.Error.Code, "message", object.Error.Message)
+			return fmt.Errorf("%w: %s (%d %s)", ErrLFSObjectNotFound, object.OID, object.Error.Code, object.Error.Message)
+		}
+		action, ok := object.Actions["download"]
+		if !ok {
+			slog.Error("no download action for Git LFS object", "oid", object.OID)
+			return fmt.Errorf("%w: no download action for %s", ErrLFSObjectNotFound, object.OID)
+		}
+		req, err := http.NewRequestWithContext(c.repository.context(), http.MethodGet, action.Href, nil)
+		if err != nil {
+			return err
+		}
+		if len(action.Header) > 0 {
+			for key, value := range action.Header {
+				req.Header.Set(key, value)
+			}
+		} else {
+			c.authenticate(req)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			slog.Error("error downloading Git LFS object", "oid", object.OID, "error", err)
+			return fmt.Errorf("error downloading Git LFS object %s: %w", object.OID, err)
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			slog.Error("Git LFS object download failed", "oid", object.OID, "status", res.Status)
+			return fmt.Errorf("error downloading Git LFS object %s: %s", object.OID, res.Status)
+		}
+		err = c.save(pointer, res.Body)
+		res.Body.Close()
+		if err != nil {
+			slog.Error("invalid Git LFS object", "oid", object.OID, "error", err)
+			return fmt.Errorf("error downloading Git LFS object %s: %w", object.OID, err)
+		}
+		c.mutex.Lock()
+		delete(c.pending, object.OID)
+		c.mutex.Unlock()
+	}
+	return nil
+}
+
+// save streams a fetched object into the writable store, through a temporary
+// file renamed into place once verified, or reads it into memory if there is
+// no store or it cannot be written to.
+func (c *lfsClient) save(pointer *LFSPointer, body io.Reader) error {
+	if c.writable != "" {
+		location := lfsObjectPath(c.writable, pointer.OID)
+		if err := os.MkdirAll(filepath.Dir(location), 0755); err == nil {
+			if file, err := os.CreateTemp(filepath.Dir(location), pointer.OID+".*.tmp"); err == nil {
+				err := verify(pointer, io.TeeReader(body, file))
+				if e := file.Close(); err == nil {
+					err = e
+				}
+				if err == nil {
+					err = os.Rename(file.Name(), location)
+				}
+				if err != nil {
+					os.Remove(file.Name())
+					return err
+				}
+				slog.Debug("Git LFS object saved in store", "store", c.writable, "oid", pointer.OID)
+				c.mutex.Lock()
+				c.verified[pointer.OID] = location
+				c.mutex.Unlock()
+				return nil
+			}
+		}
+		slog.Warn("cannot save Git LFS object in store, keeping it in memory", "store", c.writable, "oid", pointer.OID)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, pointer.Size+1))
+	if err != nil {
+		return err
+	}
+	if err := verify(pointer, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.memory[pointer.OID] = data
+	c.mutex.Unlock()
+	return nil
+}
+
+// authenticate applies the repository credentials to the given request, if
+// they are HTTP ones and the request goes to the host of the repository: the
+// LFS endpoint may come from the .lfsconfig file in the repository, and the
+// download links from the LFS server, so neither is trusted with them.
+func (c *lfsClient) authenticate(req *http.Request) {
+	auth, ok := c.repository.auth.(githttp.AuthMethod)
+	if !ok || auth == nil {
+		return
+	}
+	if !sameHost(req.URL.String(), c.repository.address) {
+		slog.Warn("not sending credentials to Git LFS server on another host", "url", req.URL.Redacted(), "address", redact(c.repository.address))
+		return
+	}
+	auth.SetAuth(req)
+}
+
+// httpClient returns the HTTP client used for requests made outside of the git
+// transport, e.g. to the Git LFS server, honouring the proxy settings.
+func (r *Repository) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if r.proxySettings != nil {
+		transport.Proxy = r.proxySettings.HTTP()
+	}
+	if r.tls != nil {
+		transport.TLSClientConfig = r.tls.Config()
+	}
+	return &http.Client{Transport: transport}
+}
+
+// verify checks that the data read from the given reader matches the size and
+// hash in the pointer, reading no more than one byte past the expected size.
+func verify(pointer *LFSPointer, reader io.Reader) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, io.LimitReader(reader, pointer.Size+1))
+	if err != nil {
+		return err
+	}
+	if size != pointer.Size {
+		return fmt.Errorf("Git LFS object %s has size %d, expected %d", pointer.OID, size, pointer.Size)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != pointer.OID {
+		return fmt.Errorf("Git LFS object %s does not match its hash", pointer.OID)
+	}
+	return nil
+}
+
+// lfsObjectPath returns the path of an object in a store, laid out as in git
+// clones (lfs/objects/<oid[0:2]>/<oid[2:4]>/<oid>).
+func lfsObjectPath(store string, oid string) string {
+	return filepath.Join(store, "objects", oid[0:2], oid[2:4], oid)
+}
+
+// lfsEndpoint derives the Git LFS server URL from the repository address, as
+// git-lfs does: <repository>.git/info/lfs over HTTPS, also for SSH addresses.
+// Local repositories have no LFS server.
+func lfsEndpoint(address string) string {
+	address = scpToURL(address)
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+	case "ssh", "git":
+		parsed.Scheme = "https"
+		parsed.Host = parsed.Hostname()
+	default:
+		return ""
+	}
+	parsed.User = nil
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	if !strings.HasSuffix(parsed.Path, ".git") {
+		parsed.Path += ".git"
+	}
+	parsed.Path += "/info/lfs"
+	return parsed.String()
+}
+
+// sameHost returns whether the two URLs have the same host, so that the
+// repository credentials can be sent to both.
+func sameHost(a string, b string) bool {
+	first, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	second, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(first.Host, second.Host)
+}
+
+// wrap returns the File for the given blob in the repository: Git LFS pointers
+// are registered with the LFS client and replaced with the objects they refer
+// to, unless disabled.
+func (r *Repository) wrap(file *object.File) File {
+	if r.noLFS || r.lfsClient == nil || file.Size > LFSPointerMaxSize || !file.Mode.IsFile() {
+		return &commitFile{file: file}
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return &commitFile{file: file}
+	}
+	pointer, ok := ParseLFSPointer([]byte(contents))
+	if !ok {
+		return &commitFile{file: file}
+	}
+	slog.Debug("Git LFS pointer found", "name", file.Name, "oid", pointer.OID, "size", pointer.Size)
+	r.lfsClient.register(pointer)
+	return &lfsFile{commitFile: commitFile{file: file}, pointer: pointer, client: r.lfsClient}
+}
+
+// lfsFile is a File whose blob in the git commit is a Git LFS pointer; its
+// contents are those of the LFS object, fetched on first use.
+type lfsFile struct {
+	commitFile
+	pointer *LFSPointer
+	client  *lfsClient
+}
+
+func (f *lfsFile) Size() int64 {
+	return f.pointer.Size
+}
+
+func (f *lfsFile) Reader() (io.ReadCloser, error) {
+	reader, err := f.client.open(f.pointer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get Git LFS object for '%s': %w", f.Name(), err)
+	}
+	return reader, nil
+}
+
+func (f *lfsFile) Contents() (string, error) {
+	reader, err := f.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("cannot read Git LFS object for '%s': %w", f.Name(), err)
+	}
+	return string(data), nil
+}
+
+// LFS returns the Git LFS pointer behind the given file, or nil if it is not
+// stored in Git LFS.
+func LFS(file File) *LFSPointer {
+	if f, ok := file.(*lfsFile); ok {
+		return f.pointer
+	}
+	return nil
+}
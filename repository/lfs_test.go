@@ -0,0 +1,416 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+)
+
+// lfsServer is a minimal Git LFS server, serving the given objects through the
+// batch API and the basic transfer adapter to clients authenticated as
+// user:secret.
+func lfsServer(t *testing.T, objects map[string][]byte) (*httptest.Server, *int32) {
+	t.Helper()
+	var batches int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/team/archetype.git/info/lfs/objects/batch":
+			atomic.AddInt32(&batches, 1)
+			var request lfsBatchRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Operation != "download" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			response := map[string]any{"transfer": "basic"}
+			result := []map[string]any{}
+			for _, object := range request.Objects {
+				result = append(result, map[string]any{
+					"oid":     object.OID,
+					"size":    object.Size,
+					"actions": map[string]any{"download": map[string]any{"href": server.URL + "/objects/" + object.OID}},
+				})
+			}
+			response["objects"] = result
+			w.Header().Set("Content-Type", LFSMediaType)
+			json.NewEncoder(w).Encode(response)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/objects/"):
+			data, ok := objects[strings.TrimPrefix(r.URL.Path, "/objects/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &batches
+}
+
+// lfsPointer returns the Git LFS pointer file for the given contents, and the
+// object ID to serve them under.
+func lfsPointer(data []byte) (string, string) {
+	hash := sha256.Sum256(data)
+	oid := hex.EncodeToString(hash[:])
+	return fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsVersion, oid, len(data)), oid
+}
+
+// lfsFixture creates an in-memory repository with the given files in a single
+// commit, and returns it along with the commit.
+func lfsFixture(t *testing.T, files map[string]string) (*Repository, *object.Commit) {
+	t.Helper()
+	r, worktree := newFixture(t)
+	for name, contents := range files {
+		file, err := worktree.Filesystem.Create(name)
+		if err != nil {
+			t.Fatalf("cannot create file: %v", err)
+		}
+		file.Write([]byte(contents))
+		file.Close()
+		if _, err := worktree.Add(name); err != nil {
+			t.Fatalf("cannot add file: %v", err)
+		}
+	}
+	hash, err := worktree.Commit("initial", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("cannot commit: %v", err)
+	}
+	commit, err := r.repository.CommitObject(hash)
+	if err != nil {
+		t.Fatalf("cannot get commit: %v", err)
+	}
+	return r, commit
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	pointer, oid := lfsPointer([]byte("binary"))
+	for _, test := range []struct {
+		name     string
+		contents string
+		ok       bool
+	}{
+		{"pointer", pointer, true},
+		{"with extension keys", strings.Replace(pointer, "\noid", "\next-0-foo sha256:"+oid+"\noid", 1), true},
+		{"regular file", "hello {{.Name}}\n", false},
+		{"missing size", fmt.Sprintf("%s\noid sha256:%s\n", lfsVersion, oid), false},
+		{"invalid oid", fmt.Sprintf("%s\noid sha256:xyz\nsize 6\n", lfsVersion), false},
+		{"too large", pointer + strings.Repeat("x", LFSPointerMaxSize), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, ok := ParseLFSPointer([]byte(test.contents))
+			if ok != test.ok {
+				t.Fatalf("expected %v, got %v", test.ok, ok)
+			}
+			if ok && (parsed.OID != oid || parsed.Size != 6) {
+				t.Fatalf("unexpected pointer: %+v", parsed)
+			}
+		})
+	}
+}
+
+func TestLFSFiles(t *testing.T) {
+	logo, report := []byte("\x89PNG logo"), []byte("%PDF report")
+	logoPointer, logoOID := lfsPointer(logo)
+	reportPointer, reportOID := lfsPointer(report)
+	server, batches := lfsServer(t, map[string][]byte{logoOID: logo, reportOID: report})
+
+	r, commit := lfsFixture(t, map[string]string{
+		"README.md":  "hello {{.Name}}\n",
+		"logo.png":   logoPointer,
+		"report.pdf": reportPointer,
+	})
+	r.address = server.URL + "/team/archetype"
+	r.auth = &githttp.BasicAuth{Username: "user", Password: "secret"}
+	store := t.TempDir()
+	r.lfsStore = store
+
+	// 1. pointers are replaced with the objects, all fetched in one batch
+	source := r.Source(commit)
+	files, err := source.Files()
+	if err != nil {
+		t.Fatalf("cannot list files: %v", err)
+	}
+	expected := map[string][]byte{"README.md": []byte("hello {{.Name}}\n"), "logo.png": logo, "report.pdf": report}
+	for _, file := range files {
+		contents, err := file.Contents()
+		if err != nil {
+			t.Fatalf("cannot get contents of %s: %v", file.Name(), err)
+		}
+		if contents != string(expected[file.Name()]) {
+			t.Fatalf("unexpected contents of %s: %q", file.Name(), contents)
+		}
+		if (LFS(file) != nil) != (file.Name() != "README.md") || file.Size() != int64(len(contents)) {
+			t.Fatalf("unexpected LFS status or size for %s", file.Name())
+		}
+	}
+	if *batches != 1 {
+		t.Fatalf("expected a single batch request, got %d", *batches)
+	}
+	// the files are listed once per source, e.g. for the names and the visit
+	if again, err := source.Files(); err != nil || !slices.Equal(again, files) {
+		t.Fatalf("expected the files to be listed once, got %v (%v)", again, err)
+	}
+	// the objects are streamed from the store, not held in memory
+	for _, file := range files {
+		if LFS(file) == nil {
+			continue
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			t.Fatalf("cannot open %s: %v", file.Name(), err)
+		}
+		if _, ok := reader.(*os.File); !ok {
+			t.Fatalf("expected %s to be streamed from the store, got %T", file.Name(), reader)
+		}
+		reader.Close()
+	}
+	if len(r.lfsClient.memory) != 0 {
+		t.Fatalf("expected no objects in memory, got %d", len(r.lfsClient.memory))
+	}
+
+	// 2. fetched objects are saved in the store and found there offline
+	r, commit = lfsFixture(t, map[string]string{"logo.png": logoPointer})
+	r.address = server.URL + "/team/archetype"
+	r.offline = true
+	r.lfsStore = store
+	file, err := r.Source(commit).File("logo.png")
+	if err != nil {
+		t.Fatalf("cannot get file: %v", err)
+	}
+	if contents, err := file.Contents(); err != nil || contents != string(logo) {
+		t.Fatalf("unexpected contents from store: %q (%v)", contents, err)
+	}
+	if *batches != 1 {
+		t.Fatalf("unexpected batch request with the object in the store")
+	}
+
+	// 3. objects are only held in memory if the store cannot be written to,
+	// and corrupted ones in the store are fetched again
+	unwritable := filepath.Join(t.TempDir(), "store")
+	os.WriteFile(unwritable, nil, 0644)
+	r, commit = lfsFixture(t, map[string]string{"logo.png": logoPointer})
+	r.address = server.URL + "/team/archetype"
+	r.auth = &githttp.BasicAuth{Username: "user", Password: "secret"}
+	r.lfsStore = unwritable
+	file, err = r.Source(commit).File("logo.png")
+	if err != nil {
+		t.Fatalf("cannot get file: %v", err)
+	}
+	if contents, err := file.Contents(); err != nil || contents != string(logo) || len(r.lfsClient.memory) != 1 {
+		t.Fatalf("expected the object in memory, got %q (%v)", contents, err)
+	}
+	os.WriteFile(lfsObjectPath(store, logoOID), []byte("\x89PNG corrupted"), 0644)
+	r, commit = lfsFixture(t, map[string]string{"logo.png": logoPointer})
+	r.address = server.URL + "/team/archetype"
+	r.auth = &githttp.BasicAuth{Username: "user", Password: "secret"}
+	r.lfsStore = store
+	file, err = r.Source(commit).File("logo.png")
+	if err != nil {
+		t.Fatalf("cannot get file: %v", err)
+	}
+	if contents, err := file.Contents(); err != nil || contents != string(logo) {
+		t.Fatalf("unexpected contents replacing corrupted object: %q (%v)", contents, err)
+	}
+	if data, _ := os.ReadFile(lfsObjectPath(store, logoOID)); string(data) != string(logo) {
+		t.Fatalf("expected the corrupted object to be replaced in the store, got %q", data)
+	}
+
+	// 4. objects missing from the stores cannot be fetched offline
+	r, commit = lfsFixture(t, map[string]string{"logo.png": logoPointer})
+	r.address = server.URL + "/team/archetype"
+	r.offline = true
+	file, err = r.Source(commit).File("logo.png")
+	if err != nil {
+		t.Fatalf("cannot get file: %v", err)
+	}
+	if _, err := file.Contents(); !errors.Is(err, ErrLFSObjectNotFound) {
+		t.Fatalf("expected object not found, got %v", err)
+	}
+
+	// 5. pointers are left as they are if LFS is disabled
+	r, commit = lfsFixture(t, map[string]string{"logo.png": logoPointer})
+	r.noLFS = true
+	file, err = r.Source(commit).File("logo.png")
+	if err != nil {
+		t.Fatalf("cannot get file: %v", err)
+	}
+	if contents, _ := file.Contents(); contents != logoPointer || LFS(file) != nil {
+		t.Fatalf("unexpected contents with LFS disabled: %q", contents)
+	}
+}
+
+func TestLFSConcurrent(t *testing.T) {
+	slow, fast := []byte("slow object"), []byte("fast object")
+	slowPointer, slowOID := lfsPointer(slow)
+	fastPointer, fastOID := lfsPointer(fast)
+	started, release := make(chan struct{}), make(chan struct{})
+	var batches int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			atomic.AddInt32(&batches, 1)
+			json.NewEncoder(w).Encode(map[string]any{"objects": []map[string]any{{
+				"oid":     slowOID,
+				"size":    len(slow),
+				"actions": map[string]any{"download": map[string]any{"href": server.URL + "/objects/" + slowOID}},
+			}}})
+		case r.URL.Path == "/objects/"+slowOID:
+			close(started)
+			<-release
+			w.Write(slow)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	// the download must not be left hanging if the test fails
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+
+	r, commit := lfsFixture(t, map[string]string{"slow.bin": slowPointer, "fast.bin": fastPointer})
+	r.address = server.URL + "/team/archetype"
+	r.lfsStore = t.TempDir()
+	os.MkdirAll(filepath.Dir(lfsObjectPath(r.lfsStore, fastOID)), 0755)
+	os.WriteFile(lfsObjectPath(r.lfsStore, fastOID), fast, 0644)
+	files := map[string]File{}
+	list, err := r.Source(commit).Files()
+	if err != nil {
+		t.Fatalf("cannot list files: %v", err)
+	}
+	for _, file := range list {
+		files[file.Name()] = file
+	}
+
+	// 1. an object being downloaded does not hold up those in the store
+	results := make(chan string, 2)
+	read := func(name string) {
+		contents, err := files[name].Contents()
+		if err != nil {
+			contents = err.Error()
+		}
+		results <- contents
+	}
+	go read("slow.bin")
+	<-started
+	go read("slow.bin")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if contents, err := files["fast.bin"].Contents(); err != nil || contents != string(fast) {
+			t.Errorf("unexpected contents of the object in the store: %q (%v)", contents, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("object in the store held up by another one being downloaded")
+	}
+
+	// 2. an object being downloaded is waited for rather than fetched again
+	close(release)
+	for range 2 {
+		if contents := <-results; contents != string(slow) {
+			t.Fatalf("unexpected contents of the downloaded object: %q", contents)
+		}
+	}
+	if batches := atomic.LoadInt32(&batches); batches != 1 {
+		t.Fatalf("expected a single batch request, got %d", batches)
+	}
+}
+
+func TestLFSEndpoint(t *testing.T) {
+	for address, expected := range map[string]string{
+		"https://github.com/team/archetype":           "https://github.com/team/archetype.git/info/lfs",
+		"https://user@github.com/team/archetype.git/": "https://github.com/team/archetype.git/info/lfs",
+		"git@github.com:team/archetype.git":           "https://github.com/team/archetype.git/info/lfs",
+		"ssh://git@example.com:2222/team/archetype":   "https://example.com/team/archetype.git/info/lfs",
+		"file:///srv/archetype":                       "",
+	} {
+		if got := lfsEndpoint(address); got != expected {
+			t.Errorf("%s: expected %q, got %q", address, expected, got)
+		}
+	}
+}
+
+func TestLFSForeignEndpoint(t *testing.T) {
+	logo := []byte("\x89PNG logo")
+	logoPointer, logoOID := lfsPointer(logo)
+	origin, batches := lfsServer(t, map[string][]byte{logoOID: logo})
+
+	// the LFS server set in .lfsconfig serves anyone, and records whether it
+	// was sent the credentials meant for the repository host
+	var leaked, requests int32
+	var foreign *httptest.Server
+	foreign = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") != "" {
+			atomic.AddInt32(&leaked, 1)
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/lfs/objects/batch":
+			w.Header().Set("Content-Type", LFSMediaType)
+			json.NewEncoder(w).Encode(map[string]any{
+				"transfer": "basic",
+				"objects": []map[string]any{{
+					"oid":     logoOID,
+					"size":    len(logo),
+					"actions": map[string]any{"download": map[string]any{"href": foreign.URL + "/objects/" + logoOID}},
+				}},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/objects/"+logoOID:
+			w.Write(logo)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(foreign.Close)
+
+	r, commit := lfsFixture(t, map[string]string{
+		".lfsconfig": "[lfs]\n\turl = " + foreign.URL + "/lfs\n",
+		"logo.png":   logoPointer,
+	})
+	r.address = origin.URL + "/team/archetype"
+	r.auth = &githttp.BasicAuth{Username: "user", Password: "secret"}
+	file, err := r.Source(commit).File("logo.png")
+	if err != nil {
+		t.Fatalf("cannot get file: %v", err)
+	}
+	if contents, err := file.Contents(); err != nil || contents != string(logo) {
+		t.Fatalf("unexpected contents: %q (%v)", contents, err)
+	}
+	if requests != 2 || *batches != 0 {
+		t.Fatalf("expected the object to be fetched from the .lfsconfig server, got %d requests there and %d batches at the origin", requests, *batches)
+	}
+	if leaked != 0 {
+		t.Fatalf("credentials sent to the LFS server on another host in %d requests", leaked)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package repository
+
+import "os"
+
+// lockFile does nothing on platforms without file locks: concurrent runs
+// sharing the clone cache are not serialised there.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing on platforms without file locks.
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/storage/memory"
+)
+
+// Mirror is a rule mapping the addresses starting with a prefix to the same
+// path under another one, e.g. "https://github.com/acme/" to
+// "https://git-mirror.acme.internal/acme/", so that the repositories of a host
+// can be fetched from a read-only mirror when it is down.
+type Mirror struct {
+	Prefix      string
+	Replacement string
+}
+
+// ParseMirror parses a mirror rule in the form prefix=replacement.
+func ParseMirror(rule string) (Mirror, error) {
+	prefix, replacement, ok := strings.Cut(rule, "=")
+	if !ok || strings.TrimSpace(prefix) == "" || strings.TrimSpace(replacement) == "" {
+		slog.Error("invalid mirror rule", "rule", rule)
+		return Mirror{}, fmt.Errorf("invalid mirror rule '%s' (expected prefix=replacement)", rule)
+	}
+	return Mirror{Prefix: strings.TrimSpace(prefix), Replacement: strings.TrimSpace(replacement)}, nil
+}
+
+// Mirrors returns the addresses of the mirrors of the given address according
+// to the given rules, in the order of the rules and without duplicates.
+func Mirrors(address string, rules []Mirror) []string {
+	mirrors := []string{}
+	for _, rule := range rules {
+		if !strings.HasPrefix(address, rule.Prefix) {
+			continue
+		}
+		mirror := rule.Replacement + strings.TrimPrefix(address, rule.Prefix)
+		if mirror != address && !slices.Contains(mirrors, mirror) {
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	return mirrors
+}
+
+// mirror is an alternative address of a repository, with the options that
+// apply to it only, e.g. its credentials.
+type mirror struct {
+	address string
+	options []Option
+}
+
+// WithMirror adds the address of a mirror of the repository, which New tries,
+// in the order the mirrors were added, if the repository cannot be reached.
+// The options of the repository apply to its mirrors too, except for the
+// credentials, which are only sent to mirrors on the same host and reached
+// over the same transport; the given options apply on top of them.
+func WithMirror(address string, options ...Option) Option {
+	return func(repository *Repository) error {
+		repository.mirrors = append(repository.mirrors, mirror{address: address, options: options})
+		return nil
+	}
+}
+
+// WithMirrorVerification configures the Repository to check its mirrors with
+// VerifyMirrors even when it could be reached itself; otherwise they are only
+// checked when one of them served the repository.
+func WithMirrorVerification() Option {
+	return func(repository *Repository) error {
+		repository.verifyMirrors = true
+		return nil
+	}
+}
+
+// Address returns the normalised address the repository was loaded from,
+// which is that of one of its mirrors if it could not be reached.
+func (r *Repository) Address() string {
+	return r.address
+}
+
+// IsMirror returns whether the repository was loaded from one of its mirrors.
+func (r *Repository) IsMirror() bool {
+	return r.origin != "" && r.address != r.origin
+}
+
+// fallback loads the repository from its mirrors, in order, after it failed
+// to load from its address with the given error.
+func (r *Repository) fallback(err error) (*Repository, error) {
+	errs := []error{fmt.Errorf("%s: %w", r.address, err)}
+	for _, m := range r.mirrors {
+		slog.Warn("repository not available, trying the next mirror", "address", r.address, "mirror", m.address, "error", err)
+		var candidate *Repository
+		if candidate, err = r.alternative(m); err == nil {
+			if err = candidate.load(); err == nil {
+				slog.Warn("using mirror", "address", r.origin, "mirror", candidate.address)
+				return candidate, nil
+			}
+		}
+		if r.context().Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.address, err))
+	}
+	slog.Error("repository and all its mirrors not available", "address", r.origin, "mirrors", len(r.mirrors))
+	return nil, fmt.Errorf("repository and all its mirrors not available: %w", errors.Join(errs...))
+}
+
+// alternative configures the repository for the given mirror address, with
+// the same options and archetype path save for the credentials if the mirror
+// is on another host or reached over another transport (e.g. SSH instead of
+// HTTPS), where they would not apply.
+func (r *Repository) alternative(m mirror) (*Repository, error) {
+	options := slices.Clone(r.options)
+	address, err := NormaliseAddress(m.address, r.shorthands)
+	if err != nil {
+		return nil, err
+	}
+	if host(address) != host(r.origin) {
+		slog.Debug("not sending the repository credentials to a mirror on another host", "mirror", address)
+		options = append(options, withoutCredentials())
+	} else if scheme(address) != scheme(r.origin) {
+		slog.Debug("not sending the repository credentials to a mirror reached over another transport", "mirror", address)
+		options = append(options, withoutCredentials())
+	}
+	candidate, err := configure(m.address, append(options, m.options...)...)
+	if err != nil {
+		return nil, err
+	}
+	// the archetype path in the repository address applies to the mirror too,
+	// unless it has one of its own
+	if candidate.path == "" {
+		candidate.path = r.path
+	}
+	candidate.ctx = r.ctx
+	candidate.options = r.options
+	candidate.origin = r.origin
+	return candidate, nil
+}
+
+// withoutCredentials forgets the credentials, SSH settings and client
+// certificates configured so far.
+func withoutCredentials() Option {
+	return func(repository *Repository) error {
+		repository.auth = nil
+		repository.ssh = nil
+		if repository.tls != nil && len(repository.tls.Certificates) > 0 {
+			settings := *repository.tls
+			settings.Certificates = nil
+			repository.tls = &settings
+		}
+		return nil
+	}
+}
+
+// host returns the host name of the given normalised address, if any.
+func host(address string) string {
+	if location, err := url.Parse(scpToURL(address)); err == nil {
+		return strings.ToLower(location.Hostname())
+	}
+	return ""
+}
+
+// scheme returns the scheme of the given normalised address, e.g. "ssh" for
+// scp-like addresses, if any.
+func scheme(address string) string {
+	if location, err := url.Parse(scpToURL(address)); err == nil {
+		return strings.ToLower(location.Scheme)
+	}
+	return ""
+}
+
+// VerifyMirrors checks that the repository and all its mirrors, whichever
+// served it, agree on the tag or branch the revision resolved to, or that a
+// revision expression such as main~1 or v1.2.0^ was evaluated from, so that
+// the same archetype is generated from any of them; mirrors that cannot be
+// reached are skipped with a warning. The check is only made when a mirror
+// served the repository, or when requested with WithMirrorVerification, so
+// that the mirrors are not contacted on every run. Revisions resolved by hash
+// need no check, since the hash identifies the contents, and nothing is
+// checked when working offline.
+func (r *Repository) VerifyMirrors(ctx context.Context, resolution *Resolution) error {
+	if len(r.mirrors) == 0 {
+		return nil
+	}
+	if !r.verifyMirrors && !r.IsMirror() {
+		slog.Debug("repository reached, not checking the mirrors")
+		return nil
+	}
+	if r.offline {
+		slog.Debug("working offline, not checking the mirrors")
+		return nil
+	}
+	reference := resolution.Reference
+	if reference == nil {
+		reference = resolution.Base
+	}
+	if reference == nil {
+		return nil
+	}
+	name := reference.Name()
+	if name.IsRemote() {
+		// in-memory clones keep the branches as remote-tracking ones
+		_, branch, _ := strings.Cut(strings.TrimPrefix(name.String(), "refs/remotes/"), "/")
+		name = plumbing.NewBranchReferenceName(branch)
+	}
+	if name != plumbing.HEAD && !name.IsTag() && !name.IsBranch() {
+		slog.Debug("revision not resolved to a tag or branch, no need to check the mirrors", "reference", name)
+		return nil
+	}
+	expected := reference.Hash()
+	for _, m := range append([]mirror{{address: r.origin}}, r.mirrors...) {
+		candidate, err := r.alternative(m)
+		if err != nil {
+			return err
+		}
+		if candidate.address == r.address {
+			continue
+		}
+		hash, err := candidate.lookup(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			slog.Warn("cannot check mirror, skipping", "mirror", candidate.address, "error", err)
+			continue
+		}
+		if hash != expected {
+			slog.Error("mirror out of sync", "reference", name, "address", r.address, "expected", expected, "mirror", candidate.address, "actual", hash)
+			return fmt.Errorf("%s resolves to %s on %s but to %s on %s: mirrors out of sync", name.Short(), expected, r.address, hash, candidate.address)
+		}
+		slog.Debug("mirror in sync", "reference", name, "mirror", candidate.address, "hash", hash)
+	}
+	return nil
+}
+
+// lookup returns the hash the given reference points to on the remote, or
+// the zero hash if the remote does not have it.
+func (r *Repository) lookup(ctx context.Context, name plumbing.ReferenceName) (plumbing.Hash, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{r.address},
+	})
+	options := &git.ListOptions{
+		Auth: r.auth,
+	}
+	if r.proxy != nil {
+		options.ProxyOptions = *r.proxy
+	}
+	if r.tls != nil {
+		options.CABundle, options.InsecureSkipTLS = r.tls.CABundle, r.tls.InsecureSkipVerify
+	}
+	references, err := remote.ListContext(ctx, options)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if name == plumbing.HEAD {
+		if head := remoteHead(references); head != "" {
+			name = head
+		}
+	}
+	for _, reference := range references {
+		if reference.Name() == name && reference.Type() == plumbing.HashReference {
+			return reference.Hash(), nil
+		}
+	}
+	return plumbing.ZeroHash, nil
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+func TestMirrors(t *testing.T) {
+	rules := []Mirror{}
+	for _, rule := range []string{
+		"https://github.com/acme/=https://mirror-1.acme.internal/acme/",
+		"https://github.com/=https://mirror-2.acme.internal/github/",
+		"https://github.com/acme/=https://mirror-1.acme.internal/acme/",
+	} {
+		mirror, err := ParseMirror(rule)
+		if err != nil {
+			t.Fatalf("cannot parse mirror rule %q: %v", rule, err)
+		}
+		rules = append(rules, mirror)
+	}
+	expected := []string{
+		"https://mirror-1.acme.internal/acme/archetype.git",
+		"https://mirror-2.acme.internal/github/acme/archetype.git",
+	}
+	if got := Mirrors("https://github.com/acme/archetype.git", rules); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected mirrors: expected %v, got %v", expected, got)
+	}
+	if got := Mirrors("https://gitlab.com/acme/archetype.git", rules); len(got) != 0 {
+		t.Fatalf("unexpected mirrors for another host: %v", got)
+	}
+	for _, rule := range []string{"https://github.com/", "=https://mirror.acme.internal/", "https://github.com/="} {
+		if _, err := ParseMirror(rule); err == nil {
+			t.Fatalf("expected error for mirror rule %q", rule)
+		}
+	}
+}
+
+// commitAndTag commits a file with the given contents to the repository in
+// the given directory and tags the commit as v1.0.0, replacing the tag if it
+// exists already.
+func commitAndTag(t *testing.T, repository *git.Repository, directory string, contents string) {
+	t.Helper()
+	worktree, _ := repository.Worktree()
+	if err := os.WriteFile(filepath.Join(directory, "README.md"), []byte(contents), 0644); err != nil {
+		t.Fatalf("cannot write file: %v", err)
+	}
+	worktree.Add("README.md")
+	hash, err := worktree.Commit(contents, &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("cannot commit: %v", err)
+	}
+	repository.DeleteTag("v1.0.0")
+	if _, err := repository.CreateTag("v1.0.0", hash, nil); err != nil {
+		t.Fatalf("cannot tag: %v", err)
+	}
+}
+
+func TestMirrorFallback(t *testing.T) {
+	primary := t.TempDir()
+	origin, err := git.PlainInit(primary, false)
+	if err != nil {
+		t.Fatalf("cannot initialise repository: %v", err)
+	}
+	commitAndTag(t, origin, primary, "hello")
+	secondary := filepath.Join(t.TempDir(), "mirror")
+	clone, err := git.PlainClone(secondary, &git.CloneOptions{URL: primary, Tags: git.AllTags})
+	if err != nil {
+		t.Fatalf("cannot clone repository: %v", err)
+	}
+	ctx := context.Background()
+
+	// the repository cannot be reached: the mirror is used instead
+	missing := filepath.Join(t.TempDir(), "missing")
+	repository, err := New(ctx, missing, WithMirror(filepath.Join(t.TempDir(), "missing-too")), WithMirror(primary))
+	if err != nil {
+		t.Fatalf("cannot fall back to mirror: %v", err)
+	}
+	if !repository.IsMirror() || repository.Address() != "file://"+primary {
+		t.Fatalf("unexpected address: %s (mirror: %t)", repository.Address(), repository.IsMirror())
+	}
+	// the archetype path in the address applies to the mirror, unless it has
+	// its own
+	repository, err = New(ctx, missing+"//services/api", WithMirror(primary))
+	if err != nil {
+		t.Fatalf("cannot fall back to mirror: %v", err)
+	}
+	if !repository.IsMirror() || repository.Path() != "services/api" {
+		t.Fatalf("expected the archetype path to be kept on the mirror, got %q", repository.Path())
+	}
+	repository, err = New(ctx, missing+"//services/api", WithMirror(primary+"//api"))
+	if err != nil {
+		t.Fatalf("cannot fall back to mirror: %v", err)
+	}
+	if repository.Path() != "api" {
+		t.Fatalf("expected the archetype path of the mirror, got %q", repository.Path())
+	}
+	if _, err := New(ctx, missing, WithMirror(filepath.Join(t.TempDir(), "missing-too"))); err == nil {
+		t.Fatalf("expected error when neither the repository nor its mirrors are available")
+	}
+
+	// the repository and its mirror agree on the tag
+	repository, err = New(ctx, primary, WithMirror(secondary), WithMirror(missing), WithMirrorVerification())
+	if err != nil {
+		t.Fatalf("cannot open repository: %v", err)
+	}
+	if repository.IsMirror() {
+		t.Fatalf("mirror used although the repository is available")
+	}
+	resolution, err := repository.Resolve(ctx, "v1.0.0")
+	if err != nil {
+		t.Fatalf("cannot resolve tag: %v", err)
+	}
+	if err := repository.VerifyMirrors(ctx, resolution); err != nil {
+		t.Fatalf("unexpected mirror verification error: %v", err)
+	}
+
+	expression, err := repository.Resolve(ctx, "v1.0.0~0")
+	if err != nil {
+		t.Fatalf("cannot resolve revision expression: %v", err)
+	}
+	if expression.Reference != nil || expression.Base == nil || expression.Base.Name().Short() != "v1.0.0" {
+		t.Fatalf("expected revision expression to be based on the tag, got %v", expression.Base)
+	}
+	if err := repository.VerifyMirrors(ctx, expression); err != nil {
+		t.Fatalf("unexpected mirror verification error: %v", err)
+	}
+
+	// the tag was moved on the mirror, which is found out whether the tag is
+	// used as it is or in an expression, unless working offline
+	commitAndTag(t, clone, secondary, "tampered")
+	if err := repository.VerifyMirrors(ctx, resolution); err == nil {
+		t.Fatalf("expected error for mirror out of sync")
+	}
+	if err := repository.VerifyMirrors(ctx, expression); err == nil {
+		t.Fatalf("expected error for mirror out of sync with revision expression")
+	}
+	// the mirrors are not contacted when the repository was reached, unless
+	// requested
+	repository.verifyMirrors = false
+	if err := repository.VerifyMirrors(ctx, resolution); err != nil {
+		t.Fatalf("unexpected mirror verification without request: %v", err)
+	}
+	repository.verifyMirrors = true
+	repository.offline = true
+	if err := repository.VerifyMirrors(ctx, resolution); err != nil {
+		t.Fatalf("unexpected mirror verification offline: %v", err)
+	}
+}
+
+func TestMirrorCredentials(t *testing.T) {
+	path, _ := writeSSHKey(t, newHome(t, true), "id_ed25519", "")
+	key := WithSSHKey(path, nil)
+	tests := []struct {
+		origin  string
+		options []Option
+		mirror  string
+		kept    bool
+	}{
+		{"https://example.com/acme/archetype.git", []Option{WithBasicAuth("user", "secret")}, "https://example.com/mirror/archetype.git", true},
+		{"https://example.com/acme/archetype.git", []Option{WithBasicAuth("user", "secret")}, "https://mirror.example.com/acme/archetype.git", false},
+		{"https://example.com/acme/archetype.git", []Option{WithBasicAuth("user", "secret")}, "ssh://git@example.com/acme/archetype.git", false},
+		{"https://example.com/acme/archetype.git", []Option{WithBasicAuth("user", "secret")}, "http://example.com/acme/archetype.git", false},
+		{"git@git.example.com:acme/archetype.git", []Option{key}, "ssh://git@git.example.com/mirror/archetype.git", true},
+		{"ssh://git@git.example.com/acme/archetype.git", []Option{key}, "https://git.example.com/acme/archetype.git", false},
+	}
+	for _, test := range tests {
+		r, err := configure(test.origin, test.options...)
+		if err != nil {
+			t.Fatalf("cannot configure %s: %v", test.origin, err)
+		}
+		r.origin, r.options = r.address, test.options
+		candidate, err := r.alternative(mirror{address: test.mirror})
+		if err != nil {
+			t.Errorf("cannot configure mirror %s of %s: %v", test.mirror, test.origin, err)
+			continue
+		}
+		if kept := candidate.auth != nil || candidate.ssh != nil; kept != test.kept {
+			t.Errorf("expected credentials of %s kept for %s: %t, got %t", test.origin, test.mirror, test.kept, kept)
+		}
+	}
+}
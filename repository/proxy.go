@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Proxy is the proxy configuration, either explicit or read from the standard
+// environment variables: HTTP_PROXY and HTTPS_PROXY for HTTP(S) addresses,
+// ALL_PROXY for SSH ones, and NO_PROXY for the hosts to reach directly, all
+// also in lowercase. The hosts in NO_PROXY are reached directly also with an
+// explicit proxy.
+type Proxy struct {
+	// URL is the explicit proxy URL (http, https, socks5 or socks5h), if any.
+	URL string
+	// Username and Password are the proxy credentials, if any; they override
+	// those in the proxy URL.
+	Username string
+	Password string
+}
+
+// WithProxy configures the Repository to use the given proxy for the git
+// transport; only SOCKS5 proxies are used for SSH addresses.
+func WithProxy(proxyURL string, username string, password string) Option {
+	return func(repository *Repository) error {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil || parsed.Host == "" {
+			slog.Error("invalid proxy URL", "proxy", proxyURL)
+			return fmt.Errorf("invalid proxy URL '%s'", redact(proxyURL))
+		}
+		switch parsed.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			slog.Error("unsupported proxy scheme", "scheme", parsed.Scheme)
+			return fmt.Errorf("unsupported proxy scheme '%s' (supported schemes: http, https, socks5, socks5h)", parsed.Scheme)
+		}
+		repository.proxySettings = &Proxy{
+			URL:      proxyURL,
+			Username: username,
+			Password: password,
+		}
+		return nil
+	}
+}
+
+// WithProxyFromEnv configures the Repository to use the proxy in the standard
+// environment variables, if any, for the git transport.
+func WithProxyFromEnv() Option {
+	return func(repository *Repository) error {
+		repository.proxySettings = &Proxy{}
+		return nil
+	}
+}
+
+// For returns the proxy to use to reach the given address, or nil to reach it
+// directly.
+func (p *Proxy) For(address string) (*url.URL, error) {
+	target, err := url.Parse(scpToURL(address))
+	if err != nil {
+		return nil, err
+	}
+	environment := httpproxy.FromEnvironment()
+	config := &httpproxy.Config{
+		HTTPProxy:  environment.HTTPProxy,
+		HTTPSProxy: environment.HTTPSProxy,
+		NoProxy:    environment.NoProxy,
+	}
+	if p.URL != "" {
+		config.HTTPProxy = p.URL
+		config.HTTPSProxy = p.URL
+	}
+	switch target.Scheme {
+	case "http", "https":
+	case "ssh", "git":
+		// SSH can only go through SOCKS5 proxies, looked up as for HTTPS
+		if p.URL == "" {
+			config.HTTPSProxy = getenv("ALL_PROXY")
+		}
+		if config.HTTPSProxy == "" {
+			return nil, nil
+		}
+		if parsed, err := url.Parse(config.HTTPSProxy); err != nil || !strings.HasPrefix(parsed.Scheme, "socks5") {
+			slog.Warn("only SOCKS5 proxies can be used for SSH, connecting directly", "address", address, "proxy", redact(config.HTTPSProxy))
+			return nil, nil
+		}
+		target = &url.URL{Scheme: "https", Host: target.Host}
+	default:
+		return nil, nil
+	}
+	proxy, err := config.ProxyFunc()(target)
+	if err != nil || proxy == nil {
+		return proxy, err
+	}
+	if p.Username != "" {
+		if p.Password != "" {
+			proxy.User = url.UserPassword(p.Username, p.Password)
+		} else {
+			proxy.User = url.User(p.Username)
+		}
+	}
+	return proxy, nil
+}
+
+// HTTP returns a function for http.Transport.Proxy applying the proxy
+// configuration to each request.
+func (p *Proxy) HTTP() func(*http.Request) (*url.URL, error) {
+	return func(request *http.Request) (*url.URL, error) {
+		return p.For(request.URL.String())
+	}
+}
+
+// options returns the proxy options for the git transport to reach the given
+// address, or nil to reach it directly.
+func (p *Proxy) options(address string) (*transport.ProxyOptions, error) {
+	proxy, err := p.For(address)
+	if err != nil {
+		slog.Error("invalid proxy configuration", "error", err)
+		return nil, fmt.Errorf("invalid proxy configuration: %w", err)
+	}
+	if proxy == nil {
+		slog.Debug("no proxy for repository", "address", address)
+		return nil, nil
+	}
+	options := &transport.ProxyOptions{}
+	if proxy.User != nil {
+		options.Username = proxy.User.Username()
+		options.Password, _ = proxy.User.Password()
+		proxy.User = nil
+	}
+	options.URL = proxy.String()
+	slog.Info("using proxy for repository", "address", address, "proxy", options.URL, "username", options.Username)
+	return options, nil
+}
+
+// getenv returns the value of the given environment variable, or of its
+// lowercase version.
+func getenv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// redact returns the given URL with the password, if any, masked, so that it
+// can be logged or shown in errors.
+func redact(address string) string {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return parsed.Redacted()
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+// clearProxyEnv removes any proxy configuration from the environment for the
+// test.
+func clearProxyEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "NO_PROXY", "REQUEST_METHOD"} {
+		t.Setenv(name, "")
+		t.Setenv(strings.ToLower(name), "")
+	}
+}
+
+func TestProxyFromEnv(t *testing.T) {
+	clearProxyEnv(t)
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("NO_PROXY", "internal.example.com,.corp.example.com")
+
+	tests := []struct {
+		address  string
+		expected string
+	}{
+		{"https://github.com/dihedron/archetype", "http://proxy.example.com:3128"},
+		{"http://github.com/dihedron/archetype", ""},
+		{"https://internal.example.com/team/archetype", ""},
+		{"https://git.corp.example.com/team/archetype", ""},
+		{"git@github.com:dihedron/archetype", ""},
+	}
+	proxy := &Proxy{}
+	for _, test := range tests {
+		url, err := proxy.For(test.address)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.address, err)
+		}
+		actual := ""
+		if url != nil {
+			actual = url.String()
+		}
+		if actual != test.expected {
+			t.Fatalf("unexpected proxy for %s: expected %q, got %v", test.address, test.expected, url)
+		}
+	}
+}
+
+func TestExplicitProxy(t *testing.T) {
+	clearProxyEnv(t)
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	repository, err := configure("https://github.com/dihedron/archetype", WithProxy("http://other.example.com:8080", "alice", "s3cret"))
+	if err != nil {
+		t.Fatalf("cannot configure proxy: %v", err)
+	}
+	if repository.proxy == nil || repository.proxy.URL != "http://other.example.com:8080" {
+		t.Fatalf("explicit proxy not used: %+v", repository.proxy)
+	}
+	if repository.proxy.Username != "alice" || repository.proxy.Password != "s3cret" {
+		t.Fatalf("proxy credentials not applied: %+v", repository.proxy)
+	}
+	if strings.Contains(repository.proxy.URL, "s3cret") {
+		t.Fatalf("proxy password in URL: %s", repository.proxy.URL)
+	}
+
+	// hosts in NO_PROXY are reached directly also with an explicit proxy
+	repository, err = configure("https://internal.example.com/team/archetype", WithProxy("http://other.example.com:8080", "", ""))
+	if err != nil {
+		t.Fatalf("cannot configure proxy: %v", err)
+	}
+	if repository.proxy != nil {
+		t.Fatalf("unexpected proxy for host in NO_PROXY: %+v", repository.proxy)
+	}
+
+	for _, invalid := range []string{"ftp://proxy.example.com", "proxy.example.com:3128", "://"} {
+		if _, err := configure("https://github.com/dihedron/archetype", WithProxy(invalid, "", "")); err == nil {
+			t.Fatalf("expected error for proxy %q", invalid)
+		}
+	}
+}
+
+func TestSSHProxy(t *testing.T) {
+	clearProxyEnv(t)
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+
+	// HTTP proxies cannot tunnel SSH
+	url, err := (&Proxy{}).For(sshAddress)
+	if err != nil || url != nil {
+		t.Fatalf("unexpected proxy for SSH: %v (%v)", url, err)
+	}
+	url, err = (&Proxy{URL: "http://proxy.example.com:3128"}).For(sshAddress)
+	if err != nil || url != nil {
+		t.Fatalf("unexpected HTTP proxy for SSH: %v (%v)", url, err)
+	}
+
+	t.Setenv("ALL_PROXY", "socks5://socks.example.com:1080")
+	url, err = (&Proxy{Username: "bob"}).For(sshAddress)
+	if err != nil || url == nil || url.String() != "socks5://bob@socks.example.com:1080" {
+		t.Fatalf("SOCKS5 proxy not used for SSH: %v (%v)", url, err)
+	}
+
+	t.Setenv("NO_PROXY", "git.example.com")
+	url, err = (&Proxy{}).For(sshAddress)
+	if err != nil || url != nil {
+		t.Fatalf("unexpected proxy for host in NO_PROXY: %v (%v)", url, err)
+	}
+}
@@ -0,0 +1,320 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// RevisionError is returned when a revision cannot be resolved; it reports
+// the kinds of reference that were tried.
+type RevisionError struct {
+	Revision string
+	Tried    []string
+	Err      error
+}
+
+// Error returns the error message, listing the kinds of reference tried.
+func (e *RevisionError) Error() string {
+	message := fmt.Sprintf("cannot resolve revision '%s' (tried %s)", e.Revision, strings.Join(e.Tried, ", "))
+	if e.Err != nil && !errors.Is(e.Err, plumbing.ErrReferenceNotFound) {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+// Unwrap returns the underlying error.
+func (e *RevisionError) Unwrap() error {
+	if e.Err != nil {
+		return e.Err
+	}
+	return plumbing.ErrReferenceNotFound
+}
+
+var (
+	// dateSelector matches revisions like main@{2025-01-01} or v1.0@{2 weeks ago}.
+	dateSelector = regexp.MustCompile(`^(.*?)@\{([^}]+)\}(.*)$`)
+	// relativeDate matches relative dates like "3 days ago" or "1.week.ago".
+	relativeDate = regexp.MustCompile(`^(\d+)[ .](second|minute|hour|day|week|month|year)s?[ .]ago$`)
+)
+
+// Reference resolves the given name to a reference, trying in order a tag, a
+// branch (falling back between main and master only if the exact branch does
+// not exist), a remote-tracking branch and a full reference name such as
+// refs/pull/42/head, which is fetched on demand from the remote if not
+// available locally.
+func (r *Repository) Reference(name string) (*plumbing.Reference, error) {
+	return r.reference(r.context(), name)
+}
+
+// reference is Reference, fetching missing references within the given
+// context.
+func (r *Repository) reference(ctx context.Context, name string) (*plumbing.Reference, error) {
+	if r == nil || r.repository == nil {
+		slog.Error("repository not initialized")
+		return nil, errors.New("repository not initialized")
+	}
+	tried := []string{}
+
+	// 1. tags
+	tried = append(tried, "tag")
+	if reference, err := r.repository.Reference(plumbing.NewTagReferenceName(name), true); err == nil {
+		slog.Debug("revision resolved as tag", "name", name, "reference", reference.Name())
+		return reference, nil
+	}
+
+	// 2. branches, with main and master standing for the main branch
+	tried = append(tried, "branch")
+	reference, err := r.Branch(name)
+	if err == nil {
+		slog.Debug("revision resolved as branch", "name", name, "reference", reference.Name())
+		return reference, nil
+	}
+	if errors.Is(err, plumbing.ErrReferenceNotFound) && (name == "main" || name == "master") {
+		if reference, err := r.MainBranch(); err == nil {
+			slog.Debug("revision resolved as main branch", "name", name, "reference", reference.Name())
+			return reference, nil
+		}
+	}
+
+	// 3. remote-tracking branches, either as origin/<branch> or as <branch>
+	tried = append(tried, "remote-tracking branch")
+	for _, candidate := range []plumbing.ReferenceName{
+		plumbing.NewRemoteReferenceName(git.DefaultRemoteName, name),
+		plumbing.ReferenceName("refs/remotes/" + name),
+	} {
+		if reference, err := r.repository.Reference(candidate, true); err == nil {
+			slog.Debug("revision resolved as remote-tracking branch", "name", name, "reference", reference.Name())
+			return reference, nil
+		}
+	}
+
+	// 4. full reference names, fetched on demand if missing
+	if strings.HasPrefix(name, "refs/") {
+		tried = append(tried, "reference")
+		reference, err := r.repository.Reference(plumbing.ReferenceName(name), true)
+		if err != nil {
+			if err = r.fetchReference(ctx, plumbing.ReferenceName(name)); err == nil {
+				reference, err = r.repository.Reference(plumbing.ReferenceName(name), true)
+			}
+		}
+		if err == nil {
+			slog.Debug("revision resolved as reference", "name", name, "reference", reference.Name())
+			return reference, nil
+		}
+		slog.Debug("failed to resolve reference", "name", name, "error", err)
+	}
+	return nil, &RevisionError{Revision: name, Tried: tried}
+}
+
+// CommitFromRevision returns the commit for the given revision; besides tags,
+// branches, remote-tracking branches, full reference names and (abbreviated)
+// commit hashes, it supports the ancestry operators (e.g. v1.2.0~1, main^2),
+// the message search operator (e.g. main^{/fix}) and date-based selection
+// (e.g. main@{2025-01-01} or main@{2 weeks ago}).
+func (r *Repository) CommitFromRevision(revision string) (*object.Commit, error) {
+	return r.commitFromRevision(r.context(), revision)
+}
+
+// commitFromRevision is CommitFromRevision, fetching missing references within
+// the given context.
+func (r *Repository) commitFromRevision(ctx context.Context, revision string) (*object.Commit, error) {
+	if r == nil || r.repository == nil {
+		slog.Error("repository not initialized")
+		return nil, errors.New("repository not initialized")
+	}
+
+	// 1. date-based selection: resolve the base revision, then walk its
+	// first-parent history back to the given date
+	if match := dateSelector.FindStringSubmatch(revision); match != nil {
+		base, selector, suffix := match[1], match[2], match[3]
+		if base == "" {
+			base = "HEAD"
+		}
+		date, err := parseDate(selector)
+		if err != nil {
+			slog.Error("invalid date selector", "revision", revision, "error", err)
+			return nil, &RevisionError{Revision: revision, Tried: []string{"date selector"}, Err: err}
+		}
+		commit, err := r.commitFromRevision(ctx, base)
+		if err != nil {
+			return nil, err
+		}
+		if commit, err = commitAtDate(commit, date); err != nil {
+			slog.Error("no commit found at date", "revision", revision, "date", date, "error", err)
+			return nil, &RevisionError{Revision: revision, Tried: []string{"date selector"}, Err: err}
+		}
+		slog.Debug("revision resolved by date", "revision", revision, "date", date, "hash", commit.Hash.String())
+		if suffix == "" {
+			return commit, nil
+		}
+		return r.commitFromExpression(revision, commit.Hash.String()+suffix)
+	}
+
+	// 2. split the base name from any ancestry or search operators
+	base, suffix := revision, ""
+	if index := strings.IndexAny(revision, "~^"); index > 0 {
+		base, suffix = revision[:index], revision[index:]
+	}
+	if base == "latest" {
+		base = "HEAD"
+	}
+
+	// 3. resolve the base name as a reference, then as a commit hash
+	var commit *object.Commit
+	reference, err := r.reference(ctx, base)
+	if err == nil {
+		if commit, err = r.CommitFromReference(reference); err != nil {
+			return nil, err
+		}
+	} else {
+		tried := []string{"tag", "branch", "remote-tracking branch"}
+		var re *RevisionError
+		if errors.As(err, &re) {
+			tried = re.Tried
+		}
+		tried = append(tried, "commit hash")
+		hash, err := r.repository.ResolveRevision(plumbing.Revision(base))
+		if err != nil {
+			slog.Error("failed to resolve revision", "revision", revision, "error", err)
+			return nil, &RevisionError{Revision: revision, Tried: tried, Err: err}
+		}
+		if commit, err = r.repository.CommitObject(*hash); err != nil {
+			slog.Error("failed to get commit", "hash", hash.String(), "error", err)
+			return nil, err
+		}
+	}
+	if suffix == "" {
+		return commit, nil
+	}
+	return r.commitFromExpression(revision, commit.Hash.String()+suffix)
+}
+
+// revisionBase returns the name a revision expression is evaluated from, e.g.
+// main for main~2, main^{/fix} or main@{2025-01-01}, and HEAD for latest and
+// for date selectors without a name; other revisions are returned as they are.
+func revisionBase(revision string) string {
+	if match := dateSelector.FindStringSubmatch(revision); match != nil {
+		if revision = match[1]; revision == "" {
+			return "HEAD"
+		}
+	}
+	if index := strings.IndexAny(revision, "~^"); index > 0 {
+		revision = revision[:index]
+	}
+	if revision == "latest" {
+		return "HEAD"
+	}
+	return revision
+}
+
+// commitFromExpression evaluates a revision expression rooted at a commit hash,
+// such as <hash>~2 or <hash>^{/message}.
+func (r *Repository) commitFromExpression(revision string, expression string) (*object.Commit, error) {
+	hash, err := r.repository.ResolveRevision(plumbing.Revision(expression))
+	if err != nil {
+		slog.Error("failed to resolve revision expression", "revision", revision, "expression", expression, "error", err)
+		return nil, &RevisionError{Revision: revision, Tried: []string{"revision expression"}, Err: err}
+	}
+	commit, err := r.repository.CommitObject(*hash)
+	if err != nil {
+		slog.Error("failed to get commit", "hash", hash.String(), "error", err)
+		return nil, err
+	}
+	slog.Debug("revision expression resolved", "revision", revision, "hash", commit.Hash.String())
+	return commit, nil
+}
+
+// fetchReference fetches the given reference from the remote into the local
+// repository, unless working offline or on a local repository.
+func (r *Repository) fetchReference(ctx context.Context, name plumbing.ReferenceName) error {
+	if r.offline || strings.HasPrefix(r.address, "file://") {
+		return plumbing.ErrReferenceNotFound
+	}
+	slog.Info("fetching reference from remote", "address", r.address, "reference", name)
+	options := &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", name, name))},
+		Auth:       r.auth,
+		Tags:       plumbing.NoTags,
+	}
+	if r.proxy != nil {
+		options.ProxyOptions = *r.proxy
+	}
+	if r.tls != nil {
+		options.CABundle, options.InsecureSkipTLS = r.tls.CABundle, r.tls.InsecureSkipVerify
+	}
+	if err := r.repository.FetchContext(ctx, options); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		slog.Error("failed to fetch reference", "reference", name, "error", err)
+		return err
+	}
+	return nil
+}
+
+// commitAtDate walks the first-parent history of the given commit and returns
+// the first commit committed at or before the given date.
+func commitAtDate(commit *object.Commit, date time.Time) (*object.Commit, error) {
+	for {
+		if !commit.Committer.When.After(date) {
+			return commit, nil
+		}
+		parent, err := commit.Parent(0)
+		if errors.Is(err, object.ErrParentNotFound) || errors.Is(err, plumbing.ErrObjectNotFound) {
+			return nil, fmt.Errorf("history does not go back to %s", date.Format(time.RFC3339))
+		} else if err != nil {
+			return nil, err
+		}
+		commit = parent
+	}
+}
+
+// parseDate parses the date in a date selector, either absolute (e.g.
+// 2025-01-01, 2025-01-01 10:00:00 or RFC 3339) or relative (e.g. 2 weeks ago).
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if _, err := strconv.Atoi(value); err == nil {
+		return time.Time{}, fmt.Errorf("reflog selector '@{%s}' is not supported", value)
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if date, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return date, nil
+		}
+	}
+	switch strings.ToLower(value) {
+	case "now":
+		return time.Now(), nil
+	case "yesterday":
+		return time.Now().AddDate(0, 0, -1), nil
+	}
+	if match := relativeDate.FindStringSubmatch(strings.ToLower(value)); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		now := time.Now()
+		switch match[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date '%s'", value)
+}
@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// revisionFixture creates a repository whose master branch has three commits,
+// a day apart, and a merge of the first one, with branches, remote-tracking
+// branches, a pull request reference and a lightweight tag; it returns the
+// repository and the commits in order.
+func revisionFixture(t *testing.T) (*Repository, []plumbing.Hash) {
+	t.Helper()
+	r, worktree := newFixture(t)
+	r.offline = true
+	when := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	hashes := []plumbing.Hash{}
+	for i, message := range []string{"first", "second", "third", "merge"} {
+		file, err := worktree.Filesystem.Create("README.md")
+		if err != nil {
+			t.Fatalf("cannot create file: %v", err)
+		}
+		file.Write([]byte(message))
+		file.Close()
+		worktree.Add("README.md")
+		options := &git.CommitOptions{
+			Author: &object.Signature{Name: "Test", Email: "test@example.com", When: when.AddDate(0, 0, i)},
+		}
+		if message == "merge" {
+			options.Parents = []plumbing.Hash{hashes[2], hashes[0]}
+		}
+		hash, err := worktree.Commit(message, options)
+		if err != nil {
+			t.Fatalf("cannot commit: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	for name, hash := range map[plumbing.ReferenceName]plumbing.Hash{
+		plumbing.NewBranchReferenceName("feature"):               hashes[0],
+		plumbing.NewRemoteReferenceName("origin", "stable"):      hashes[1],
+		plumbing.NewTagReferenceName("v1.0.0"):                   hashes[1],
+		plumbing.NewBranchReferenceName("1.x"):                   hashes[2],
+		plumbing.ReferenceName("refs/pull/42/head"):              hashes[2],
+		plumbing.NewRemoteReferenceName("upstream", "release-1"): hashes[0],
+	} {
+		if err := r.repository.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			t.Fatalf("cannot create reference %s: %v", name, err)
+		}
+	}
+	return r, hashes
+}
+
+func TestReference(t *testing.T) {
+	r, _ := revisionFixture(t)
+	tests := []struct {
+		name     string
+		expected plumbing.ReferenceName
+	}{
+		{"v1.0.0", "refs/tags/v1.0.0"},
+		{"feature", "refs/heads/feature"},
+		{"main", "refs/heads/master"},
+		{"master", "refs/heads/master"},
+		{"stable", "refs/remotes/origin/stable"},
+		{"origin/stable", "refs/remotes/origin/stable"},
+		{"upstream/release-1", "refs/remotes/upstream/release-1"},
+		{"refs/pull/42/head", "refs/pull/42/head"},
+	}
+	for _, test := range tests {
+		reference, err := r.Reference(test.name)
+		if err != nil {
+			t.Errorf("cannot resolve %s: %v", test.name, err)
+		} else if reference.Name() != test.expected {
+			t.Errorf("expected %s to resolve to %s, got %s", test.name, test.expected, reference.Name())
+		}
+	}
+
+	for name, tried := range map[string][]string{
+		"missing":           {"tag", "branch", "remote-tracking branch"},
+		"refs/pull/43/head": {"tag", "branch", "remote-tracking branch", "reference"},
+	} {
+		_, err := r.Reference(name)
+		var re *RevisionError
+		if !errors.As(err, &re) || !reflect.DeepEqual(re.Tried, tried) {
+			t.Errorf("expected revision error trying %v for %s, got %v", tried, name, err)
+		}
+		if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			t.Errorf("expected reference not found for %s, got %v", name, err)
+		}
+	}
+}
+
+func TestReferenceMainAndMaster(t *testing.T) {
+	r, hashes := revisionFixture(t)
+	if err := r.repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), hashes[0])); err != nil {
+		t.Fatalf("cannot create main branch: %v", err)
+	}
+	// with both branches, each name resolves to its own branch
+	for name, expected := range map[string]plumbing.Hash{"main": hashes[0], "master": hashes[3]} {
+		reference, err := r.Reference(name)
+		if err != nil {
+			t.Errorf("cannot resolve %s: %v", name, err)
+		} else if reference.Name() != plumbing.NewBranchReferenceName(name) || reference.Hash() != expected {
+			t.Errorf("expected %s to resolve to itself, got %s (%s)", name, reference.Name(), reference.Hash())
+		}
+		commit, err := r.CommitFromRevision(name)
+		if err != nil {
+			t.Errorf("cannot get commit for %s: %v", name, err)
+		} else if commit.Hash != expected {
+			t.Errorf("expected %s to resolve to %s, got %s", name, expected, commit.Hash)
+		}
+	}
+}
+
+func TestCommitFromRevision(t *testing.T) {
+	r, hashes := revisionFixture(t)
+	tests := []struct {
+		revision string
+		expected plumbing.Hash
+	}{
+		{"master", hashes[3]},
+		{"main", hashes[3]},
+		{"latest", hashes[3]},
+		{"HEAD", hashes[3]},
+		{"feature", hashes[0]},
+		{"stable", hashes[1]},
+		{"origin/stable", hashes[1]},
+		{"v1.0.0", hashes[1]},
+		{"refs/pull/42/head", hashes[2]},
+		{hashes[2].String()[:8], hashes[2]},
+		{"main~1", hashes[2]},
+		{"main~2", hashes[1]},
+		{"main^", hashes[2]},
+		{"main^2", hashes[0]},
+		{"v1.0.0^", hashes[0]},
+		{"main^{/second}", hashes[1]},
+		{"main@{2025-01-02T18:00:00Z}", hashes[1]},
+		{"main@{2025-01-02T18:00:00Z}~1", hashes[0]},
+		{"@{2025-01-03T12:00:00Z}", hashes[2]},
+		{"main@{now}", hashes[3]},
+	}
+	for _, test := range tests {
+		commit, err := r.CommitFromRevision(test.revision)
+		if err != nil {
+			t.Errorf("cannot resolve %s: %v", test.revision, err)
+		} else if commit.Hash != test.expected {
+			t.Errorf("expected %s to resolve to %s, got %s", test.revision, test.expected, commit.Hash)
+		}
+	}
+
+	for revision, message := range map[string]string{
+		"missing":           "tried tag, branch, remote-tracking branch, commit hash",
+		"missing~1":         "tried tag, branch, remote-tracking branch, commit hash",
+		"main~9":            "tried revision expression",
+		"main@{2024-01-01}": "tried date selector",
+		"main@{1}":          "reflog selector",
+		"main@{someday}":    "unsupported date",
+	} {
+		_, err := r.CommitFromRevision(revision)
+		var re *RevisionError
+		if !errors.As(err, &re) || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected revision error with %q for %s, got %v", message, revision, err)
+		}
+	}
+}
+
+func TestResolveRevision(t *testing.T) {
+	r, hashes := revisionFixture(t)
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelError})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	tests := []struct {
+		revision  string
+		reference plumbing.ReferenceName
+		base      plumbing.ReferenceName
+		expected  plumbing.Hash
+	}{
+		{"feature", "refs/heads/feature", "", hashes[0]},
+		{"stable", "refs/remotes/origin/stable", "", hashes[1]},
+		{"1.x", "refs/heads/1.x", "", hashes[2]},
+		{"^1.0", "refs/tags/v1.0.0", "", hashes[1]},
+		{"main~2", "", "refs/heads/master", hashes[1]},
+		{"v1.0.0^", "", "refs/tags/v1.0.0", hashes[0]},
+		{"@{2025-01-03T12:00:00Z}", "", "refs/heads/master", hashes[2]},
+		{hashes[2].String()[:8], "", "", hashes[2]},
+	}
+	for _, test := range tests {
+		resolution, err := r.Resolve(context.Background(), test.revision)
+		if err != nil {
+			t.Errorf("cannot resolve %s: %v", test.revision, err)
+			continue
+		}
+		if resolution.Commit.Hash != test.expected {
+			t.Errorf("expected %s to resolve to %s, got %s", test.revision, test.expected, resolution.Commit.Hash)
+		}
+		if name := referenceName(resolution.Reference); name != test.reference {
+			t.Errorf("expected %s to resolve through %q, got %q", test.revision, test.reference, name)
+		}
+		if name := referenceName(resolution.Base); name != test.base {
+			t.Errorf("expected %s to be based on %q, got %q", test.revision, test.base, name)
+		}
+	}
+	// revisions that are not tags are not reported as missing tags
+	if logs.Len() > 0 {
+		t.Errorf("unexpected errors logged:\n%s", logs.String())
+	}
+}
+
+// referenceName returns the name of the given reference, if any.
+func referenceName(reference *plumbing.Reference) plumbing.ReferenceName {
+	if reference == nil {
+		return ""
+	}
+	return reference.Name()
+}
+
+func TestParseDate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"2025-01-01", time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"2025-01-01 10:30", time.Date(2025, 1, 1, 10, 30, 0, 0, time.Local)},
+		{"2025-01-01 10:30:15", time.Date(2025, 1, 1, 10, 30, 15, 0, time.Local)},
+		{"2025-01-01T10:30:15Z", time.Date(2025, 1, 1, 10, 30, 15, 0, time.UTC)},
+		{" now ", now},
+		{"yesterday", now.AddDate(0, 0, -1)},
+		{"3 hours ago", now.Add(-3 * time.Hour)},
+		{"1.day.ago", now.AddDate(0, 0, -1)},
+		{"2 weeks ago", now.AddDate(0, 0, -14)},
+		{"1 month ago", now.AddDate(0, -1, 0)},
+		{"2 Years Ago", now.AddDate(-2, 0, 0)},
+	}
+	for _, test := range tests {
+		date, err := parseDate(test.value)
+		if err != nil {
+			t.Errorf("cannot parse %q: %v", test.value, err)
+		} else if date.Sub(test.expected).Abs() > time.Minute {
+			t.Errorf("expected %q to be %s, got %s", test.value, test.expected, date)
+		}
+	}
+	for _, value := range []string{"1", "someday", "2 fortnights ago", "2025-13-01"} {
+		if _, err := parseDate(value); err == nil {
+			t.Errorf("expected error parsing %q", value)
+		}
+	}
+}
+
+func TestCommitFromReference(t *testing.T) {
+	r, hashes := revisionFixture(t)
+	tagger := &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	annotated, err := r.repository.CreateTag("v2.0.0", hashes[2], &git.CreateTagOptions{Tagger: tagger, Message: "Release 2.0.0"})
+	if err != nil {
+		t.Fatalf("cannot create tag: %v", err)
+	}
+	// a tag of a tag, as created by "git tag -a nested v2.0.0"
+	nested, err := r.repository.CreateTag("nested", annotated.Hash(), &git.CreateTagOptions{Tagger: tagger, Message: "Nested"})
+	if err != nil {
+		t.Fatalf("cannot create nested tag: %v", err)
+	}
+	commit, err := r.repository.CommitObject(hashes[2])
+	if err != nil {
+		t.Fatalf("cannot get commit: %v", err)
+	}
+	file, err := commit.File("README.md")
+	if err != nil {
+		t.Fatalf("cannot get file: %v", err)
+	}
+	blob, err := r.repository.CreateTag("blob", file.Hash, &git.CreateTagOptions{Tagger: tagger, Message: "Blob"})
+	if err != nil {
+		t.Fatalf("cannot create blob tag: %v", err)
+	}
+
+	// 1. annotated tags are peeled to the commit they point to
+	for _, reference := range []*plumbing.Reference{annotated, nested} {
+		commit, err := r.CommitFromReference(reference)
+		if err != nil {
+			t.Errorf("cannot peel %s: %v", reference.Name(), err)
+		} else if commit.Hash != hashes[2] {
+			t.Errorf("expected %s to be peeled to %s, got %s", reference.Name(), hashes[2], commit.Hash)
+		}
+	}
+
+	// 2. tags pointing to anything else are refused
+	if _, err := r.CommitFromReference(blob); err == nil || !strings.Contains(err.Error(), "points to a blob, not to a commit") {
+		t.Errorf("expected error peeling a tag of a blob, got %v", err)
+	}
+}
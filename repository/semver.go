@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// LatestRelease is the keyword selecting the tag with the highest semantic
+// version.
+const LatestRelease = "latest-release"
+
+// plainVersion matches partial or complete versions like 1.4 or v2.0.1.
+var plainVersion = regexp.MustCompile(`^v?\d+\.\d+(\.\d+)?$`)
+
+// WithPreReleases configures the Repository to consider pre-release tags
+// when resolving version constraints.
+func WithPreReleases() Option {
+	return func(repository *Repository) error {
+		repository.prerelease = true
+		return nil
+	}
+}
+
+// Release is a tag whose name is a valid semantic version.
+type Release struct {
+	Reference *plumbing.Reference
+	Version   *semver.Version
+}
+
+// Name returns the name of the tag.
+func (r *Release) Name() string {
+	return r.Reference.Name().Short()
+}
+
+// IsConstraint returns whether the given revision looks like a semantic version
+// constraint (e.g. ^1.4, ~2.0, >=1.2 <2, 1.x or 1.4) rather than a tag, branch
+// or commit name. Revisions that look like both, such as a branch named 1.x,
+// resolve to the tag or branch of that name if there is one.
+func IsConstraint(revision string) bool {
+	if revision == "" {
+		return false
+	}
+	if _, err := semver.NewConstraint(revision); err != nil {
+		return false
+	}
+	return strings.ContainsAny(revision[:1], "^~<>=!") ||
+		strings.ContainsAny(revision, " ,|*") ||
+		strings.Contains(revision, ".x") || strings.Contains(revision, ".X") ||
+		plainVersion.MatchString(revision)
+}
+
+// Releases returns the tags whose names are valid semantic versions, sorted
+// from the highest to the lowest version; pre-releases are only included if
+// requested.
+func (r *Repository) Releases(prerelease bool) ([]*Release, error) {
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+	return releases(tags, prerelease), nil
+}
+
+// Release returns the tag with the highest semantic version satisfying the
+// given constraint; the LatestRelease keyword selects the highest version
+// overall. Pre-releases are only considered if requested, or if the constraint
+// itself mentions a pre-release.
+func (r *Repository) Release(constraint string, prerelease bool) (*Release, error) {
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+	return selectRelease(releases(tags, true), constraint, prerelease)
+}
+
+// releases returns the references among the given ones that are tags named
+// after a valid semantic version, sorted from the highest to the lowest.
+func releases(references []*plumbing.Reference, prerelease bool) []*Release {
+	result := []*Release{}
+	for _, reference := range references {
+		if !reference.Name().IsTag() || strings.HasSuffix(reference.Name().String(), "^{}") {
+			continue
+		}
+		version, err := semver.NewVersion(reference.Name().Short())
+		if err != nil {
+			slog.Debug("skipping tag that is not a semantic version", "tag", reference.Name().Short())
+			continue
+		}
+		if version.Prerelease() != "" && !prerelease {
+			slog.Debug("skipping pre-release tag", "tag", reference.Name().Short())
+			continue
+		}
+		result = append(result, &Release{
+			Reference: reference,
+			Version:   version,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Version.GreaterThan(result[j].Version)
+	})
+	return result
+}
+
+// selectRelease returns the highest of the given releases that satisfies the
+// constraint.
+func selectRelease(releases []*Release, constraint string, prerelease bool) (*Release, error) {
+	if constraint == LatestRelease {
+		constraint = "*"
+	}
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		slog.Error("invalid version constraint", "constraint", constraint, "error", err)
+		return nil, fmt.Errorf("invalid version constraint '%s': %w", constraint, err)
+	}
+	constraints.IncludePrerelease = prerelease
+	for _, release := range releases {
+		if constraints.Check(release.Version) {
+			slog.Debug("version constraint satisfied", "constraint", constraint, "tag", release.Name())
+			return release, nil
+		}
+	}
+	slog.Error("no tag satisfies version constraint", "constraint", constraint)
+	return nil, fmt.Errorf("no tag satisfies version constraint '%s'", constraint)
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+func TestIsConstraint(t *testing.T) {
+	tests := map[string]bool{
+		"^1.4":       true,
+		"~2.0":       true,
+		">=1.2 <2":   true,
+		">=1.2, <2":  true,
+		"1.x":        true,
+		"2.X":        true,
+		"1.4":        true,
+		"v1.4.2":     true,
+		"*":          true,
+		"^1 || ^2":   true,
+		"":           false,
+		"v2":         false,
+		"main":       false,
+		"v1.0.0~1":   false,
+		"feature/x":  false,
+		"1a2b3c4d":   false,
+		"latest":     false,
+		"release-1":  false,
+		"main@{now}": false,
+	}
+	for revision, expected := range tests {
+		if got := IsConstraint(revision); got != expected {
+			t.Errorf("expected IsConstraint(%q) to be %v, got %v", revision, expected, got)
+		}
+	}
+}
+
+// tagReferences returns references to the given names, as tags unless they
+// are full reference names already.
+func tagReferences(names ...string) []*plumbing.Reference {
+	references := []*plumbing.Reference{}
+	for _, name := range names {
+		reference := plumbing.ReferenceName(name)
+		if !reference.IsBranch() && !reference.IsTag() {
+			reference = plumbing.NewTagReferenceName(name)
+		}
+		references = append(references, plumbing.NewHashReference(reference, plumbing.ZeroHash))
+	}
+	return references
+}
+
+// releaseNames returns the names of the given releases.
+func releaseNames(releases []*Release) []string {
+	names := []string{}
+	for _, release := range releases {
+		names = append(names, release.Name())
+	}
+	return names
+}
+
+func TestReleases(t *testing.T) {
+	references := tagReferences("v1.0.0", "1.2.0", "v2.0.0-rc.1", "nightly", "v1.10.0", "refs/heads/v3.0.0", "v0.9")
+	if got, expected := releaseNames(releases(references, false)), []string{"v1.10.0", "1.2.0", "v1.0.0", "v0.9"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected releases %v, got %v", expected, got)
+	}
+	if got, expected := releaseNames(releases(references, true)), []string{"v2.0.0-rc.1", "v1.10.0", "1.2.0", "v1.0.0", "v0.9"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected releases with pre-releases %v, got %v", expected, got)
+	}
+}
+
+func TestSelectRelease(t *testing.T) {
+	available := releases(tagReferences("v1.0.0", "v1.4.0", "v1.4.3", "v1.5.0-beta.1", "v2.0.0", "v2.1.0-rc.1"), true)
+	tests := []struct {
+		constraint string
+		prerelease bool
+		expected   string
+	}{
+		{LatestRelease, false, "v2.0.0"},
+		{LatestRelease, true, "v2.1.0-rc.1"},
+		{"^1.4", false, "v1.4.3"},
+		{"^1.4", true, "v1.5.0-beta.1"},
+		{"~1.4", false, "v1.4.3"},
+		{"1.x", false, "v1.4.3"},
+		{"1.4", false, "v1.4.3"},
+		{">=1.0 <1.4", false, "v1.0.0"},
+		{">=1.5.0-0 <2", false, "v1.5.0-beta.1"},
+		{"^2 || ^1", false, "v2.0.0"},
+	}
+	for _, test := range tests {
+		release, err := selectRelease(available, test.constraint, test.prerelease)
+		if err != nil {
+			t.Errorf("cannot select release for %s: %v", test.constraint, err)
+		} else if release.Name() != test.expected {
+			t.Errorf("expected %s for %s (pre-releases: %v), got %s", test.expected, test.constraint, test.prerelease, release.Name())
+		}
+	}
+	for _, constraint := range []string{"^3", ">=2.1", "not a constraint"} {
+		if _, err := selectRelease(available, constraint, false); err == nil {
+			t.Errorf("expected no release for %s", constraint)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrUnsigned is returned when verifying an object that carries no signature.
+	ErrUnsigned = errors.New("object is not signed")
+	// ErrUntrustedSignature is returned when an object is signed with a key
+	// that is not in the keyring, or the signature does not match the object.
+	ErrUntrustedSignature = errors.New("signature is invalid or made with an untrusted key")
+)
+
+// SSHNamespace is the namespace git uses for SSH signatures of commits and tags.
+const SSHNamespace = "git"
+
+// Keyring holds the keys trusted to sign archetype commits and tags: OpenPGP
+// public keys and SSH allowed signers.
+type Keyring struct {
+	openpgp openpgp.EntityList
+	signers []*AllowedSigner
+}
+
+// AllowedSigner is an entry of an SSH allowed signers file.
+type AllowedSigner struct {
+	Principals []string
+	Namespaces []string
+	Key        ssh.PublicKey
+}
+
+// Verification reports who signed a verified object and how.
+type Verification struct {
+	// Object is the kind of object verified, "commit" or "tag".
+	Object string
+	// Hash is the hash of the verified object.
+	Hash plumbing.Hash
+	// Format is the signature format, "openpgp" or "ssh".
+	Format string
+	// Signer identifies the trusted key that made the signature: the primary
+	// identity of the OpenPGP key, or the first principal of the first allowed
+	// signer entry for the SSH key, in file order.
+	Signer string
+}
+
+// LoadKeyring loads the trusted keys from the given files; each file may
+// contain either OpenPGP armored public keys or SSH allowed signers, in the
+// format used by git's gpg.ssh.allowedSignersFile.
+func LoadKeyring(paths ...string) (*Keyring, error) {
+	keyring := &Keyring{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed to read keyring file", "path", path, "error", err)
+			return nil, fmt.Errorf("failed to read keyring file '%s': %w", path, err)
+		}
+		if err := keyring.Add(data); err != nil {
+			slog.Error("failed to parse keyring file", "path", path, "error", err)
+			return nil, fmt.Errorf("failed to parse keyring file '%s': %w", path, err)
+		}
+	}
+	if len(keyring.openpgp) == 0 && len(keyring.signers) == 0 {
+		slog.Error("no trusted keys in keyring")
+		return nil, errors.New("no trusted keys in keyring")
+	}
+	return keyring, nil
+}
+
+// Add adds the keys in the given data, either OpenPGP armored public keys or
+// SSH allowed signers, to the keyring.
+func (k *Keyring) Add(data []byte) error {
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		// the data may contain several concatenated armored blocks
+		for len(bytes.TrimSpace(data)) > 0 {
+			start := bytes.Index(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----"))
+			if start < 0 {
+				break
+			}
+			end := bytes.Index(data[start:], []byte("-----END PGP PUBLIC KEY BLOCK-----"))
+			if end < 0 {
+				return errors.New("unterminated OpenPGP armored block")
+			}
+			end += start + len("-----END PGP PUBLIC KEY BLOCK-----")
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data[start:end]))
+			if err != nil {
+				return err
+			}
+			k.openpgp = append(k.openpgp, entities...)
+			data = data[end:]
+		}
+		return nil
+	}
+	signers, err := ParseAllowedSigners(data)
+	if err != nil {
+		return err
+	}
+	k.signers = append(k.signers, signers...)
+	return nil
+}
+
+// ParseAllowedSigners parses an SSH allowed signers file: each line holds a
+// comma-separated list of principals, optional options (among which only
+// namespaces is honoured) and a public key.
+func ParseAllowedSigners(data []byte) ([]*AllowedSigner, error) {
+	signers := []*AllowedSigner{}
+	for number, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid allowed signer on line %d", number+1)
+		}
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(fields[1])))
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed signer key on line %d: %w", number+1, err)
+		}
+		signer := &AllowedSigner{
+			Principals: strings.Split(fields[0], ","),
+			Key:        key,
+		}
+		for _, option := range options {
+			if value, ok := strings.CutPrefix(strings.ToLower(option), "namespaces="); ok {
+				signer.Namespaces = strings.Split(strings.Trim(value, `"`), ",")
+			}
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// VerifyCommit checks that the given commit is signed by a trusted key.
+func (k *Keyring) VerifyCommit(commit *object.Commit) (*Verification, error) {
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return nil, err
+	}
+	verification, err := k.verify(encoded, commit.PGPSignature)
+	if err != nil {
+		slog.Error("commit signature verification failed", "hash", commit.Hash.String(), "error", err)
+		return nil, fmt.Errorf("commit %s: %w", commit.Hash.String(), err)
+	}
+	verification.Object = "commit"
+	verification.Hash = commit.Hash
+	slog.Info("commit signature verified", "hash", commit.Hash.String(), "format", verification.Format, "signer", verification.Signer)
+	return verification, nil
+}
+
+// VerifyTag checks that the given annotated tag is signed by a trusted key.
+func (k *Keyring) VerifyTag(tag *object.Tag) (*Verification, error) {
+	encoded := &plumbing.MemoryObject{}
+	if err := tag.EncodeWithoutSignature(encoded); err != nil {
+		return nil, err
+	}
+	verification, err := k.verify(encoded, tag.PGPSignature)
+	if err != nil {
+		slog.Error("tag signature verification failed", "tag", tag.Name, "error", err)
+		return nil, fmt.Errorf("tag %s: %w", tag.Name, err)
+	}
+	verification.Object = "tag"
+	verification.Hash = tag.Hash
+	slog.Info("tag signature verified", "tag", tag.Name, "format", verification.Format, "signer", verification.Signer)
+	return verification, nil
+}
+
+// Verify checks the signature of a resolved revision: if it resolved to a
+// signed annotated tag the tag signature is checked, otherwise that of the
+// commit.
+func (k *Keyring) Verify(resolution *Resolution) (*Verification, error) {
+	if resolution.Annotation != nil && resolution.Annotation.PGPSignature != "" {
+		return k.VerifyTag(resolution.Annotation)
+	}
+	return k.VerifyCommit(resolution.Commit)
+}
+
+// verify checks the signature over the given encoded object.
+func (k *Keyring) verify(encoded *plumbing.MemoryObject, signature string) (*Verification, error) {
+	reader, err := encoded.Reader()
+	if err != nil {
+		return nil, err
+	}
+	message, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	switch SignatureFormat(signature) {
+	case "":
+		return nil, ErrUnsigned
+	case "openpgp":
+		if len(k.openpgp) == 0 {
+			return nil, fmt.Errorf("%w: no OpenPGP keys in keyring", ErrUntrustedSignature)
+		}
+		entity, err := openpgp.CheckArmoredDetachedSignature(k.openpgp, bytes.NewReader(message), strings.NewReader(signature), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUntrustedSignature, err)
+		}
+		signer := fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+		if identity := entity.PrimaryIdentity(); identity != nil {
+			signer = identity.Name
+		}
+		return &Verification{Format: "openpgp", Signer: signer}, nil
+	case "ssh":
+		signer, err := k.verifySSH(message, signature)
+		if err != nil {
+			return nil, err
+		}
+		return &Verification{Format: "ssh", Signer: signer}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported signature format %s", ErrUntrustedSignature, SignatureFormat(signature))
+	}
+}
+
+// verifySSH checks an armored SSHSIG signature over the given message and
+// returns the first principal of the first allowed signer entry, in file
+// order, that matches the key.
+func (k *Keyring) verifySSH(message []byte, armored string) (string, error) {
+	if len(k.signers) == 0 {
+		return "", fmt.Errorf("%w: no SSH allowed signers in keyring", ErrUntrustedSignature)
+	}
+	signature, err := ParseSSHSignature(armored)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUntrustedSignature, err)
+	}
+	if signature.Namespace != SSHNamespace {
+		return "", fmt.Errorf("%w: unexpected namespace '%s'", ErrUntrustedSignature, signature.Namespace)
+	}
+	var h hash.Hash
+	switch signature.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("%w: unsupported hash algorithm '%s'", ErrUntrustedSignature, signature.HashAlgorithm)
+	}
+	h.Write(message)
+	signed := SSHSignedData(signature.Namespace, signature.HashAlgorithm, h.Sum(nil))
+
+	for _, signer := range k.signers {
+		if !bytes.Equal(signer.Key.Marshal(), signature.PublicKey.Marshal()) {
+			continue
+		}
+		if len(signer.Namespaces) > 0 && !contains(signer.Namespaces, SSHNamespace) {
+			continue
+		}
+		if err := signer.Key.Verify(signed, signature.Signature); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrUntrustedSignature, err)
+		}
+		return signer.Principals[0], nil
+	}
+	return "", fmt.Errorf("%w: key %s is not an allowed signer", ErrUntrustedSignature, ssh.FingerprintSHA256(signature.PublicKey))
+}
+
+// SSHSignature is a decoded SSHSIG signature, as produced by ssh-keygen -Y sign.
+type SSHSignature struct {
+	PublicKey     ssh.PublicKey
+	Namespace     string
+	HashAlgorithm string
+	Signature     *ssh.Signature
+}
+
+// ParseSSHSignature decodes an armored SSHSIG signature.
+func ParseSSHSignature(armored string) (*SSHSignature, error) {
+	armored = strings.TrimSpace(armored)
+	armored = strings.TrimPrefix(armored, "-----BEGIN SSH SIGNATURE-----")
+	armored = strings.TrimSuffix(armored, "-----END SSH SIGNATURE-----")
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(armored), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH signature encoding: %w", err)
+	}
+	var envelope struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if !bytes.HasPrefix(blob, []byte("SSHSIG")) {
+		return nil, errors.New("invalid SSH signature magic")
+	}
+	if err := ssh.Unmarshal(blob[len("SSHSIG"):], &envelope); err != nil {
+		return nil, fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	if envelope.Version != 1 {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", envelope.Version)
+	}
+	key, err := ssh.ParsePublicKey(envelope.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH signature public key: %w", err)
+	}
+	signature := &ssh.Signature{}
+	if err := ssh.Unmarshal(envelope.Signature, signature); err != nil {
+		return nil, fmt.Errorf("invalid SSH signature blob: %w", err)
+	}
+	return &SSHSignature{
+		PublicKey:     key,
+		Namespace:     envelope.Namespace,
+		HashAlgorithm: envelope.HashAlgorithm,
+		Signature:     signature,
+	}, nil
+}
+
+// SSHSignedData returns the data actually signed in an SSHSIG signature, given
+// the namespace, the hash algorithm and the hash of the message.
+func SSHSignedData(namespace string, algorithm string, digest []byte) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("SSHSIG")
+	for _, field := range [][]byte{[]byte(namespace), {}, []byte(algorithm), digest} {
+		binary.Write(&buffer, binary.BigEndian, uint32(len(field)))
+		buffer.Write(field)
+	}
+	return buffer.Bytes()
+}
+
+// contains returns whether the given slice contains the value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
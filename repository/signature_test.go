@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-billy/v6/memfs"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/storage/memory"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSigner signs git objects with an ed25519 key in the SSHSIG format, the
+// same as git does with gpg.format=ssh.
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha512.Sum512(data)
+	signature, err := s.signer.Sign(rand.Reader, SSHSignedData(SSHNamespace, "sha512", digest[:]))
+	if err != nil {
+		return nil, err
+	}
+	blob := []byte("SSHSIG")
+	blob = append(blob, ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{1, s.signer.PublicKey().Marshal(), SSHNamespace, "", "sha512", ssh.Marshal(signature)})...)
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var buffer bytes.Buffer
+	buffer.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		buffer.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	buffer.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+	return buffer.Bytes(), nil
+}
+
+// newSSHSigner generates a new ed25519 signer and the matching allowed signers entry.
+func newSSHSigner(t *testing.T, principal string) (*sshSigner, []byte) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate ed25519 key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("cannot create SSH signer: %v", err)
+	}
+	allowed := fmt.Sprintf("%s namespaces=\"git\" %s", principal, ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	return &sshSigner{signer: signer}, []byte(allowed)
+}
+
+// newOpenPGPEntity generates a new OpenPGP entity and its armored public key.
+func newOpenPGPEntity(t *testing.T, name string) (*openpgp.Entity, []byte) {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatalf("cannot generate OpenPGP entity: %v", err)
+	}
+	var buffer bytes.Buffer
+	writer, err := armor.Encode(&buffer, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("cannot armor OpenPGP key: %v", err)
+	}
+	if err := entity.Serialize(writer); err != nil {
+		t.Fatalf("cannot serialise OpenPGP key: %v", err)
+	}
+	writer.Close()
+	return entity, buffer.Bytes()
+}
+
+// newFixture creates an in-memory repository with a single file and returns
+// it wrapped in a Repository, together with the worktree to add commits.
+func newFixture(t *testing.T) (*Repository, *git.Worktree) {
+	t.Helper()
+	fs := memfs.New()
+	repository, err := git.Init(memory.NewStorage(), git.WithWorkTree(fs))
+	if err != nil {
+		t.Fatalf("cannot initialise repository: %v", err)
+	}
+	worktree, err := repository.Worktree()
+	if err != nil {
+		t.Fatalf("cannot get worktree: %v", err)
+	}
+	return &Repository{address: "memory://fixture", repository: repository}, worktree
+}
+
+// commit adds a commit changing the README file, signed as per the options.
+func commit(t *testing.T, worktree *git.Worktree, message string, options *git.CommitOptions) {
+	t.Helper()
+	file, err := worktree.Filesystem.Create("README.md")
+	if err != nil {
+		t.Fatalf("cannot create file: %v", err)
+	}
+	file.Write([]byte(message))
+	file.Close()
+	if _, err := worktree.Add("README.md"); err != nil {
+		t.Fatalf("cannot add file: %v", err)
+	}
+	options.Author = &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	if _, err := worktree.Commit(message, options); err != nil {
+		t.Fatalf("cannot commit: %v", err)
+	}
+}
+
+func TestVerifyCommitOpenPGP(t *testing.T) {
+	trusted, trustedKey := newOpenPGPEntity(t, "trusted")
+	untrusted, _ := newOpenPGPEntity(t, "untrusted")
+	keyring := &Keyring{}
+	if err := keyring.Add(trustedKey); err != nil {
+		t.Fatalf("cannot load keyring: %v", err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		options  *git.CommitOptions
+		expected error
+	}{
+		{"trusted", &git.CommitOptions{SignKey: trusted}, nil},
+		{"untrusted", &git.CommitOptions{SignKey: untrusted}, ErrUntrustedSignature},
+		{"unsigned", &git.CommitOptions{}, ErrUnsigned},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			repository, worktree := newFixture(t)
+			commit(t, worktree, test.name, test.options)
+			resolution, err := repository.Resolve(context.Background(), "latest")
+			if err != nil {
+				t.Fatalf("cannot resolve latest: %v", err)
+			}
+			verification, err := keyring.Verify(resolution)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("unexpected verification result: expected %v got %v", test.expected, err)
+			}
+			if err == nil && (verification.Format != "openpgp" || !strings.Contains(verification.Signer, "trusted")) {
+				t.Fatalf("unexpected verification: %+v", verification)
+			}
+		})
+	}
+}
+
+func TestVerifyCommitSSH(t *testing.T) {
+	trusted, allowed := newSSHSigner(t, "dev@example.com")
+	untrusted, _ := newSSHSigner(t, "other@example.com")
+	keyring := &Keyring{}
+	if err := keyring.Add(allowed); err != nil {
+		t.Fatalf("cannot load allowed signers: %v", err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		options  *git.CommitOptions
+		expected error
+	}{
+		{"trusted", &git.CommitOptions{Signer: trusted}, nil},
+		{"untrusted", &git.CommitOptions{Signer: untrusted}, ErrUntrustedSignature},
+		{"unsigned", &git.CommitOptions{}, ErrUnsigned},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			repository, worktree := newFixture(t)
+			commit(t, worktree, test.name, test.options)
+			resolution, err := repository.Resolve(context.Background(), "latest")
+			if err != nil {
+				t.Fatalf("cannot resolve latest: %v", err)
+			}
+			verification, err := keyring.Verify(resolution)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("unexpected verification result: expected %v got %v", test.expected, err)
+			}
+			if err == nil && (verification.Format != "ssh" || verification.Signer != "dev@example.com") {
+				t.Fatalf("unexpected verification: %+v", verification)
+			}
+		})
+	}
+}
+
+func TestVerifySigner(t *testing.T) {
+	trusted, allowed := newSSHSigner(t, "dev@example.com,alias@example.com")
+	// several entries for the same key, the first in file order being reported
+	key := string(ssh.MarshalAuthorizedKey(trusted.signer.PublicKey()))
+	keyring := &Keyring{}
+	if err := keyring.Add([]byte("other@example.com namespaces=\"file\" " + key + string(allowed) + "second@example.com " + key)); err != nil {
+		t.Fatalf("cannot load allowed signers: %v", err)
+	}
+	repository, worktree := newFixture(t)
+	commit(t, worktree, "signed", &git.CommitOptions{Signer: trusted})
+	resolution, err := repository.Resolve(context.Background(), "latest")
+	if err != nil {
+		t.Fatalf("cannot resolve latest: %v", err)
+	}
+	for range 10 {
+		verification, err := keyring.Verify(resolution)
+		if err != nil {
+			t.Fatalf("cannot verify commit: %v", err)
+		}
+		if verification.Signer != "dev@example.com" {
+			t.Fatalf("expected the first matching principal, got %s", verification.Signer)
+		}
+	}
+}
+
+func TestVerifyTamperedCommit(t *testing.T) {
+	trusted, allowed := newSSHSigner(t, "dev@example.com")
+	keyring := &Keyring{}
+	if err := keyring.Add(allowed); err != nil {
+		t.Fatalf("cannot load allowed signers: %v", err)
+	}
+	repository, worktree := newFixture(t)
+	commit(t, worktree, "original", &git.CommitOptions{Signer: trusted})
+	resolution, err := repository.Resolve(context.Background(), "latest")
+	if err != nil {
+		t.Fatalf("cannot resolve latest: %v", err)
+	}
+	resolution.Commit.Message = "tampered"
+	if _, err := keyring.Verify(resolution); !errors.Is(err, ErrUntrustedSignature) {
+		t.Fatalf("tampered commit not detected: %v", err)
+	}
+}
+
+func TestVerifyAnnotatedTag(t *testing.T) {
+	trusted, trustedKey := newOpenPGPEntity(t, "trusted")
+	keyring := &Keyring{}
+	if err := keyring.Add(trustedKey); err != nil {
+		t.Fatalf("cannot load keyring: %v", err)
+	}
+	repository, worktree := newFixture(t)
+	commit(t, worktree, "unsigned commit", &git.CommitOptions{})
+	head, err := repository.Head()
+	if err != nil {
+		t.Fatalf("cannot get HEAD: %v", err)
+	}
+	_, err = repository.repository.CreateTag("v1.0.0", head.Hash(), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+		Message: "Release 1.0.0",
+		SignKey: trusted,
+	})
+	if err != nil {
+		t.Fatalf("cannot create tag: %v", err)
+	}
+	resolution, err := repository.Resolve(context.Background(), "v1.0.0")
+	if err != nil {
+		t.Fatalf("cannot resolve tag: %v", err)
+	}
+	if resolution.Annotation == nil || strings.TrimSpace(resolution.Annotation.Message) != "Release 1.0.0" {
+		t.Fatalf("annotated tag not resolved: %+v", resolution.Annotation)
+	}
+	verification, err := keyring.Verify(resolution)
+	if err != nil {
+		t.Fatalf("cannot verify signed tag: %v", err)
+	}
+	if verification.Object != "tag" {
+		t.Fatalf("expected tag verification, got %s", verification.Object)
+	}
+}
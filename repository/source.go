@@ -0,0 +1,493 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dihedron/archetype/progress"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	format "github.com/go-git/go-git/v6/plumbing/format/config"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// File is a file in an archetype source: a blob in a git commit, or a file in
+// a plain directory or in a local archive.
+type File interface {
+	// Name returns the path of the file, relative to the archetype root and
+	// using forward slashes.
+	Name() string
+	// Mode returns the permissions and type of the file; git modes are mapped
+	// to 0644 for regular files, 0755 for executables and fs.ModeSymlink|0777
+	// for symbolic links, whose contents are their target.
+	Mode() fs.FileMode
+	// Size returns the size of the file in bytes.
+	Size() int64
+	// Hash returns the git blob hash of the file contents.
+	Hash() plumbing.Hash
+	// Reader returns a reader over the file contents.
+	Reader() (io.ReadCloser, error)
+	// Contents returns the file contents as a string.
+	Contents() (string, error)
+}
+
+// Source is a set of files making up an archetype: a git commit, a plain
+// directory or a local tar or zip archive.
+type Source interface {
+	// Files returns the files in the source, sorted by name.
+	Files() ([]File, error)
+	// File returns the file with the given name, relative to the archetype root.
+	File(name string) (File, error)
+	// String returns a description of the source.
+	String() string
+}
+
+// VisitOption is a functional option for configuring ForEachFile.
+type VisitOption func(*visit)
+
+// visit holds the settings of an iteration over the files in a source.
+type visit struct {
+	reporter progress.Reporter
+	phase    string
+	failFast bool
+	jobs     int
+}
+
+// WithVisitProgress makes ForEachFile report the iteration to the given
+// reporter as a phase with the given name, along with the number of files.
+func WithVisitProgress(reporter progress.Reporter, phase string) VisitOption {
+	return func(v *visit) {
+		v.reporter = reporter
+		v.phase = phase
+	}
+}
+
+// WithFailFast makes ForEachFile stop at the first file the visitor fails on,
+// instead of visiting all the files.
+func WithFailFast() VisitOption {
+	return func(v *visit) {
+		v.failFast = true
+	}
+}
+
+// WithJobs makes ForEachFile visit up to the given number of files at the same
+// time; the visitor must then be safe for concurrent use. The errors are still
+// returned in the order of the files.
+func WithJobs(jobs int) VisitOption {
+	return func(v *visit) {
+		v.jobs = jobs
+	}
+}
+
+// FileError is the error the visitor returned for a file.
+type FileError struct {
+	Name string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ForEachFile iterates over all the files in the given source and calls the
+// visitor function for each file, until the context is done, one file at a
+// time or as many at a time as set with WithJobs. The errors the visitor
+// returns are collected and returned together, as FileErrors in the order of
+// the files, once all the files have been visited, or as soon as one occurs
+// with WithFailFast, in which case no more files are started.
+func ForEachFile(ctx context.Context, source Source, visitor FileVisitor, options ...VisitOption) error {
+	settings := &visit{jobs: 1}
+	for _, option := range options {
+		option(settings)
+	}
+	files, err := source.Files()
+	if err != nil {
+		slog.Error("error getting files in source", "source", source.String(), "error", err)
+		return err
+	}
+	if settings.reporter != nil {
+		defer progress.Phase(settings.reporter, settings.phase, len(files))()
+	}
+	jobs := max(1, min(settings.jobs, len(files)))
+	slog.Debug("visiting files", "source", source.String(), "files", len(files), "jobs", jobs)
+
+	// each file is started, in order, as soon as a worker is free; the error
+	// of each file, if any, is recorded at its index
+	errs := make([]error, len(files))
+	slots := make(chan struct{}, jobs)
+	var failed atomic.Bool
+	var workers sync.WaitGroup
+	for i, file := range files {
+		slots <- struct{}{}
+		if ctx.Err() != nil || (settings.failFast && failed.Load()) {
+			break
+		}
+		workers.Go(func() {
+			defer func() { <-slots }()
+			if err := visitor(ctx, file); err != nil {
+				slog.Error("error visiting file", "source", source.String(), "file", file.Name(), "error", err)
+				errs[i] = &FileError{Name: file.Name(), Err: err}
+				failed.Store(true)
+			}
+		})
+	}
+	workers.Wait()
+
+	if err := ctx.Err(); err != nil {
+		slog.Warn("iteration over files cancelled", "source", source.String(), "error", err)
+		return err
+	}
+	if settings.failFast && failed.Load() {
+		slog.Warn("stopped at the first failure", "source", source.String())
+	}
+	return errors.Join(errs...)
+}
+
+// Metadata returns the archetype metadata file in the given source.
+func Metadata(source Source) (File, error) {
+	file, err := source.File(MetadataFile)
+	if err != nil {
+		slog.Error("archetype metadata file not found", "source", source.String(), "error", err)
+		return nil, fmt.Errorf("no archetype found in %s (missing %s): %w", source.String(), MetadataFile, err)
+	}
+	return file, nil
+}
+
+// Archetypes returns the paths of all the archetypes in the given source, i.e.
+// of all the directories containing an archetype metadata file, sorted by
+// path; the archetype at the root of the source, if any, is reported as ".".
+func Archetypes(source Source) ([]string, error) {
+	files, err := source.Files()
+	if err != nil {
+		slog.Error("error getting files in source", "source", source.String(), "error", err)
+		return nil, err
+	}
+	paths := []string{}
+	for _, file := range files {
+		if file.Name() == MetadataFile {
+			paths = append(paths, ".")
+		} else if strings.HasSuffix(file.Name(), "/"+MetadataFile) {
+			paths = append(paths, strings.TrimSuffix(file.Name(), "/"+MetadataFile))
+		}
+	}
+	sort.Strings(paths)
+	slog.Debug("archetypes found in source", "source", source.String(), "paths", paths)
+	return paths, nil
+}
+
+// IsPlainSource returns whether the given address points to a local directory
+// that is not a git repository, or to a local tar or zip archive; these are
+// opened with OpenSource instead of New.
+func IsPlainSource(address string) bool {
+	address, err := NormaliseAddress(address, nil)
+	if err != nil {
+		return false
+	}
+	address, _ = SplitAddress(address)
+	location, ok := localPath(address)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(location)
+	if err != nil {
+		return false
+	}
+	if !info.IsDir() {
+		return archiveFormat(location) != ""
+	}
+	if _, err := git.PlainOpen(location); errors.Is(err, git.ErrRepositoryNotExists) {
+		return true
+	}
+	return false
+}
+
+// OpenSource opens a plain directory or a local tar or zip archive as an
+// archetype source; the archetype path (either in the repo//subdir form or
+// through WithPath) and the sparse prefix are honoured, while all the other
+// options only apply to git repositories and are ignored.
+func OpenSource(address string, options ...Option) (Source, error) {
+	filter, err := configure(address, options...)
+	if err != nil {
+		return nil, err
+	}
+	location, ok := localPath(filter.address)
+	if !ok {
+		slog.Error("not a local source", "address", filter.address)
+		return nil, fmt.Errorf("'%s' is not a local directory or archive", filter.address)
+	}
+	info, err := os.Stat(location)
+	if err != nil {
+		slog.Error("cannot access local source", "location", location, "error", err)
+		return nil, err
+	}
+	if info.IsDir() {
+		slog.Info("using plain directory as archetype source", "directory", location, "path", filter.path)
+		return &directorySource{directory: location, filter: filter}, nil
+	}
+	slog.Info("using local archive as archetype source", "archive", location, "path", filter.path)
+	return openArchive(location, filter)
+}
+
+// Source returns the files in the given commit as an archetype source.
+func (r *Repository) Source(commit *object.Commit) Source {
+	return &commitSource{repository: r, commit: commit}
+}
+
+// include returns the name of the given file relative to the archetype path,
+// and whether the file is to be included at all, considering both the
+// archetype path and the sparse prefix.
+func (r *Repository) include(name string) (string, bool) {
+	name, ok := r.relative(name)
+	if !ok || !r.inSparse(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// localPath returns the local filesystem path the given normalised address
+// points to, and false if the address is a remote one.
+func localPath(address string) (string, bool) {
+	if !strings.HasPrefix(address, "file://") {
+		return "", false
+	}
+	address = strings.TrimPrefix(address, "file://")
+	if driveLetter.MatchString(strings.TrimPrefix(address, "/")) {
+		address = strings.TrimPrefix(address, "/")
+	}
+	return filepath.FromSlash(address), true
+}
+
+// blobHash returns the git blob hash of the given contents.
+func blobHash(data []byte) plumbing.Hash {
+	hasher := plumbing.NewHasher(format.SHA1, plumbing.BlobObject, int64(len(data)))
+	hasher.Write(data)
+	return hasher.Sum()
+}
+
+// commitSource is the Source of the files in a git commit; the files are
+// listed once, since listing them walks the tree and fetches the submodules
+// and the Git LFS objects, if any.
+type commitSource struct {
+	repository *Repository
+	commit     *object.Commit
+	lock       sync.Mutex
+	files      []File
+}
+
+func (s *commitSource) Files() ([]File, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.files == nil {
+		entries, err := s.repository.files(s.commit)
+		if err != nil {
+			return nil, err
+		}
+		s.files = make([]File, 0, len(entries))
+		for _, entry := range entries {
+			s.files = append(s.files, entry.owner.wrap(entry.file))
+		}
+	}
+	return slices.Clone(s.files), nil
+}
+
+func (s *commitSource) File(name string) (File, error) {
+	if s.commit == nil {
+		return nil, fmt.Errorf("invalid commit")
+	}
+	file, err := s.commit.File(path.Join(s.repository.path, name))
+	if err != nil {
+		if !errors.Is(err, object.ErrFileNotFound) || s.repository.noSubmodules {
+			return nil, err
+		}
+		// the file may be in a submodule
+		files, e := s.Files()
+		if e != nil {
+			return nil, e
+		}
+		for _, file := range files {
+			if file.Name() == name {
+				return file, nil
+			}
+		}
+		return nil, err
+	}
+	file.Name = name
+	if !s.repository.noLFS {
+		s.repository.lfs(s.commit)
+	}
+	return s.repository.wrap(file), nil
+}
+
+func (s *commitSource) String() string {
+	description := fmt.Sprintf("%s at %s", s.repository.address, s.commit.Hash.String())
+	if s.repository.path != "" {
+		description += fmt.Sprintf(" (path %s)", s.repository.path)
+	}
+	return description
+}
+
+// commitFile is a File backed by a blob in a git commit.
+type commitFile struct {
+	file *object.File
+}
+
+func (f *commitFile) Name() string {
+	return f.file.Name
+}
+
+func (f *commitFile) Mode() fs.FileMode {
+	mode, err := f.file.Mode.ToOSFileMode()
+	if err != nil {
+		return 0644
+	}
+	return mode
+}
+
+func (f *commitFile) Size() int64 {
+	return f.file.Size
+}
+
+func (f *commitFile) Hash() plumbing.Hash {
+	return f.file.Hash
+}
+
+func (f *commitFile) Reader() (io.ReadCloser, error) {
+	return f.file.Reader()
+}
+
+func (f *commitFile) Contents() (string, error) {
+	return f.file.Contents()
+}
+
+// directorySource is the Source of the files in a plain directory; the .git
+// directory, if any, is skipped.
+type directorySource struct {
+	directory string
+	filter    *Repository
+}
+
+func (s *directorySource) Files() ([]File, error) {
+	files := []File{}
+	err := filepath.WalkDir(s.directory, func(location string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relative, err := filepath.Rel(s.directory, location)
+		if err != nil {
+			return err
+		}
+		name, ok := s.filter.include(filepath.ToSlash(relative))
+		if !ok {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			slog.Debug("skipping special file", "file", location, "mode", info.Mode())
+			return nil
+		}
+		files = append(files, &directoryFile{name: name, location: location, info: info})
+		return nil
+	})
+	if err != nil {
+		slog.Error("error walking directory", "directory", s.directory, "error", err)
+		return nil, err
+	}
+	return files, nil
+}
+
+func (s *directorySource) File(name string) (File, error) {
+	location := filepath.Join(s.directory, filepath.FromSlash(path.Join(s.filter.path, name)))
+	info, err := os.Lstat(location)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("'%s' is a directory", name)
+	}
+	return &directoryFile{name: name, location: location, info: info}, nil
+}
+
+func (s *directorySource) String() string {
+	if s.filter.path != "" {
+		return fmt.Sprintf("directory %s (path %s)", s.directory, s.filter.path)
+	}
+	return fmt.Sprintf("directory %s", s.directory)
+}
+
+// directoryFile is a File in a plain directory; symbolic links are reported
+// as such, with their target as contents, the same as in git.
+type directoryFile struct {
+	name     string
+	location string
+	info     fs.FileInfo
+}
+
+func (f *directoryFile) Name() string {
+	return f.name
+}
+
+func (f *directoryFile) Mode() fs.FileMode {
+	return f.info.Mode()
+}
+
+func (f *directoryFile) Size() int64 {
+	return f.info.Size()
+}
+
+func (f *directoryFile) Hash() plumbing.Hash {
+	data, err := f.data()
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	return blobHash(data)
+}
+
+func (f *directoryFile) Reader() (io.ReadCloser, error) {
+	if f.info.Mode()&fs.ModeSymlink != 0 {
+		data, err := f.data()
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return os.Open(f.location)
+}
+
+func (f *directoryFile) Contents() (string, error) {
+	data, err := f.data()
+	return string(data), err
+}
+
+func (f *directoryFile) data() ([]byte, error) {
+	if f.info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(f.location)
+		return []byte(filepath.ToSlash(target)), err
+	}
+	return os.ReadFile(f.location)
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fixture is the set of files in the test archetypes, relative to the root.
+var fixture = map[string]string{
+	".archetype/metadata.yml":              "version: 1\n",
+	"README.md":                            "hello {{.Name}}\n",
+	"services/api/.archetype/metadata.yml": "version: 1\n",
+	"services/api/main.go":                 "package main\n",
+	".git/HEAD":                            "ref: refs/heads/main\n",
+}
+
+func writeDirectory(t *testing.T) string {
+	t.Helper()
+	directory := t.TempDir()
+	for name, contents := range fixture {
+		if strings.HasPrefix(name, ".git/") {
+			// a .git directory would make it a (broken) git repository
+			continue
+		}
+		location := filepath.Join(directory, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+			t.Fatalf("cannot create directory: %v", err)
+		}
+		if err := os.WriteFile(location, []byte(contents), 0644); err != nil {
+			t.Fatalf("cannot write file: %v", err)
+		}
+	}
+	return directory
+}
+
+func writeTarGz(t *testing.T) string {
+	t.Helper()
+	location := filepath.Join(t.TempDir(), "archetype-1.0.0.tar.gz")
+	file, err := os.Create(location)
+	if err != nil {
+		t.Fatalf("cannot create archive: %v", err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	defer gz.Close()
+	archive := tar.NewWriter(gz)
+	defer archive.Close()
+	for name, contents := range fixture {
+		// mimic the archives of git hosting services, with a top-level directory
+		header := &tar.Header{Name: "archetype-1.0.0/" + name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatalf("cannot write header: %v", err)
+		}
+		archive.Write([]byte(contents))
+	}
+	return location
+}
+
+func writeZip(t *testing.T) string {
+	t.Helper()
+	location := filepath.Join(t.TempDir(), "archetype.zip")
+	file, err := os.Create(location)
+	if err != nil {
+		t.Fatalf("cannot create archive: %v", err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	defer archive.Close()
+	for name, contents := range fixture {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("cannot create entry: %v", err)
+		}
+		writer.Write([]byte(contents))
+	}
+	return location
+}
+
+func names(t *testing.T, source Source) []string {
+	t.Helper()
+	files, err := source.Files()
+	if err != nil {
+		t.Fatalf("cannot list files: %v", err)
+	}
+	result := []string{}
+	for _, file := range files {
+		result = append(result, file.Name())
+	}
+	return result
+}
+
+func TestPlainSources(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		address string
+	}{
+		{"directory", writeDirectory(t)},
+		{"tar.gz", writeTarGz(t)},
+		{"zip", writeZip(t)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if !IsPlainSource(test.address) {
+				t.Fatalf("%s not recognised as a plain source", test.address)
+			}
+
+			source, err := OpenSource(test.address)
+			if err != nil {
+				t.Fatalf("cannot open source: %v", err)
+			}
+			expected := []string{".archetype/metadata.yml", "README.md", "services/api/.archetype/metadata.yml", "services/api/main.go"}
+			if got := names(t, source); !reflect.DeepEqual(got, expected) {
+				t.Fatalf("unexpected files: expected %v, got %v", expected, got)
+			}
+			archetypes, err := Archetypes(source)
+			if err != nil || !reflect.DeepEqual(archetypes, []string{".", "services/api"}) {
+				t.Fatalf("unexpected archetypes: %v (%v)", archetypes, err)
+			}
+			file, err := Metadata(source)
+			if err != nil {
+				t.Fatalf("cannot get metadata: %v", err)
+			}
+			if contents, _ := file.Contents(); contents != "version: 1\n" {
+				t.Fatalf("unexpected metadata contents: %q", contents)
+			}
+			if file.Hash().String() != blobHash([]byte("version: 1\n")).String() {
+				t.Fatalf("unexpected metadata hash: %s", file.Hash())
+			}
+
+			source, err = OpenSource(test.address + "//services/api")
+			if err != nil {
+				t.Fatalf("cannot open source with path: %v", err)
+			}
+			if got := names(t, source); !reflect.DeepEqual(got, []string{".archetype/metadata.yml", "main.go"}) {
+				t.Fatalf("unexpected files under path: %v", got)
+			}
+			if _, err := Metadata(source); err != nil {
+				t.Fatalf("cannot get metadata under path: %v", err)
+			}
+		})
+	}
+}
+
+func TestForEachFileCancelled(t *testing.T) {
+	source, err := OpenSource(writeDirectory(t))
+	if err != nil {
+		t.Fatalf("cannot open source: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	visited := []string{}
+	err = ForEachFile(ctx, source, func(ctx context.Context, file File) error {
+		visited = append(visited, file.Name())
+		if len(visited) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+	if len(visited) != 2 {
+		t.Fatalf("expected iteration to stop after 2 files, visited %v", visited)
+	}
+
+	// clones are cancelled too, which is checked against a local repository
+	// cloned as a remote one would be
+	address, _ := fetchFixture(t)
+	r := &Repository{address: address, ctx: ctx}
+	if err := r.clone(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error when cloning, got %v", err)
+	}
+}
+
+func TestForEachFileErrors(t *testing.T) {
+	source, err := OpenSource(writeDirectory(t))
+	if err != nil {
+		t.Fatalf("cannot open source: %v", err)
+	}
+	broken := errors.New("broken")
+	visitor := func(visited *[]string) FileVisitor {
+		return func(ctx context.Context, file File) error {
+			*visited = append(*visited, file.Name())
+			if strings.HasSuffix(file.Name(), ".md") || strings.HasSuffix(file.Name(), ".go") {
+				return broken
+			}
+			return nil
+		}
+	}
+
+	visited := []string{}
+	err = ForEachFile(context.Background(), source, visitor(&visited))
+	if len(visited) != 4 {
+		t.Fatalf("expected all files to be visited, visited %v", visited)
+	}
+	var failure *FileError
+	if !errors.Is(err, broken) || !errors.As(err, &failure) || failure.Name != "README.md" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "README.md: broken") || !strings.Contains(err.Error(), "services/api/main.go: broken") {
+		t.Fatalf("expected all failures to be reported, got %v", err)
+	}
+
+	visited = []string{}
+	err = ForEachFile(context.Background(), source, visitor(&visited), WithFailFast())
+	if len(visited) != 2 || !errors.Is(err, broken) || strings.Contains(err.Error(), "main.go") {
+		t.Fatalf("expected iteration to stop at the first failure, visited %v (%v)", visited, err)
+	}
+}
+
+func TestForEachFileJobs(t *testing.T) {
+	source, err := OpenSource(writeDirectory(t))
+	if err != nil {
+		t.Fatalf("cannot open source: %v", err)
+	}
+	var lock sync.Mutex
+	var once sync.Once
+	running, peak, visited := 0, 0, 0
+	release := make(chan struct{})
+	visitor := func(ctx context.Context, file File) error {
+		lock.Lock()
+		running++
+		visited++
+		peak = max(peak, running)
+		if running == 2 {
+			once.Do(func() { close(release) })
+		}
+		lock.Unlock()
+		<-release
+		lock.Lock()
+		running--
+		lock.Unlock()
+		if strings.HasSuffix(file.Name(), ".md") || strings.HasSuffix(file.Name(), ".go") {
+			return errors.New("broken")
+		}
+		return nil
+	}
+	err = ForEachFile(context.Background(), source, visitor, WithJobs(2))
+	if visited != 4 || peak != 2 {
+		t.Fatalf("expected 4 files to be visited 2 at a time, visited %d, %d at a time", visited, peak)
+	}
+	var failure *FileError
+	if !errors.As(err, &failure) || failure.Name != "README.md" || strings.Index(err.Error(), "README.md") > strings.Index(err.Error(), "main.go") {
+		t.Fatalf("expected the failures in the order of the files, got %v", err)
+	}
+}
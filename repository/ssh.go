@@ -0,0 +1,422 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh/knownhosts"
+	"github.com/kevinburke/ssh_config"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrPassphraseRequired is returned when an SSH private key is protected by
+	// a passphrase but there is no way to get it.
+	ErrPassphraseRequired = errors.New("SSH key is protected by a passphrase")
+	// ErrKnownHostsNotFound is returned when there is no known_hosts file to
+	// check the identity of the SSH server against.
+	ErrKnownHostsNotFound = errors.New("known_hosts file not found")
+	// ErrNoSSHKey is returned when none of the default SSH keys exists.
+	ErrNoSSHKey = errors.New("no SSH key found")
+	// ErrHostKeyMismatch is returned when the SSH server key does not match the
+	// pinned fingerprints.
+	ErrHostKeyMismatch = errors.New("SSH host key does not match")
+)
+
+// DefaultSSHKeys are the names of the SSH keys in ~/.ssh tried, in this order,
+// when no key is given explicitly, after those in IdentityFile directives.
+var DefaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// SSHConfig is the source of the user's ssh_config settings; it defaults to
+// ~/.ssh/config and /etc/ssh/ssh_config.
+var SSHConfig SSHConfigReader = ssh_config.DefaultUserSettings
+
+// SSHConfigReader reads settings for a host alias from ssh_config files.
+type SSHConfigReader interface {
+	Get(alias string, key string) string
+	GetAll(alias string, key string) []string
+}
+
+// HostKeyPolicy is the policy for checking the identity of SSH servers.
+type HostKeyPolicy string
+
+const (
+	// HostKeyStrict accepts only servers whose key is in the known_hosts files.
+	HostKeyStrict HostKeyPolicy = "strict"
+	// HostKeyAcceptNew also accepts servers not in the known_hosts files, and
+	// adds their key to the first one, but refuses servers whose key changed.
+	HostKeyAcceptNew HostKeyPolicy = "accept-new"
+	// HostKeyFingerprint accepts only servers whose key has one of the pinned
+	// SHA256 fingerprints, regardless of the known_hosts files.
+	HostKeyFingerprint HostKeyPolicy = "fingerprint"
+)
+
+// Passphrase returns the passphrase to decrypt the SSH private key at the given
+// path; it is only called if the key is actually protected by a passphrase.
+type Passphrase func(path string) ([]byte, error)
+
+// StaticPassphrase returns a Passphrase that always returns the given value.
+func StaticPassphrase(passphrase string) Passphrase {
+	return func(string) ([]byte, error) {
+		return []byte(passphrase), nil
+	}
+}
+
+// sshSettings collects the SSH options; they are resolved against the host in
+// the repository address, and the user's ssh_config for it, once the address
+// is known.
+type sshSettings struct {
+	key          string
+	defaultKeys  bool
+	agent        bool
+	passphrase   Passphrase
+	knownHosts   []string
+	policy       HostKeyPolicy
+	fingerprints []string
+}
+
+// settings returns the SSH settings of the repository, creating them if needed.
+func (r *Repository) settings() *sshSettings {
+	if r.ssh == nil {
+		r.ssh = &sshSettings{}
+	}
+	return r.ssh
+}
+
+// WithSSHKey configures the Repository to use an SSH key for authentication;
+// if the key is protected by a passphrase, it is obtained through the given
+// function, which may be nil for keys without one.
+func WithSSHKey(path string, passphrase Passphrase) Option {
+	return func(repository *Repository) error {
+		settings := repository.settings()
+		settings.key = path
+		settings.passphrase = passphrase
+		return nil
+	}
+}
+
+// WithDefaultSSHKey configures the Repository to use the SSH keys in the
+// IdentityFile directives of the user's ssh_config for the host, and then the
+// DefaultSSHKeys in ~/.ssh, for authentication; the server is offered all the
+// existing ones in this order. Passphrases are obtained as in WithSSHKey.
+func WithDefaultSSHKey(passphrase Passphrase) Option {
+	return func(repository *Repository) error {
+		settings := repository.settings()
+		settings.defaultKeys = true
+		settings.passphrase = passphrase
+		return nil
+	}
+}
+
+// WithSSHAgent configures the Repository to use an SSH agent for authentication.
+func WithSSHAgent() Option {
+	return func(repository *Repository) error {
+		repository.settings().agent = true
+		return nil
+	}
+}
+
+// WithKnownHosts configures the known_hosts files to check the identity of SSH
+// servers against, instead of those in the user's ssh_config (the
+// UserKnownHostsFile directive) or ~/.ssh/known_hosts.
+func WithKnownHosts(files ...string) Option {
+	return func(repository *Repository) error {
+		repository.settings().knownHosts = files
+		return nil
+	}
+}
+
+// WithHostKeyPolicy configures the policy for checking the identity of SSH
+// servers, instead of the one in the user's ssh_config (the
+// StrictHostKeyChecking directive); fingerprints (as in SHA256:...) are only
+// used, and required, with HostKeyFingerprint.
+func WithHostKeyPolicy(policy HostKeyPolicy, fingerprints ...string) Option {
+	return func(repository *Repository) error {
+		switch policy {
+		case HostKeyStrict, HostKeyAcceptNew:
+		case HostKeyFingerprint:
+			if len(fingerprints) == 0 {
+				return errors.New("the fingerprint host key policy requires at least one fingerprint")
+			}
+		default:
+			return fmt.Errorf("unsupported host key policy '%s'", policy)
+		}
+		settings := repository.settings()
+		settings.policy = policy
+		settings.fingerprints = fingerprints
+		return nil
+	}
+}
+
+// setupSSH creates the SSH authentication method from the SSH options and the
+// user's ssh_config for the host in the repository address; HostName and Port
+// are applied by the git transport itself.
+func (r *Repository) setupSSH() error {
+	settings := r.ssh
+	alias, login := sshHost(r.address)
+	configured := func(key string) string {
+		if SSHConfig == nil {
+			return ""
+		}
+		return SSHConfig.Get(alias, key)
+	}
+	if hostname := configured("HostName"); hostname != "" {
+		slog.Debug("SSH host alias from ssh_config", "alias", alias, "hostname", hostname, "port", configured("Port"))
+	}
+
+	// 1. the remote user: the one in the address, then the one in ssh_config
+	if login == "" {
+		login = configured("User")
+	}
+	if login == "" {
+		login = "git"
+	}
+
+	// 2. the authentication method
+	var method ssh.AuthMethod
+	var helper *ssh.HostKeyCallbackHelper
+	switch {
+	case settings.key != "":
+		signer, err := loadSSHKey(settings.key, settings.passphrase)
+		if err != nil {
+			return err
+		}
+		keys := &ssh.PublicKeys{User: login, Signer: signer}
+		method, helper = keys, &keys.HostKeyCallbackHelper
+	case settings.defaultKeys:
+		signers, err := r.defaultSSHKeys(alias, login, settings.passphrase)
+		if err != nil {
+			return err
+		}
+		keys := &ssh.PublicKeysCallback{User: login, Callback: func() ([]gossh.Signer, error) { return signers, nil }}
+		method, helper = keys, &keys.HostKeyCallbackHelper
+	default:
+		// the SSH agent, either requested or as the git transport does anyway
+		slog.Info("setting up SSH authentication using SSH agent...")
+		agent, err := ssh.NewSSHAgentAuth(login)
+		if err != nil {
+			slog.Error("failed to connect to SSH agent", "error", err)
+			return fmt.Errorf("cannot connect to SSH agent: %w", err)
+		}
+		method, helper = agent, &agent.HostKeyCallbackHelper
+	}
+
+	// 3. the identity check of the server
+	policy := settings.policy
+	if policy == "" {
+		switch strings.ToLower(configured("StrictHostKeyChecking")) {
+		case "accept-new":
+			policy = HostKeyAcceptNew
+		case "no", "off":
+			slog.Warn("StrictHostKeyChecking disabled in ssh_config, accepting new host keys only", "host", alias)
+			policy = HostKeyAcceptNew
+		default:
+			policy = HostKeyStrict
+		}
+	}
+	files := settings.knownHosts
+	if len(files) == 0 && SSHConfig != nil {
+		for _, value := range SSHConfig.GetAll(alias, "UserKnownHostsFile") {
+			for _, file := range strings.Fields(value) {
+				if file != "" && file != "/dev/null" {
+					files = append(files, expandSSHPath(file, alias, login))
+				}
+			}
+		}
+	}
+	if len(files) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			slog.Error("failed to get user home directory", "error", err)
+			return fmt.Errorf("cannot locate known_hosts file: %w", err)
+		}
+		files = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+	callback, err := hostKeyCallback(policy, files, settings.fingerprints)
+	if err != nil {
+		return err
+	}
+	helper.HostKeyCallback = callback
+	slog.Info("SSH authentication configured", "host", alias, "user", login, "method", method.Name(), "policy", policy, "known_hosts", files)
+	r.auth = method
+	return nil
+}
+
+// defaultSSHKeys loads the keys in the IdentityFile directives for the host
+// and the DefaultSSHKeys, skipping those that do not exist.
+func (r *Repository) defaultSSHKeys(alias string, login string, passphrase Passphrase) ([]gossh.Signer, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		slog.Error("failed to get user home directory", "error", err)
+		return nil, fmt.Errorf("cannot locate default SSH keys: %w", err)
+	}
+	candidates := []string{}
+	if SSHConfig != nil {
+		for _, file := range SSHConfig.GetAll(alias, "IdentityFile") {
+			// skip the legacy default reported when there is no directive
+			if file != ssh_config.Default("IdentityFile") {
+				candidates = append(candidates, expandSSHPath(file, alias, login))
+			}
+		}
+	}
+	for _, name := range DefaultSSHKeys {
+		candidates = append(candidates, filepath.Join(home, ".ssh", name))
+	}
+	signers := []gossh.Signer{}
+	tried := []string{}
+	var failure error
+	for _, path := range candidates {
+		if slices.Contains(tried, path) {
+			continue
+		}
+		tried = append(tried, path)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		signer, err := loadSSHKey(path, passphrase)
+		if err != nil {
+			slog.Warn("skipping unusable SSH key", "path", path, "error", err)
+			failure = err
+			continue
+		}
+		slog.Debug("using SSH key", "path", path, "type", signer.PublicKey().Type())
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 && failure != nil {
+		return nil, failure
+	} else if len(signers) == 0 {
+		slog.Error("no usable SSH key found", "tried", tried)
+		return nil, fmt.Errorf("%w (tried %s)", ErrNoSSHKey, strings.Join(tried, ", "))
+	}
+	return signers, nil
+}
+
+// loadSSHKey reads the SSH private key at the given path, asking for its
+// passphrase if it is protected by one.
+func loadSSHKey(path string, passphrase Passphrase) (gossh.Signer, error) {
+	slog.Info("setting up SSH authentication...", "key", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("failed to read SSH key", "path", path, "error", err)
+		return nil, fmt.Errorf("cannot read SSH key: %w", err)
+	}
+	signer, err := gossh.ParsePrivateKey(data)
+	if _, ok := err.(*gossh.PassphraseMissingError); ok {
+		if passphrase == nil {
+			slog.Error("SSH key protected by a passphrase but none available", "path", path)
+			return nil, fmt.Errorf("%w: %s", ErrPassphraseRequired, path)
+		}
+		secret, e := passphrase(path)
+		if e != nil {
+			slog.Error("failed to get passphrase for SSH key", "path", path, "error", e)
+			return nil, fmt.Errorf("cannot get passphrase for SSH key %s: %w", path, e)
+		}
+		signer, err = gossh.ParsePrivateKeyWithPassphrase(data, secret)
+	}
+	if err != nil {
+		slog.Error("failed to parse SSH key", "path", path, "error", err)
+		return nil, fmt.Errorf("invalid SSH key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// hostKeyCallback returns the callback checking the identity of SSH servers
+// according to the given policy.
+func hostKeyCallback(policy HostKeyPolicy, files []string, fingerprints []string) (gossh.HostKeyCallback, error) {
+	switch policy {
+	case HostKeyFingerprint:
+		return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			fingerprint := gossh.FingerprintSHA256(key)
+			for _, pinned := range fingerprints {
+				if strings.TrimPrefix(pinned, "SHA256:") == strings.TrimPrefix(fingerprint, "SHA256:") {
+					return nil
+				}
+			}
+			slog.Error("SSH host key does not match the pinned fingerprints", "host", hostname, "fingerprint", fingerprint)
+			return fmt.Errorf("%w for %s: got %s", ErrHostKeyMismatch, hostname, fingerprint)
+		}, nil
+	case HostKeyAcceptNew:
+		return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			existing := []string{}
+			for _, file := range files {
+				if _, err := os.Stat(file); err == nil {
+					existing = append(existing, file)
+				}
+			}
+			if len(existing) > 0 {
+				db, err := knownhosts.NewDB(existing...)
+				if err != nil {
+					return fmt.Errorf("invalid known_hosts file: %w", err)
+				}
+				err = db.HostKeyCallback()(hostname, remote, key)
+				if err == nil || !knownhosts.IsHostUnknown(err) {
+					return err
+				}
+			}
+			slog.Warn("adding new SSH host key to known_hosts", "host", hostname, "fingerprint", gossh.FingerprintSHA256(key), "file", files[0])
+			if err := os.MkdirAll(filepath.Dir(files[0]), 0700); err != nil {
+				return fmt.Errorf("cannot create known_hosts directory: %w", err)
+			}
+			file, err := os.OpenFile(files[0], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return fmt.Errorf("cannot update known_hosts file: %w", err)
+			}
+			defer file.Close()
+			return knownhosts.WriteKnownHost(file, hostname, remote, key)
+		}, nil
+	default:
+		existing := []string{}
+		for _, file := range files {
+			if _, err := os.Stat(file); err == nil {
+				existing = append(existing, file)
+			}
+		}
+		if len(existing) == 0 {
+			slog.Error("known_hosts file not found", "files", files)
+			return nil, fmt.Errorf("%w: %s (add the server key, e.g. with ssh-keyscan, or use the accept-new host key policy)", ErrKnownHostsNotFound, strings.Join(files, ", "))
+		}
+		callback, err := ssh.NewKnownHostsCallback(existing...)
+		if err != nil {
+			slog.Error("failed to create known_hosts callback", "files", existing, "error", err)
+			return nil, fmt.Errorf("invalid known_hosts file: %w", err)
+		}
+		return callback, nil
+	}
+}
+
+// sshHost returns the host (or host alias) and the user in an SSH address.
+func sshHost(address string) (string, string) {
+	parsed, err := url.Parse(scpToURL(address))
+	if err != nil {
+		return "", ""
+	}
+	login := ""
+	if parsed.User != nil {
+		login = parsed.User.Username()
+	}
+	return parsed.Hostname(), login
+}
+
+// expandSSHPath expands the ~ and the %d, %h, %r, %u and %% tokens in paths in
+// ssh_config files.
+func expandSSHPath(path string, host string, login string) string {
+	home, _ := os.UserHomeDir()
+	local := ""
+	if account, err := user.Current(); err == nil {
+		local = account.Username
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = home + path[1:]
+	}
+	path = strings.NewReplacer("%%", "%", "%d", home, "%h", host, "%r", login, "%u", local).Replace(path)
+	return filepath.FromSlash(path)
+}
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	"github.com/kevinburke/ssh_config"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testSSHConfig adapts a parsed ssh_config file to SSHConfigReader.
+type testSSHConfig struct {
+	config *ssh_config.Config
+}
+
+func (c *testSSHConfig) Get(alias string, key string) string {
+	value, _ := c.config.Get(alias, key)
+	return value
+}
+
+func (c *testSSHConfig) GetAll(alias string, key string) []string {
+	values, _ := c.config.GetAll(alias, key)
+	return values
+}
+
+// useSSHConfig makes the given ssh_config contents the user's for the test.
+func useSSHConfig(t *testing.T, contents string) {
+	t.Helper()
+	config, err := ssh_config.Decode(strings.NewReader(contents))
+	if err != nil {
+		t.Fatalf("cannot parse ssh_config: %v", err)
+	}
+	previous := SSHConfig
+	SSHConfig = &testSSHConfig{config}
+	t.Cleanup(func() { SSHConfig = previous })
+}
+
+// writeSSHKey writes a new ed25519 private key with the given name, protected
+// by the given passphrase unless empty, into the .ssh directory of the given
+// home directory, and returns its path and public key.
+func writeSSHKey(t *testing.T, home string, name string, passphrase string) (string, gossh.PublicKey) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatalf("cannot create .ssh directory: %v", err)
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = gossh.MarshalPrivateKey(private, "test")
+	} else {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(private, "test", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("cannot marshal key: %v", err)
+	}
+	path := filepath.Join(home, ".ssh", name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("cannot write key: %v", err)
+	}
+	key, _ := gossh.NewPublicKey(public)
+	return path, key
+}
+
+// newHome creates a home directory for the test, with a known_hosts file
+// unless told otherwise, and an empty ssh_config.
+func newHome(t *testing.T, knownHosts bool) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	useSSHConfig(t, "")
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatalf("cannot create .ssh directory: %v", err)
+	}
+	if knownHosts {
+		_, key := writeSSHKey(t, t.TempDir(), "host", "")
+		line := "git.example.com " + string(gossh.MarshalAuthorizedKey(key))
+		if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(line), 0600); err != nil {
+			t.Fatalf("cannot write known_hosts: %v", err)
+		}
+	}
+	return home
+}
+
+const sshAddress = "git@git.example.com:team/archetype"
+
+func TestWithSSHKey(t *testing.T) {
+	t.Run("no passphrase", func(t *testing.T) {
+		path, _ := writeSSHKey(t, newHome(t, true), "id_rsa", "")
+		repository, err := configure(sshAddress, WithSSHKey(path, nil))
+		if err != nil || repository.auth == nil {
+			t.Fatalf("cannot use key: %v", err)
+		}
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		path, _ := writeSSHKey(t, newHome(t, true), "id_rsa", "s3cret")
+		if _, err := configure(sshAddress, WithSSHKey(path, nil)); !errors.Is(err, ErrPassphraseRequired) {
+			t.Fatalf("expected passphrase required, got %v", err)
+		}
+		if _, err := configure(sshAddress, WithSSHKey(path, StaticPassphrase("wrong"))); err == nil {
+			t.Fatalf("expected error with wrong passphrase")
+		}
+		asked := ""
+		passphrase := func(p string) ([]byte, error) {
+			asked = p
+			return []byte("s3cret"), nil
+		}
+		repository, err := configure(sshAddress, WithDefaultSSHKey(passphrase))
+		if err != nil || repository.auth == nil {
+			t.Fatalf("cannot use key with passphrase: %v", err)
+		}
+		if asked != path {
+			t.Fatalf("passphrase asked for %q, expected %q", asked, path)
+		}
+	})
+
+	t.Run("missing known_hosts", func(t *testing.T) {
+		path, _ := writeSSHKey(t, newHome(t, false), "id_rsa", "")
+		if _, err := configure(sshAddress, WithSSHKey(path, nil)); !errors.Is(err, ErrKnownHostsNotFound) {
+			t.Fatalf("expected known_hosts not found, got %v", err)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		newHome(t, true)
+		if _, err := New(context.Background(), sshAddress, WithSSHKey("/nonexistent/id_rsa", nil)); err == nil {
+			t.Fatalf("expected error for missing key")
+		}
+	})
+
+	t.Run("not an SSH address", func(t *testing.T) {
+		path, _ := writeSSHKey(t, newHome(t, true), "id_rsa", "")
+		if _, err := configure("https://git.example.com/team/archetype", WithSSHKey(path, nil)); err == nil {
+			t.Fatalf("expected error for HTTPS address")
+		}
+	})
+}
+
+func TestDefaultSSHKeys(t *testing.T) {
+	home := newHome(t, true)
+	if _, err := configure(sshAddress, WithDefaultSSHKey(nil)); !errors.Is(err, ErrNoSSHKey) {
+		t.Fatalf("expected no SSH key, got %v", err)
+	}
+
+	_, rsa := writeSSHKey(t, home, "id_rsa", "")
+	_, ed25519 := writeSSHKey(t, home, "id_ed25519", "")
+	_, identity := writeSSHKey(t, home, "work_key", "")
+	useSSHConfig(t, `
+Host work
+	HostName git.example.com
+	User deploy
+	IdentityFile ~/.ssh/work_key
+	UserKnownHostsFile ~/.ssh/work_known_hosts
+	StrictHostKeyChecking accept-new
+`)
+
+	// 1. keys in the IdentityFile directives first, then the default ones
+	repository, err := configure("work:team/archetype", WithDefaultSSHKey(nil))
+	if err != nil {
+		t.Fatalf("cannot use default keys: %v", err)
+	}
+	auth, ok := repository.auth.(*ssh.PublicKeysCallback)
+	if !ok {
+		t.Fatalf("unexpected authentication method %T", repository.auth)
+	}
+	if auth.User != "deploy" {
+		t.Fatalf("expected user from ssh_config, got %q", auth.User)
+	}
+	signers, _ := auth.Callback()
+	expected := []gossh.PublicKey{identity, ed25519, rsa}
+	if len(signers) != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(signers))
+	}
+	for i, signer := range signers {
+		if string(signer.PublicKey().Marshal()) != string(expected[i].Marshal()) {
+			t.Fatalf("unexpected key at position %d", i)
+		}
+	}
+
+	// 2. the known_hosts file and host key policy from ssh_config: new keys are
+	// added to the known_hosts file, changed ones refused
+	_, server := writeSSHKey(t, t.TempDir(), "server", "")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	if err := auth.HostKeyCallback("git.example.com:22", remote, server); err != nil {
+		t.Fatalf("new host key refused: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".ssh", "work_known_hosts"))
+	if err != nil || !strings.Contains(string(data), "git.example.com") {
+		t.Fatalf("host key not added to known_hosts: %q (%v)", data, err)
+	}
+	if err := auth.HostKeyCallback("git.example.com:22", remote, server); err != nil {
+		t.Fatalf("known host key refused: %v", err)
+	}
+	_, other := writeSSHKey(t, t.TempDir(), "other", "")
+	if err := auth.HostKeyCallback("git.example.com:22", remote, other); err == nil {
+		t.Fatalf("changed host key accepted")
+	}
+
+	// 3. the user in the address takes precedence over the one in ssh_config
+	repository, err = configure("admin@work:team/archetype", WithDefaultSSHKey(nil), WithHostKeyPolicy(HostKeyStrict))
+	if err != nil {
+		t.Fatalf("cannot use default keys: %v", err)
+	}
+	if user := repository.auth.(*ssh.PublicKeysCallback).User; user != "admin" {
+		t.Fatalf("expected user from address, got %q", user)
+	}
+}
+
+func TestHostKeyFingerprint(t *testing.T) {
+	home := newHome(t, false)
+	path, _ := writeSSHKey(t, home, "id_ed25519", "")
+	_, server := writeSSHKey(t, t.TempDir(), "server", "")
+	if _, err := configure(sshAddress, WithSSHKey(path, nil), WithHostKeyPolicy(HostKeyFingerprint)); err == nil {
+		t.Fatalf("expected error without fingerprints")
+	}
+	repository, err := configure(sshAddress, WithSSHKey(path, nil), WithHostKeyPolicy(HostKeyFingerprint, gossh.FingerprintSHA256(server)))
+	if err != nil {
+		t.Fatalf("cannot pin fingerprint: %v", err)
+	}
+	callback := repository.auth.(*ssh.PublicKeys).HostKeyCallback
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	if err := callback("git.example.com:22", remote, server); err != nil {
+		t.Fatalf("pinned host key refused: %v", err)
+	}
+	_, other := writeSSHKey(t, t.TempDir(), "other", "")
+	if err := callback("git.example.com:22", remote, other); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Fatalf("expected host key mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// Submodule is a git submodule in a commit, pinned at a given commit.
+type Submodule struct {
+	// Name is the name of the submodule in the .gitmodules file.
+	Name string
+	// Path is the path of the submodule, relative to the repository root.
+	Path string
+	// URL is the address of the submodule repository, with relative URLs
+	// resolved against the address of the containing repository.
+	URL string
+	// Commit is the hash of the commit the submodule is pinned at.
+	Commit plumbing.Hash
+}
+
+// WithoutSubmodules configures the Repository not to fetch the submodules in
+// the commit tree; their directories are then missing from Files.
+func WithoutSubmodules() Option {
+	return func(repository *Repository) error {
+		repository.noSubmodules = true
+		return nil
+	}
+}
+
+// Submodules returns the submodules in the given commit, as declared in the
+// .gitmodules file at the repository root and pinned in the commit tree.
+func (r *Repository) Submodules(commit *object.Commit) ([]*Submodule, error) {
+	if commit == nil {
+		return nil, fmt.Errorf("invalid commit")
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		slog.Error("error getting tree for commit", "commit", commit.Hash, "error", err)
+		return nil, err
+	}
+
+	// 1. collect the gitlink entries in the tree
+	pinned := map[string]plumbing.Hash{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			slog.Error("error walking tree for commit", "commit", commit.Hash, "error", err)
+			return nil, err
+		}
+		if entry.Mode == filemode.Submodule {
+			pinned[name] = entry.Hash
+		}
+	}
+	if len(pinned) == 0 {
+		return nil, nil
+	}
+
+	// 2. match them against the declarations in .gitmodules
+	file, err := tree.File(".gitmodules")
+	if err != nil {
+		slog.Error("submodules found but no .gitmodules file", "commit", commit.Hash.String(), "error", err)
+		return nil, fmt.Errorf("commit %s has submodules but no .gitmodules file: %w", commit.Hash.String(), err)
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		slog.Error("error reading .gitmodules file", "commit", commit.Hash.String(), "error", err)
+		return nil, err
+	}
+	modules := config.NewModules()
+	if err := modules.Unmarshal([]byte(contents)); err != nil {
+		slog.Error("error parsing .gitmodules file", "commit", commit.Hash.String(), "error", err)
+		return nil, fmt.Errorf("invalid .gitmodules file in commit %s: %w", commit.Hash.String(), err)
+	}
+	submodules := []*Submodule{}
+	for _, module := range modules.Submodules {
+		hash, ok := pinned[module.Path]
+		if !ok {
+			slog.Warn("submodule declared but not in tree", "name", module.Name, "path", module.Path)
+			continue
+		}
+		address, err := resolveSubmoduleURL(r.address, module.URL)
+		if err != nil {
+			slog.Error("invalid submodule URL", "name", module.Name, "url", module.URL, "error", err)
+			return nil, fmt.Errorf("invalid URL '%s' for submodule '%s': %w", module.URL, module.Name, err)
+		}
+		submodules = append(submodules, &Submodule{
+			Name:   module.Name,
+			Path:   module.Path,
+			URL:    address,
+			Commit: hash,
+		})
+		delete(pinned, module.Path)
+	}
+	for name := range pinned {
+		slog.Warn("submodule in tree but not declared in .gitmodules", "path", name)
+	}
+	sort.Slice(submodules, func(i, j int) bool {
+		return submodules[i].Path < submodules[j].Path
+	})
+	return submodules, nil
+}
+
+// submoduleFiles returns the files in the given submodule at its pinned
+// commit, including those of its own submodules, with names relative to the
+// root of the containing repository.
+func (r *Repository) submoduleFiles(submodule *Submodule) ([]*entry, error) {
+	slog.Info("fetching submodule", "path", submodule.Path, "url", submodule.URL, "commit", submodule.Commit.String())
+	child := &Repository{
+		address:       submodule.URL,
+		proxySettings: r.proxySettings,
+		tls:           r.tls,
+		reporter:      r.reporter,
+		ctx:           r.ctx,
+		cache:         r.cache,
+		offline:       r.offline,
+		shorthands:    r.shorthands,
+		lfsStore:      r.lfsStore,
+		noLFS:         r.noLFS,
+	}
+	// the submodule may be on another host, with different proxy settings
+	if child.proxySettings != nil {
+		proxy, err := child.proxySettings.options(child.address)
+		if err != nil {
+			return nil, err
+		}
+		child.proxy = proxy
+	}
+	// reuse the authentication only if the submodule is on the same host and
+	// reached through the same kind of transport
+	if sharesCredentials(r.address, child.address) {
+		child.auth = r.auth
+	} else {
+		slog.Debug("not reusing authentication for submodule on another host or transport", "path", submodule.Path, "url", submodule.URL)
+	}
+	// without the cache, fetch only the pinned commit
+	if child.cache == nil && isRemoteAddress(child.address) {
+		child.shallow = true
+		child.revision = submodule.Commit.String()
+	}
+	if err := child.load(); err != nil {
+		slog.Error("failed to fetch submodule", "path", submodule.Path, "url", submodule.URL, "error", err)
+		return nil, fmt.Errorf("failed to fetch submodule '%s' from '%s': %w", submodule.Path, submodule.URL, err)
+	}
+	commit, err := child.repository.CommitObject(submodule.Commit)
+	if err != nil {
+		slog.Error("submodule commit not found", "path", submodule.Path, "commit", submodule.Commit.String(), "error", err)
+		return nil, fmt.Errorf("commit %s of submodule '%s' not found in '%s': %w", submodule.Commit.String(), submodule.Path, submodule.URL, err)
+	}
+	entries, err := child.tree(commit)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		entry.file = object.NewFile(path.Join(submodule.Path, entry.file.Name), entry.file.Mode, &entry.file.Blob)
+	}
+	return entries, nil
+}
+
+// sharesCredentials returns whether the credentials of the repository at the
+// given address may be used for the submodule at the other: only if it is on
+// the same host, so that they are not sent to whoever .gitmodules points to,
+// and reached through the same kind of transport, since e.g. SSH keys are no
+// good over HTTPS.
+func sharesCredentials(address string, submodule string) bool {
+	if IsSSHAddress(submodule) != IsSSHAddress(address) || IsHTTPAddress(submodule) != IsHTTPAddress(address) {
+		return false
+	}
+	return host(submodule) == host(address)
+}
+
+// entry is a file in a commit tree, along with the repository it comes from,
+// which is either the containing repository or one of its submodules.
+type entry struct {
+	file  *object.File
+	owner *Repository
+}
+
+// tree returns all the files in the given commit, with the files in its
+// submodules in place of the submodule entries unless disabled.
+func (r *Repository) tree(commit *object.Commit) ([]*entry, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		slog.Error("error getting tree for commit", "commit", commit.Hash, "error", err)
+		return nil, err
+	}
+	if !r.noLFS {
+		// initialise the LFS client while the commit (and its .lfsconfig) is at hand
+		r.lfs(commit)
+	}
+	files := []*entry{}
+	if err := tree.Files().ForEach(func(f *object.File) error {
+		files = append(files, &entry{file: f, owner: r})
+		return nil
+	}); err != nil {
+		slog.Error("error walking tree for commit", "commit", commit.Hash, "error", err)
+		return nil, err
+	}
+	if r.noSubmodules {
+		return files, nil
+	}
+	submodules, err := r.Submodules(commit)
+	if err != nil {
+		return nil, err
+	}
+	for _, submodule := range submodules {
+		if !r.overlaps(submodule.Path) {
+			slog.Debug("skipping submodule outside the archetype path", "path", submodule.Path)
+			continue
+		}
+		children, err := r.submoduleFiles(submodule)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, children...)
+	}
+	if len(submodules) > 0 {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].file.Name < files[j].file.Name
+		})
+	}
+	return files, nil
+}
+
+// overlaps returns whether the given directory and the archetype path overlap,
+// i.e. whether one contains the other.
+func (r *Repository) overlaps(directory string) bool {
+	return r.path == "" || strings.HasPrefix(directory+"/", r.path+"/") || strings.HasPrefix(r.path+"/", directory+"/")
+}
+
+// resolveSubmoduleURL resolves the URL of a submodule, which can be relative
+// (./ or ../) to the address of the containing repository.
+func resolveSubmoduleURL(base string, address string) (string, error) {
+	if address == "" {
+		return "", errors.New("empty submodule URL")
+	}
+	if !strings.HasPrefix(address, "./") && !strings.HasPrefix(address, "../") {
+		return NormaliseAddress(address, nil)
+	}
+	if match := schemeLike.FindStringSubmatch(base); match != nil {
+		parsed, err := url.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		parsed.Path = path.Join(parsed.Path, address)
+		return parsed.String(), nil
+	}
+	if match := scpLike.FindStringSubmatch(base); match != nil {
+		host, location, _ := strings.Cut(base, ":")
+		return host + ":" + path.Join(location, address), nil
+	}
+	return "", fmt.Errorf("cannot resolve relative URL against '%s'", base)
+}
@@ -0,0 +1,47 @@
+package repository
+
+import "testing"
+
+func TestResolveSubmoduleURL(t *testing.T) {
+	for _, test := range []struct {
+		base     string
+		url      string
+		expected string
+	}{
+		{"https://github.com/example/archetype.git", "../shared-ci.git", "https://github.com/example/shared-ci.git"},
+		{"https://github.com/example/archetype.git", "./modules/ci.git", "https://github.com/example/archetype.git/modules/ci.git"},
+		{"git@github.com:example/archetype.git", "../shared-ci.git", "git@github.com:example/shared-ci.git"},
+		{"file:///srv/git/archetype", "../shared-ci", "file:///srv/git/shared-ci"},
+		{"https://github.com/example/archetype.git", "https://gitlab.com/example/ci.git", "https://gitlab.com/example/ci.git"},
+		{"https://github.com/example/archetype.git", "git@gitlab.com:example/ci.git", "git@gitlab.com:example/ci.git"},
+	} {
+		got, err := resolveSubmoduleURL(test.base, test.url)
+		if err != nil {
+			t.Errorf("resolveSubmoduleURL(%q, %q): unexpected error %v", test.base, test.url, err)
+		} else if got != test.expected {
+			t.Errorf("resolveSubmoduleURL(%q, %q): expected %q, got %q", test.base, test.url, test.expected, got)
+		}
+	}
+}
+
+func TestSharesCredentials(t *testing.T) {
+	for _, test := range []struct {
+		address   string
+		submodule string
+		expected  bool
+	}{
+		{"https://github.com/example/archetype.git", "https://github.com/example/shared-ci.git", true},
+		{"https://github.com/example/archetype.git", "https://GitHub.com/other/ci.git", true},
+		{"git@github.com:example/archetype.git", "git@github.com:example/shared-ci.git", true},
+		{"git@github.com:example/archetype.git", "ssh://git@github.com/example/shared-ci.git", true},
+		{"https://github.com/example/archetype.git", "https://gitlab.com/example/ci.git", false},
+		{"https://github.com/example/archetype.git", "https://github.com.attacker.example/ci.git", false},
+		{"git@github.com:example/archetype.git", "git@gitlab.com:example/ci.git", false},
+		{"https://github.com/example/archetype.git", "git@github.com:example/ci.git", false},
+		{"git@github.com:example/archetype.git", "https://github.com/example/ci.git", false},
+	} {
+		if got := sharesCredentials(test.address, test.submodule); got != test.expected {
+			t.Errorf("sharesCredentials(%q, %q): expected %v, got %v", test.address, test.submodule, test.expected, got)
+		}
+	}
+}
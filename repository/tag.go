@@ -3,10 +3,16 @@ package repository
 import (
 	"errors"
 	"log/slog"
+	"strings"
 
 	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
 )
 
+// ErrLightweightTag is returned when the tag object of a lightweight tag is
+// requested: lightweight tags point directly to a commit.
+var ErrLightweightTag = errors.New("lightweight tag has no tag object")
+
 // Tag returns the reference to the given tag.
 func (r *Repository) Tag(name string) (*plumbing.Reference, error) {
 	if r == nil || r.repository == nil {
@@ -21,6 +27,42 @@ func (r *Repository) Tag(name string) (*plumbing.Reference, error) {
 	return reference, nil
 }
 
+// TagObject returns the annotated tag object the given tag reference points
+// to, with its tagger, date, message and signature; it returns
+// ErrLightweightTag if the reference points directly to a commit.
+func (r *Repository) TagObject(reference *plumbing.Reference) (*object.Tag, error) {
+	if r == nil || r.repository == nil {
+		slog.Error("repository not initialized")
+		return nil, errors.New("repository not initialized")
+	}
+	tag, err := r.repository.TagObject(reference.Hash())
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		slog.Debug("tag is lightweight", "name", reference.Name())
+		return nil, ErrLightweightTag
+	} else if err != nil {
+		slog.Error("failed to get tag object", "name", reference.Name(), "error", err)
+		return nil, err
+	}
+	return tag, nil
+}
+
+// SignatureFormat returns the format of the given signature, as found in an
+// annotated tag or in a commit: "openpgp", "x509", "ssh", or an empty string
+// if the object is not signed.
+func SignatureFormat(signature string) string {
+	switch {
+	case signature == "":
+		return ""
+	case strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----"), strings.HasPrefix(signature, "-----BEGIN PGP MESSAGE-----"):
+		return "openpgp"
+	case strings.HasPrefix(signature, "-----BEGIN CERTIFICATE-----"), strings.HasPrefix(signature, "-----BEGIN SIGNED MESSAGE-----"):
+		return "x509"
+	case strings.HasPrefix(signature, "-----BEGIN SSH SIGNATURE-----"):
+		return "ssh"
+	}
+	return "unknown"
+}
+
 // Tags returns all the tags in the repository.
 func (r *Repository) Tags() ([]*plumbing.Reference, error) {
 	var tags []*plumbing.Reference
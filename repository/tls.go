@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v6/plumbing/protocol"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+	"github.com/go-git/go-git/v6/storage"
+)
+
+// TLS is the TLS configuration for HTTPS repositories and requests, on top of
+// the system defaults.
+type TLS struct {
+	// CABundle holds the PEM certificates of additional trusted authorities,
+	// e.g. a private CA, which are trusted along with the system ones.
+	CABundle []byte
+	// Certificates are the client certificates presented to servers requiring
+	// mutual TLS.
+	Certificates []tls.Certificate
+	// InsecureSkipVerify disables the verification of server certificates.
+	InsecureSkipVerify bool
+}
+
+// LoadTLS reads the given CA bundle and client certificate and key files, all
+// optional, into a TLS configuration; it returns nil if there is nothing to
+// configure.
+func LoadTLS(caBundle string, clientCert string, clientKey string, insecure bool) (*TLS, error) {
+	if caBundle == "" && clientCert == "" && clientKey == "" && !insecure {
+		return nil, nil
+	}
+	settings := &TLS{InsecureSkipVerify: insecure}
+	if caBundle != "" {
+		data, err := os.ReadFile(caBundle)
+		if err != nil {
+			slog.Error("failed to read CA bundle", "path", caBundle, "error", err)
+			return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			slog.Error("no certificates in CA bundle", "path", caBundle)
+			return nil, fmt.Errorf("no PEM certificates in CA bundle '%s'", caBundle)
+		}
+		settings.CABundle = data
+	}
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			slog.Error("client certificate and key must be given together", "certificate", clientCert, "key", clientKey)
+			return nil, errors.New("client certificate and key must be given together")
+		}
+		certificate, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			slog.Error("failed to load client certificate", "certificate", clientCert, "key", clientKey, "error", err)
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		settings.Certificates = []tls.Certificate{certificate}
+	}
+	return settings, nil
+}
+
+// WithTLS configures the Repository to use the given TLS configuration, if not
+// nil, for HTTPS.
+func WithTLS(settings *TLS) Option {
+	return func(repository *Repository) error {
+		if settings != nil && settings.InsecureSkipVerify {
+			slog.Warn("TLS certificate verification disabled")
+		}
+		repository.tls = settings
+		return nil
+	}
+}
+
+// Config returns the TLS client configuration for net/http, trusting the
+// system authorities and those in the CA bundle.
+func (t *TLS) Config() *tls.Config {
+	config := &tls.Config{
+		Certificates:       t.Certificates,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if len(t.CABundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			slog.Debug("system certificate pool not available", "error", err)
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(t.CABundle)
+		config.RootCAs = pool
+	}
+	return config
+}
+
+// install makes the git HTTPS transport present the client certificates, if
+// any, to the host of the given address, and to no other: go-git has no
+// per-operation option for them, unlike for the CA bundle and the certificate
+// verification, so the process-wide transport is replaced, once, with one
+// choosing the client configuration by host.
+func (t *TLS) install(address string) {
+	if len(t.Certificates) == 0 {
+		return
+	}
+	location, err := url.Parse(address)
+	if err != nil || location.Scheme != "https" {
+		return
+	}
+	client := http.DefaultTransport.(*http.Transport).Clone()
+	client.TLSClientConfig = t.Config()
+	slog.Debug("using client certificate for git over HTTPS", "host", location.Host)
+	clientCertificates.set(endpointHost(location), githttp.NewTransport(&githttp.TransportOptions{
+		Client: &http.Client{Transport: client},
+	}))
+}
+
+// clientCertificates is the git HTTPS transport of the process once any
+// client certificate has been installed.
+var clientCertificates = &hostTransports{}
+
+// hostTransports is a git transport delegating to the transport configured
+// for the host of each endpoint, if any, or else to the one it replaced.
+type hostTransports struct {
+	lock     sync.RWMutex
+	once     sync.Once
+	fallback transport.Transport
+	hosts    map[string]transport.Transport
+}
+
+// set configures the transport for the given host, registering the
+// hostTransports for HTTPS the first time.
+func (h *hostTransports) set(host string, configured transport.Transport) {
+	h.once.Do(func() {
+		fallback, err := transport.Get("https")
+		if err != nil {
+			fallback = githttp.DefaultTransport
+		}
+		h.lock.Lock()
+		h.fallback, h.hosts = fallback, map[string]transport.Transport{}
+		h.lock.Unlock()
+		transport.Register("https", h)
+	})
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hosts[host] = configured
+}
+
+// get returns the transport for the given endpoint.
+func (h *hostTransports) get(endpoint *transport.Endpoint) transport.Transport {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if configured, ok := h.hosts[endpointHost(&endpoint.URL)]; ok {
+		return configured
+	}
+	return h.fallback
+}
+
+func (h *hostTransports) NewSession(storer storage.Storer, endpoint *transport.Endpoint, auth transport.AuthMethod) (transport.Session, error) {
+	return h.get(endpoint).NewSession(storer, endpoint, auth)
+}
+
+func (h *hostTransports) SupportedProtocols() []protocol.Version {
+	return h.fallback.SupportedProtocols()
+}
+
+// endpointHost returns the lower-case host of the given HTTPS location, with
+// its port unless it is the default one.
+func endpointHost(location *url.URL) string {
+	if port := location.Port(); port != "" && port != "443" {
+		return strings.ToLower(location.Hostname()) + ":" + port
+	}
+	return strings.ToLower(location.Hostname())
+}
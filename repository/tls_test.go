@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+)
+
+// gitServer serves a repository with a single commit over HTTPS with the dumb
+// protocol, requiring client certificates signed by the given authority, if
+// any, and returns the server and the PEM file of its certificate.
+func gitServer(t *testing.T, clientCA *x509.Certificate) (*httptest.Server, string) {
+	t.Helper()
+	directory := t.TempDir()
+	repository, err := git.PlainInit(directory, false)
+	if err != nil {
+		t.Fatalf("cannot initialise repository: %v", err)
+	}
+	worktree, _ := repository.Worktree()
+	if err := os.WriteFile(filepath.Join(directory, "README.md"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("cannot write file: %v", err)
+	}
+	worktree.Add("README.md")
+	hash, err := worktree.Commit("initial", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("cannot commit: %v", err)
+	}
+	head, _ := repository.Head()
+	refs := fmt.Sprintf("%s\t%s\n", hash, head.Name())
+	// what `git update-server-info` would write, all objects being loose
+	os.MkdirAll(filepath.Join(directory, ".git", "info"), 0755)
+	os.MkdirAll(filepath.Join(directory, ".git", "objects", "info"), 0755)
+	if err := os.WriteFile(filepath.Join(directory, ".git", "info", "refs"), []byte(refs), 0644); err != nil {
+		t.Fatalf("cannot write info/refs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, ".git", "objects", "info", "packs"), nil, 0644); err != nil {
+		t.Fatalf("cannot write objects/info/packs: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.StripPrefix("/archetype.git", http.FileServer(http.Dir(filepath.Join(directory, ".git")))))
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA)
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, data, 0644); err != nil {
+		t.Fatalf("cannot write CA bundle: %v", err)
+	}
+	return server, bundle
+}
+
+// writeClientCert writes a self-signed client certificate and its key, and
+// returns their paths and the certificate.
+func writeClientCert(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "archetype"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	private, _ := x509.MarshalECPrivateKey(key)
+	directory := t.TempDir()
+	certPath := filepath.Join(directory, "client.pem")
+	keyPath := filepath.Join(directory, "client-key.pem")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: private}), 0600)
+	return certPath, keyPath, certificate
+}
+
+// cloneWithTLS fetches the repository on the server into a new clone cache
+// (the dumb protocol cannot clone into memory) with the given TLS
+// configuration.
+func cloneWithTLS(t *testing.T, server *httptest.Server, settings *TLS) error {
+	t.Helper()
+	cache, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("cannot create cache: %v", err)
+	}
+	_, err = New(context.Background(), server.URL+"/archetype.git", WithCache(cache), WithTLS(settings))
+	return err
+}
+
+func TestTLS(t *testing.T) {
+	clearProxyEnv(t)
+	server, bundle := gitServer(t, nil)
+
+	if err := cloneWithTLS(t, server, nil); err == nil {
+		t.Fatalf("expected error for untrusted server certificate")
+	}
+	settings, err := LoadTLS(bundle, "", "", false)
+	if err != nil {
+		t.Fatalf("cannot load CA bundle: %v", err)
+	}
+	if err := cloneWithTLS(t, server, settings); err != nil {
+		t.Fatalf("cannot clone with CA bundle: %v", err)
+	}
+	settings, _ = LoadTLS("", "", "", true)
+	if err := cloneWithTLS(t, server, settings); err != nil {
+		t.Fatalf("cannot clone skipping verification: %v", err)
+	}
+
+	// the same configuration applies to plain HTTP requests, e.g. for the api
+	// template function
+	settings, _ = LoadTLS(bundle, "", "", false)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: settings.Config()}}
+	response, err := client.Get(server.URL + "/archetype.git/HEAD")
+	if err != nil {
+		t.Fatalf("cannot request with CA bundle: %v", err)
+	}
+	response.Body.Close()
+}
+
+func TestClientCertificate(t *testing.T) {
+	clearProxyEnv(t)
+	certPath, keyPath, certificate := writeClientCert(t)
+	server, bundle := gitServer(t, certificate)
+
+	settings, _ := LoadTLS(bundle, "", "", false)
+	if err := cloneWithTLS(t, server, settings); err == nil {
+		t.Fatalf("expected error without client certificate")
+	}
+	settings, err := LoadTLS(bundle, certPath, keyPath, false)
+	if err != nil {
+		t.Fatalf("cannot load client certificate: %v", err)
+	}
+	if err := cloneWithTLS(t, server, settings); err != nil {
+		t.Fatalf("cannot clone with client certificate: %v", err)
+	}
+
+	// the certificate is only presented to the host it was configured for,
+	// even if another one would accept it
+	other, otherBundle := gitServer(t, certificate)
+	settings, _ = LoadTLS(otherBundle, "", "", false)
+	if err := cloneWithTLS(t, other, settings); err == nil {
+		t.Fatalf("expected error with client certificate configured for another host")
+	}
+	if registered, err := transport.Get("https"); err != nil || registered != clientCertificates {
+		t.Fatalf("expected the client certificate transport to be registered, got %T (%v)", registered, err)
+	}
+	if clientCertificates.get(&transport.Endpoint{URL: url.URL{Scheme: "https", Host: "example.com"}}) != githttp.DefaultTransport {
+		t.Errorf("expected the default transport for other hosts")
+	}
+
+	if _, err := LoadTLS("", certPath, "", false); err == nil {
+		t.Fatalf("expected error for certificate without key")
+	}
+	if _, err := LoadTLS(keyPath, "", "", false); err == nil {
+		t.Fatalf("expected error for CA bundle without certificates")
+	}
+}
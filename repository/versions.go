@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// Version is a tag or branch of the repository, i.e. a version of the
+// archetypes in it that can be selected with Resolve.
+type Version struct {
+	// Name is the short name of the tag or branch.
+	Name string `json:"name"`
+	// Kind is either "tag" or "branch".
+	Kind string `json:"kind"`
+	// SemVer is the semantic version the tag is named after, if any.
+	SemVer *semver.Version `json:"semver,omitempty"`
+	// Default is set for the default branch, the one HEAD points to.
+	Default bool `json:"default,omitempty"`
+	// Commit is the hash of the commit the tag or branch points to.
+	Commit string `json:"commit"`
+	// Date is the date of the commit.
+	Date time.Time `json:"date"`
+	// Author is the author of the commit, as name <email>.
+	Author string `json:"author"`
+	// Annotated is set for annotated tags, whose message is in Message.
+	Annotated bool   `json:"annotated,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+const (
+	// TagVersion and BranchVersion are the kinds of Version.
+	TagVersion    = "tag"
+	BranchVersion = "branch"
+)
+
+// Versions returns the tags and the branches of the repository: first the
+// tags named after a semantic version, from the highest to the lowest, then
+// the other tags by name, then the default branch and the other branches by
+// name. Remote-tracking branches, as found in clones, are listed as branches.
+func (r *Repository) Versions() ([]*Version, error) {
+	if r == nil || r.repository == nil {
+		slog.Error("repository not initialized")
+		return nil, errors.New("repository not initialized")
+	}
+	head := plumbing.ReferenceName("")
+	if reference, err := r.repository.Reference(plumbing.HEAD, false); err == nil && reference.Type() == plumbing.SymbolicReference {
+		head = reference.Target()
+	}
+
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+	result := []*Version{}
+	for _, reference := range tags {
+		version, err := r.version(reference, reference.Name().Short(), TagVersion)
+		if err != nil {
+			return nil, err
+		}
+		if tag, err := r.TagObject(reference); err == nil {
+			version.Annotated = true
+			version.Message = strings.TrimSpace(tag.Message)
+		} else if !errors.Is(err, ErrLightweightTag) {
+			return nil, err
+		}
+		if parsed, err := semver.NewVersion(version.Name); err == nil {
+			version.SemVer = parsed
+		}
+		result = append(result, version)
+	}
+
+	references, err := r.repository.References()
+	if err != nil {
+		slog.Error("failed to get references", "error", err)
+		return nil, err
+	}
+	branches := map[string]*Version{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		name := reference.Name()
+		prefix := "refs/remotes/" + git.DefaultRemoteName + "/"
+		switch {
+		case name.IsBranch():
+		case strings.HasPrefix(name.String(), prefix) && name.String() != prefix+"HEAD":
+			if _, ok := branches[strings.TrimPrefix(name.String(), prefix)]; ok {
+				return nil
+			}
+		default:
+			return nil
+		}
+		if reference.Type() != plumbing.HashReference {
+			return nil
+		}
+		short := strings.TrimPrefix(strings.TrimPrefix(name.String(), prefix), "refs/heads/")
+		version, err := r.version(reference, short, BranchVersion)
+		if err != nil {
+			return err
+		}
+		version.Default = head != "" && short == head.Short()
+		branches[short] = version
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range branches {
+		result = append(result, version)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Kind != b.Kind {
+			return a.Kind == TagVersion
+		}
+		if a.Kind == BranchVersion && a.Default != b.Default {
+			return a.Default
+		}
+		if (a.SemVer != nil) != (b.SemVer != nil) {
+			return a.SemVer != nil
+		}
+		if a.SemVer != nil && !a.SemVer.Equal(b.SemVer) {
+			return a.SemVer.GreaterThan(b.SemVer)
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+// version describes the commit the given reference points to.
+func (r *Repository) version(reference *plumbing.Reference, name string, kind string) (*Version, error) {
+	commit, err := r.CommitFromReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	return &Version{
+		Name:   name,
+		Kind:   kind,
+		Commit: commit.Hash.String(),
+		Date:   commit.Author.When,
+		Author: commit.Author.Name + " <" + commit.Author.Email + ">",
+	}, nil
+}
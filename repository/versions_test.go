@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+func TestVersions(t *testing.T) {
+	directory := t.TempDir()
+	origin, err := git.PlainInit(directory, false)
+	if err != nil {
+		t.Fatalf("cannot initialise repository: %v", err)
+	}
+	commitAndTag(t, origin, directory, "hello")
+	head, _ := origin.Head()
+	tagger := &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	for _, name := range []string{"v1.10.0", "v1.2.0", "v2.0.0-rc.1", "legacy"} {
+		var options *git.CreateTagOptions
+		if name == "v1.10.0" {
+			options = &git.CreateTagOptions{Tagger: tagger, Message: "Release 1.10.0\n\nRelease notes.\n"}
+		}
+		if _, err := origin.CreateTag(name, head.Hash(), options); err != nil {
+			t.Fatalf("cannot create tag %s: %v", name, err)
+		}
+	}
+	if err := origin.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("develop"), head.Hash())); err != nil {
+		t.Fatalf("cannot create branch: %v", err)
+	}
+
+	repository, err := New(context.Background(), directory)
+	if err != nil {
+		t.Fatalf("cannot open repository: %v", err)
+	}
+	versions, err := repository.Versions()
+	if err != nil {
+		t.Fatalf("cannot list versions: %v", err)
+	}
+	expected := []string{"v2.0.0-rc.1", "v1.10.0", "v1.2.0", "v1.0.0", "legacy", head.Name().Short(), "develop"}
+	if len(versions) != len(expected) {
+		t.Fatalf("unexpected number of versions: expected %d, got %d", len(expected), len(versions))
+	}
+	for i, version := range versions {
+		if version.Name != expected[i] {
+			t.Fatalf("unexpected version at %d: expected %s, got %s", i, expected[i], version.Name)
+		}
+		if version.Commit != head.Hash().String() || version.Author != "Test <test@example.com>" {
+			t.Fatalf("unexpected commit for %s: %s by %s", version.Name, version.Commit, version.Author)
+		}
+		if version.Default != (version.Kind == BranchVersion && version.Name == head.Name().Short()) {
+			t.Fatalf("unexpected default flag for %s", version.Name)
+		}
+		if version.Annotated != (version.Name == "v1.10.0") {
+			t.Fatalf("unexpected annotation flag for %s", version.Name)
+		}
+	}
+	if versions[1].Message != "Release 1.10.0\n\nRelease notes." {
+		t.Fatalf("unexpected tag message: %q", versions[1].Message)
+	}
+	if versions[4].SemVer != nil {
+		t.Fatalf("unexpected semantic version for tag legacy: %s", versions[4].SemVer)
+	}
+}
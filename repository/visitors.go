@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
@@ -31,13 +32,13 @@ func DefaultCommitVisitor(commit *object.Commit) error {
 }
 
 // FileVisitor is the signature of a function that can be used to visit
-// a Git file.
-type FileVisitor func(file *object.File) error
+// a file in an archetype source; it should stop when the context is done.
+type FileVisitor func(ctx context.Context, file File) error
 
 // DefaultFileVisitor is a sample implementation of the FileVisitor that
 // simply logs the file details.
-func DefaultFileVisitor(file *object.File) error {
-	slog.Info("visiting file", "type", file.Type().String(), "name", file.Name, "size", file.Size, "hash", file.Hash.String())
-	fmt.Printf("%v  %9d  %s    %s\n", file.Mode, file.Size, file.Hash.String(), file.Name)
+func DefaultFileVisitor(ctx context.Context, file File) error {
+	slog.Info("visiting file", "name", file.Name(), "mode", file.Mode(), "size", file.Size(), "hash", file.Hash().String())
+	fmt.Printf("%v  %9d  %s    %s\n", file.Mode(), file.Size(), file.Hash().String(), file.Name())
 	return nil
 }
@@ -0,0 +1,61 @@
+package settings
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ParameterChange describes how a parameter changed between two versions of
+// the archetype metadata.
+type ParameterChange struct {
+	// Name is the name of the parameter.
+	Name string `json:"name"`
+	// Action is either "added", "removed" or "changed".
+	Action string `json:"action"`
+	// Fields are the fields of a changed parameter that differ, among "type",
+	// "default" and "description".
+	Fields []string `json:"fields,omitempty"`
+	// Before and After are the parameter in the older and the newer metadata.
+	Before *Parameter `json:"before,omitempty"`
+	After  *Parameter `json:"after,omitempty"`
+}
+
+// CompareMetadata returns the parameters added, removed or changed from the
+// older to the newer metadata, sorted by name; either may be nil.
+func CompareMetadata(older *Metadata, newer *Metadata) []ParameterChange {
+	if older == nil {
+		older = &Metadata{}
+	}
+	if newer == nil {
+		newer = &Metadata{}
+	}
+	changes := []ParameterChange{}
+	for name, before := range older.Parameters {
+		if after, ok := newer.Parameters[name]; !ok {
+			changes = append(changes, ParameterChange{Name: name, Action: "removed", Before: &before})
+		} else {
+			fields := []string{}
+			if before.Type != after.Type {
+				fields = append(fields, "type")
+			}
+			if !reflect.DeepEqual(before.Default, after.Default) {
+				fields = append(fields, "default")
+			}
+			if before.Description != after.Description {
+				fields = append(fields, "description")
+			}
+			if len(fields) > 0 {
+				changes = append(changes, ParameterChange{Name: name, Action: "changed", Fields: fields, Before: &before, After: &after})
+			}
+		}
+	}
+	for name, after := range newer.Parameters {
+		if _, ok := older.Parameters[name]; !ok {
+			changes = append(changes, ParameterChange{Name: name, Action: "added", After: &after})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}